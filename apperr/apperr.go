@@ -0,0 +1,73 @@
+// Package apperr defines the domain errors shared by the repos, services and
+// HTTP handlers. Handlers do not render errors themselves: they return them
+// and the central error handler maps each kind to a status code.
+package apperr
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrNotFound means the requested record does not exist or is not
+	// visible to the caller.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the request clashes with the current state, e.g. a
+	// duplicate of a unique value.
+	ErrConflict = errors.New("conflict")
+	// ErrForbidden means the caller is known but not allowed to do this.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// FieldError describes what is wrong with a single input field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every invalid field of a request so that clients
+// can report them all at once.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Add records a problem with field.
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// Err returns e if any field was invalid and nil otherwise.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return "invalid input: " + strings.Join(messages, "; ")
+}
+
+// Invalid returns a validation error for a single field.
+func Invalid(field, message string) error {
+	return &ValidationError{Fields: []FieldError{{Field: field, Message: message}}}
+}
+
+// New returns an error with the given message that matches kind, one of the
+// sentinels above, under errors.Is.
+func New(kind error, message string) error {
+	return &kindError{kind: kind, message: message}
+}
+
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (e *kindError) Error() string { return e.message }
+
+func (e *kindError) Unwrap() error { return e.kind }
@@ -0,0 +1,278 @@
+// Package backup writes and restores a user's complete account data as a
+// versioned zip archive: a JSON manifest plus one file per piece of content.
+package backup
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"snippetier/db/repo"
+	"time"
+)
+
+// Version is the manifest format written by this build. Read accepts every
+// version up to and including it. Version 2 added stars and version 3
+// comments.
+const Version = 3
+
+const manifestName = "manifest.json"
+
+const (
+	// maxContentSize caps a single content file read back from an archive.
+	maxContentSize = repo.MaxContentBytes
+	// maxManifestSize caps the manifest, which lists every snippet and
+	// revision of the account.
+	maxManifestSize = 64 << 20
+)
+
+// Account is everything exported for one user.
+type Account struct {
+	Profile  repo.User
+	Snippets []Snippet
+	// Starred lists the IDs of the snippets the user starred.
+	Starred []int
+	// Comments lists the comments the user wrote that are still shown,
+	// oldest first, on any snippet.
+	Comments []repo.Comment
+}
+
+// Snippet is a snippet together with its content history.
+type Snippet struct {
+	repo.Snippet
+	Revisions []repo.Revision
+}
+
+type manifest struct {
+	Version    int               `json:"version"`
+	ExportedAt string            `json:"exportedAt"`
+	Profile    repo.User         `json:"profile"`
+	Snippets   []manifestSnippet `json:"snippets"`
+	Starred    []int             `json:"starred,omitempty"`
+	Comments   []manifestComment `json:"comments,omitempty"`
+}
+
+type manifestSnippet struct {
+	ID            int                `json:"id"`
+	Name          string             `json:"name"`
+	Description   string             `json:"description"`
+	Language      string             `json:"language"`
+	Tags          []string           `json:"tags"`
+	Revision      int                `json:"revision"`
+	ExpiresAt     *string            `json:"expiresAt,omitempty"`
+	BurnAfterRead bool               `json:"burnAfterRead"`
+	CreatedAt     string             `json:"createdAt"`
+	UpdatedAt     string             `json:"updatedAt"`
+	ContentFile   string             `json:"contentFile"`
+	Revisions     []manifestRevision `json:"revisions"`
+}
+
+type manifestRevision struct {
+	Revision    int    `json:"revision"`
+	AuthorID    int    `json:"authorId"`
+	CreatedAt   string `json:"createdAt"`
+	ContentFile string `json:"contentFile"`
+}
+
+type manifestComment struct {
+	ID        int          `json:"id"`
+	SnippetID int          `json:"snippetId"`
+	ParentID  *int         `json:"parentId,omitempty"`
+	AuthorID  int          `json:"authorId"`
+	Body      string       `json:"body"`
+	Anchor    *repo.Anchor `json:"anchor,omitempty"`
+	CreatedAt string       `json:"createdAt"`
+	UpdatedAt string       `json:"updatedAt"`
+}
+
+// ErrUnsupportedVersion is returned for archives written by a newer build.
+var ErrUnsupportedVersion = errors.New("unsupported backup version")
+
+// Write streams account to w as a zip archive. Content files are written
+// first so that the archive can be produced without buffering.
+func Write(w io.Writer, account Account) error {
+	zw := zip.NewWriter(w)
+
+	m := manifest{
+		Version:    Version,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Profile:    account.Profile,
+		Snippets:   make([]manifestSnippet, 0, len(account.Snippets)),
+		Starred:    account.Starred,
+	}
+	for _, c := range account.Comments {
+		m.Comments = append(m.Comments, manifestComment{
+			ID:        c.ID,
+			SnippetID: c.SnippetID,
+			ParentID:  c.ParentID,
+			AuthorID:  c.AuthorID,
+			Body:      c.Body,
+			Anchor:    c.Anchor,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+		})
+	}
+
+	for _, s := range account.Snippets {
+		ms := manifestSnippet{
+			ID:            s.ID,
+			Name:          s.Name,
+			Description:   s.Description,
+			Language:      s.Language,
+			Tags:          s.Tags,
+			Revision:      s.Revision,
+			ExpiresAt:     s.ExpiresAt,
+			BurnAfterRead: s.BurnAfterRead,
+			CreatedAt:     s.CreatedAt,
+			UpdatedAt:     s.UpdatedAt,
+			ContentFile:   fmt.Sprintf("snippets/%d/content", s.ID),
+			Revisions:     make([]manifestRevision, 0, len(s.Revisions)),
+		}
+		if err := writeFile(zw, ms.ContentFile, s.Content); err != nil {
+			return err
+		}
+
+		for _, rev := range s.Revisions {
+			mr := manifestRevision{
+				Revision:    rev.Revision,
+				AuthorID:    rev.AuthorID,
+				CreatedAt:   rev.CreatedAt,
+				ContentFile: fmt.Sprintf("snippets/%d/revisions/%d", s.ID, rev.Revision),
+			}
+			if err := writeFile(zw, mr.ContentFile, rev.Content); err != nil {
+				return err
+			}
+			ms.Revisions = append(ms.Revisions, mr)
+		}
+
+		m.Snippets = append(m.Snippets, ms)
+	}
+
+	f, err := zw.Create(manifestName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// Read parses an archive written by Write.
+func Read(r io.ReaderAt, size int64) (Account, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return Account{}, fmt.Errorf("invalid backup archive: %v", err)
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	manifestFile, ok := files[manifestName]
+	if !ok {
+		return Account{}, errors.New("invalid backup archive: missing manifest")
+	}
+	data, err := readFile(manifestFile, maxManifestSize)
+	if err != nil {
+		return Account{}, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return Account{}, fmt.Errorf("invalid backup manifest: %v", err)
+	}
+	if m.Version < 1 || m.Version > Version {
+		return Account{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, m.Version)
+	}
+
+	account := Account{Profile: m.Profile, Starred: m.Starred}
+	for _, mc := range m.Comments {
+		account.Comments = append(account.Comments, repo.Comment{
+			ID:        mc.ID,
+			SnippetID: mc.SnippetID,
+			ParentID:  mc.ParentID,
+			AuthorID:  mc.AuthorID,
+			Body:      mc.Body,
+			Anchor:    mc.Anchor,
+			CreatedAt: mc.CreatedAt,
+			UpdatedAt: mc.UpdatedAt,
+		})
+	}
+	for _, ms := range m.Snippets {
+		content, err := readNamed(files, ms.ContentFile)
+		if err != nil {
+			return Account{}, err
+		}
+
+		s := Snippet{Snippet: repo.Snippet{
+			ID:            ms.ID,
+			Name:          ms.Name,
+			Description:   ms.Description,
+			Content:       content,
+			Language:      ms.Language,
+			Tags:          ms.Tags,
+			Revision:      ms.Revision,
+			ExpiresAt:     ms.ExpiresAt,
+			BurnAfterRead: ms.BurnAfterRead,
+			CreatedAt:     ms.CreatedAt,
+			UpdatedAt:     ms.UpdatedAt,
+		}}
+		for _, mr := range ms.Revisions {
+			revContent, err := readNamed(files, mr.ContentFile)
+			if err != nil {
+				return Account{}, err
+			}
+			s.Revisions = append(s.Revisions, repo.Revision{
+				SnippetID: ms.ID,
+				Revision:  mr.Revision,
+				AuthorID:  mr.AuthorID,
+				Content:   revContent,
+				CreatedAt: mr.CreatedAt,
+			})
+		}
+		account.Snippets = append(account.Snippets, s)
+	}
+
+	return account, nil
+}
+
+func writeFile(zw *zip.Writer, name, content string) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, content)
+	return err
+}
+
+func readNamed(files map[string]*zip.File, name string) (string, error) {
+	f, ok := files[name]
+	if !ok {
+		return "", fmt.Errorf("invalid backup archive: missing %s", name)
+	}
+	return readFile(f, maxContentSize)
+}
+
+// readFile reads a file of the archive, failing if it is larger than limit.
+func readFile(f *zip.File, limit int) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, int64(limit)+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > limit {
+		return "", fmt.Errorf("invalid backup archive: %s is too large", f.Name)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"bytes"
+	"snippetier/db/repo"
+	"strings"
+	"testing"
+)
+
+func roundTrip(t *testing.T, account Account) Account {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, account); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return read
+}
+
+func TestReadLargeManifest(t *testing.T) {
+	account := Account{Profile: repo.User{ID: 1, Username: "owner", Email: "owner@example.com"}}
+	description := strings.Repeat("d", 500)
+	for i := 1; i <= 3000; i++ {
+		account.Snippets = append(account.Snippets, Snippet{Snippet: repo.Snippet{ID: i, Name: "s", Description: description, Content: "echo hello"}})
+	}
+
+	read := roundTrip(t, account)
+	if len(read.Snippets) != len(account.Snippets) {
+		t.Errorf("read %d snippets, want %d", len(read.Snippets), len(account.Snippets))
+	}
+}
+
+func TestCommentsRoundTrip(t *testing.T) {
+	parent := 10
+	account := Account{
+		Profile:  repo.User{ID: 1, Username: "owner", Email: "owner@example.com"},
+		Snippets: []Snippet{{Snippet: repo.Snippet{ID: 5, Name: "s", Content: "a\nb\n", Revision: 2}}},
+		Comments: []repo.Comment{
+			{ID: 10, SnippetID: 5, AuthorID: 1, Body: "root", Anchor: &repo.Anchor{Revision: 2, LineStart: 1, LineEnd: 2}, CreatedAt: "2026-01-02T03:04:05Z", UpdatedAt: "2026-01-02T03:04:05Z"},
+			{ID: 11, SnippetID: 5, ParentID: &parent, AuthorID: 1, Body: "reply", CreatedAt: "2026-01-03T03:04:05Z", UpdatedAt: "2026-01-03T03:04:05Z"},
+		},
+	}
+
+	read := roundTrip(t, account)
+	if len(read.Comments) != 2 {
+		t.Fatalf("read %d comments, want 2", len(read.Comments))
+	}
+	root, reply := read.Comments[0], read.Comments[1]
+	if root.ID != 10 || root.SnippetID != 5 || root.AuthorID != 1 || root.Body != "root" || root.Anchor == nil || *root.Anchor != *account.Comments[0].Anchor || root.CreatedAt != account.Comments[0].CreatedAt {
+		t.Errorf("root comment = %+v, want %+v", root, account.Comments[0])
+	}
+	if reply.ParentID == nil || *reply.ParentID != 10 || reply.Anchor != nil || reply.Body != "reply" {
+		t.Errorf("reply = %+v, want a reply to 10", reply)
+	}
+}
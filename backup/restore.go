@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"snippetier/apperr"
+	"snippetier/db"
+	"snippetier/db/repo"
+)
+
+// Collect gathers everything a user owns for export.
+func Collect(ctx context.Context, storage *db.Storage, userID int) (Account, error) {
+	profile, err := storage.UsersRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return Account{}, err
+	}
+
+	snippets, err := storage.SnippetsRepo.GetSnippetsByUser(ctx, userID)
+	if err != nil {
+		return Account{}, err
+	}
+
+	starred, err := storage.SnippetsRepo.GetStarredIDs(ctx, userID)
+	if err != nil {
+		return Account{}, err
+	}
+
+	comments, err := storage.CommentsRepo.GetCommentsByAuthor(ctx, userID)
+	if err != nil {
+		return Account{}, err
+	}
+
+	account := Account{Profile: profile, Starred: starred, Comments: comments}
+	for _, s := range snippets {
+		revisions, err := storage.SnippetsRepo.GetRevisions(ctx, s.ID)
+		if err != nil {
+			return Account{}, err
+		}
+		account.Snippets = append(account.Snippets, Snippet{Snippet: s, Revisions: revisions})
+	}
+	return account, nil
+}
+
+// ErrAccountExists is returned when restoring an account whose email is
+// already registered on this instance.
+var ErrAccountExists = apperr.New(apperr.ErrConflict, "an account with this email already exists")
+
+// Result maps the IDs in the archive to the IDs the restored records got.
+type Result struct {
+	UserID   int         `json:"userId"`
+	Snippets map[int]int `json:"snippets"`
+	Comments map[int]int `json:"comments"`
+}
+
+// Restore recreates an exported account in a single transaction, assigning
+// new IDs to the user, every snippet and every comment. Either everything is
+// restored or nothing is.
+func Restore(ctx context.Context, storage *db.Storage, account Account) (Result, error) {
+	var result Result
+
+	err := storage.WithTx(ctx, func(tx *db.Tx) error {
+		_, err := tx.UsersRepo.GetUserByEmail(ctx, account.Profile.Email)
+		if err == nil {
+			return ErrAccountExists
+		}
+		if !errors.Is(err, repo.ErrUserNotFound) {
+			return err
+		}
+
+		user, err := tx.UsersRepo.CreateUser(ctx, account.Profile.Username, account.Profile.Email, account.Profile.FullName)
+		if err != nil {
+			return err
+		}
+
+		result = Result{UserID: user.ID, Snippets: make(map[int]int, len(account.Snippets))}
+		for _, s := range account.Snippets {
+			restored, err := tx.SnippetsRepo.ImportSnippet(ctx, user.ID, s.Snippet, s.Revisions)
+			if err != nil {
+				return err
+			}
+			result.Snippets[s.ID] = restored.ID
+		}
+
+		// Only stars of snippets in the archive can be mapped to new IDs;
+		// other IDs may point at unrelated snippets on this instance.
+		for _, id := range account.Starred {
+			if newID, ok := result.Snippets[id]; ok {
+				if err := tx.SnippetsRepo.StarSnippet(ctx, user.ID, newID); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Likewise only comments on snippets in the archive are restored. A
+		// reply whose parent was not restored starts a thread of its own.
+		result.Comments = make(map[int]int, len(account.Comments))
+		for _, c := range account.Comments {
+			snippetID, ok := result.Snippets[c.SnippetID]
+			if !ok || c.AuthorID != account.Profile.ID {
+				continue
+			}
+			c.SnippetID, c.AuthorID = snippetID, user.ID
+			if c.ParentID != nil {
+				if parentID, ok := result.Comments[*c.ParentID]; ok {
+					c.ParentID = &parentID
+				} else {
+					c.ParentID = nil
+				}
+			}
+			restored, err := tx.CommentsRepo.ImportComment(ctx, c)
+			if err != nil {
+				return err
+			}
+			result.Comments[c.ID] = restored.ID
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result, nil
+}
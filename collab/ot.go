@@ -0,0 +1,287 @@
+// Package collab lets several users edit a snippet's content at once. Edits
+// are operational transforms: every client edits its own copy and sends
+// operations against the version it last saw; the server transforms them
+// over what happened since, applies them in one order and broadcasts the
+// result.
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ErrMismatch is returned when an operation does not fit the document or
+// operation it is applied to or transformed against.
+var ErrMismatch = errors.New("operation does not match the document length")
+
+// component is one step of an operation: retain or delete that many
+// characters, or insert text. Exactly one field is set.
+type component struct {
+	retain int
+	delete int
+	insert string
+}
+
+// Operation turns a document of BaseLen characters into one of TargetLen
+// characters. Lengths and positions count Unicode code points.
+//
+// Operations are sent as JSON arrays in which a positive number retains
+// characters, a negative number deletes them and a string inserts it, e.g.
+// [4, "abc", -2, 10].
+type Operation struct {
+	components []component
+	baseLen    int
+	targetLen  int
+}
+
+func (o *Operation) BaseLen() int   { return o.baseLen }
+func (o *Operation) TargetLen() int { return o.targetLen }
+
+// Retain skips over n characters.
+func (o *Operation) Retain(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLen += n
+	o.targetLen += n
+	if last := o.last(); last != nil && last.retain > 0 {
+		last.retain += n
+		return o
+	}
+	o.components = append(o.components, component{retain: n})
+	return o
+}
+
+// Insert inserts s at the current position.
+func (o *Operation) Insert(s string) *Operation {
+	if s == "" {
+		return o
+	}
+	o.targetLen += utf8.RuneCountInString(s)
+	last := o.last()
+	switch {
+	case last != nil && last.insert != "":
+		last.insert += s
+	case last != nil && last.delete > 0:
+		// Keep inserts before deletes so that equal operations have equal
+		// components.
+		if prev := o.at(len(o.components) - 2); prev != nil && prev.insert != "" {
+			prev.insert += s
+		} else {
+			o.components = append(o.components, component{})
+			copy(o.components[len(o.components)-1:], o.components[len(o.components)-2:])
+			o.components[len(o.components)-2] = component{insert: s}
+		}
+	default:
+		o.components = append(o.components, component{insert: s})
+	}
+	return o
+}
+
+// Delete removes n characters at the current position.
+func (o *Operation) Delete(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLen += n
+	if last := o.last(); last != nil && last.delete > 0 {
+		last.delete += n
+		return o
+	}
+	o.components = append(o.components, component{delete: n})
+	return o
+}
+
+func (o *Operation) last() *component {
+	return o.at(len(o.components) - 1)
+}
+
+func (o *Operation) at(i int) *component {
+	if i < 0 || i >= len(o.components) {
+		return nil
+	}
+	return &o.components[i]
+}
+
+// IsNoop reports whether the operation leaves every document unchanged.
+func (o *Operation) IsNoop() bool {
+	return len(o.components) == 0 || len(o.components) == 1 && o.components[0].retain > 0
+}
+
+// Apply applies the operation to doc.
+func (o *Operation) Apply(doc string) (string, error) {
+	runes := []rune(doc)
+	if len(runes) != o.baseLen {
+		return "", ErrMismatch
+	}
+	out := make([]rune, 0, o.targetLen)
+	pos := 0
+	for _, c := range o.components {
+		switch {
+		case c.retain > 0:
+			out = append(out, runes[pos:pos+c.retain]...)
+			pos += c.retain
+		case c.delete > 0:
+			pos += c.delete
+		default:
+			out = append(out, []rune(c.insert)...)
+		}
+	}
+	return string(out), nil
+}
+
+// Transform transforms two operations made concurrently on the same
+// document so that a then b' and b then a' give the same result. When both
+// insert at the same position, a's insert goes first.
+func Transform(a, b *Operation) (aPrime, bPrime *Operation, err error) {
+	if a.baseLen != b.baseLen {
+		return nil, nil, ErrMismatch
+	}
+	aPrime, bPrime = &Operation{}, &Operation{}
+	as, bs := a.components, b.components
+	var ca, cb component
+	next := func(list *[]component) component {
+		if len(*list) == 0 {
+			return component{}
+		}
+		c := (*list)[0]
+		*list = (*list)[1:]
+		return c
+	}
+	ca, cb = next(&as), next(&bs)
+	for !isZero(ca) || !isZero(cb) {
+		switch {
+		case ca.insert != "":
+			aPrime.Insert(ca.insert)
+			bPrime.Retain(utf8.RuneCountInString(ca.insert))
+			ca = next(&as)
+			continue
+		case cb.insert != "":
+			aPrime.Retain(utf8.RuneCountInString(cb.insert))
+			bPrime.Insert(cb.insert)
+			cb = next(&bs)
+			continue
+		case isZero(ca) || isZero(cb):
+			return nil, nil, ErrMismatch
+		}
+
+		n := min(ca.retain+ca.delete, cb.retain+cb.delete)
+		switch {
+		case ca.retain > 0 && cb.retain > 0:
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+		case ca.delete > 0 && cb.retain > 0:
+			aPrime.Delete(n)
+		case ca.retain > 0 && cb.delete > 0:
+			bPrime.Delete(n)
+		}
+		// When both delete the same characters, neither needs to.
+		ca, cb = shorten(ca, n), shorten(cb, n)
+		if isZero(ca) {
+			ca = next(&as)
+		}
+		if isZero(cb) {
+			cb = next(&bs)
+		}
+	}
+	return aPrime, bPrime, nil
+}
+
+func isZero(c component) bool {
+	return c.retain == 0 && c.delete == 0 && c.insert == ""
+}
+
+// shorten drops the first n characters of a retain or delete.
+func shorten(c component, n int) component {
+	if c.retain > 0 {
+		c.retain -= n
+	} else {
+		c.delete -= n
+	}
+	return c
+}
+
+// TransformIndex moves a cursor position over the operation: inserts before
+// it push it right and deletes before it pull it left.
+func (o *Operation) TransformIndex(index int) int {
+	pos, newIndex := 0, index
+	for _, c := range o.components {
+		if pos > index {
+			break
+		}
+		switch {
+		case c.retain > 0:
+			pos += c.retain
+		case c.delete > 0:
+			newIndex -= min(c.delete, index-pos)
+			pos += c.delete
+		default:
+			newIndex += utf8.RuneCountInString(c.insert)
+		}
+	}
+	return newIndex
+}
+
+// Replace returns the operation turning before into after by replacing
+// everything between their common prefix and suffix.
+func Replace(before, after string) *Operation {
+	a, b := []rune(before), []rune(after)
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return (&Operation{}).
+		Retain(prefix).
+		Insert(string(b[prefix : len(b)-suffix])).
+		Delete(len(a) - prefix - suffix).
+		Retain(suffix)
+}
+
+func (o *Operation) MarshalJSON() ([]byte, error) {
+	out := make([]any, 0, len(o.components))
+	for _, c := range o.components {
+		switch {
+		case c.retain > 0:
+			out = append(out, c.retain)
+		case c.delete > 0:
+			out = append(out, -c.delete)
+		default:
+			out = append(out, c.insert)
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*o = Operation{}
+	for _, item := range raw {
+		var s string
+		if err := json.Unmarshal(item, &s); err == nil {
+			if s == "" {
+				return errors.New("empty insert in operation")
+			}
+			o.Insert(s)
+			continue
+		}
+		var n int
+		if err := json.Unmarshal(item, &n); err != nil || n == 0 {
+			return fmt.Errorf("invalid operation component %s", item)
+		}
+		if n > 0 {
+			o.Retain(n)
+		} else {
+			o.Delete(-n)
+		}
+	}
+	return nil
+}
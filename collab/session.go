@@ -0,0 +1,497 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// outboxSize is how many messages a client may fall behind by before it is
+// disconnected.
+const outboxSize = 256
+
+// ErrStale is returned by Store.Save when the snippet changed since the
+// revision the session last saw.
+var ErrStale = errors.New("snippet changed since it was loaded")
+
+// Store loads and saves the content of snippets being edited.
+type Store interface {
+	// Load returns a snippet's content and revision.
+	Load(ctx context.Context, snippetID int) (content string, revision int, err error)
+	// Save stores content as the snippet's next revision on behalf of
+	// userID, unless the snippet is no longer at revision, in which case it
+	// returns ErrStale. It returns the content as stored, which the store
+	// may have changed, e.g. by redacting secrets, and the new revision.
+	Save(ctx context.Context, snippetID, userID, revision int, content string) (stored string, newRevision int, err error)
+	// CanEdit reports whether userID may still edit the snippet.
+	CanEdit(ctx context.Context, snippetID, userID int) (bool, error)
+}
+
+// Peer is a connected editor as shown to the others.
+type Peer struct {
+	ClientID     int    `json:"clientId"`
+	UserID       int    `json:"userId"`
+	Username     string `json:"username"`
+	Cursor       int    `json:"cursor"`
+	SelectionEnd int    `json:"selectionEnd"`
+}
+
+// Message is sent to clients. Type says which fields are set:
+//
+//	init       Version, Content, ClientID and Peers, on joining
+//	ack        Version, once the client's operation is applied
+//	op         Version, Op and ClientID of an operation by someone else,
+//	           or by the server when it merges outside edits
+//	presence   Peer, when someone joins or moves their cursor
+//	leave      ClientID, when someone leaves
+//	checkpoint Revision, when the document was saved as a new revision
+//	error      Error
+type Message struct {
+	Type     string     `json:"type"`
+	Version  int        `json:"version,omitempty"`
+	Content  *string    `json:"content,omitempty"`
+	Op       *Operation `json:"op,omitempty"`
+	ClientID int        `json:"clientId,omitempty"`
+	Peers    []Peer     `json:"peers,omitempty"`
+	Peer     *Peer      `json:"peer,omitempty"`
+	Revision int        `json:"revision,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Manager runs one editing session per snippet, creating it when the first
+// editor joins and closing it, after a last checkpoint, when the last one
+// leaves. The store is never called with a lock held, so that a slow
+// database does not hold up every session.
+type Manager struct {
+	store    Store
+	interval time.Duration
+	maxBytes int
+
+	mu       sync.Mutex
+	sessions map[int]*session
+	lastID   int
+}
+
+// NewManager returns a manager that checkpoints sessions every interval
+// and refuses edits that make a document larger than maxBytes.
+func NewManager(store Store, interval time.Duration, maxBytes int) *Manager {
+	return &Manager{store: store, interval: interval, maxBytes: maxBytes, sessions: make(map[int]*session)}
+}
+
+// session is the shared document of a snippet. version counts the
+// operations applied since the session started; history[i] turned version
+// i into i+1.
+type session struct {
+	manager   *Manager
+	snippetID int
+
+	mu      sync.Mutex
+	content string
+	history []*Operation
+	clients map[int]*Client
+	// saved* describe the document as last loaded or saved: its content,
+	// the session version it corresponds to and the snippet revision.
+	savedContent  string
+	savedVersion  int
+	savedRevision int
+	// editors are the users who edited the document, least recent first.
+	// Checkpoints are saved on behalf of the last one.
+	editors []int
+	closed  bool
+	stop    chan struct{}
+
+	// saving serializes checkpoints, which run without mu held.
+	saving sync.Mutex
+}
+
+// Client is one editor's connection to a session. Messages for it are
+// read from Outbox, which is closed when the client is disconnected.
+type Client struct {
+	session *session
+	peer    Peer
+	outbox  chan Message
+	gone    bool
+}
+
+// Outbox returns the messages to send to the client.
+func (c *Client) Outbox() <-chan Message {
+	return c.outbox
+}
+
+// Join adds an editor to the snippet's session, starting it if needed. The
+// client's first message is its init message.
+func (m *Manager) Join(ctx context.Context, snippetID, userID int, username string) (*Client, error) {
+	for {
+		s, err := m.session(ctx, snippetID)
+		if err != nil {
+			return nil, err
+		}
+
+		m.mu.Lock()
+		m.lastID++
+		c := &Client{
+			session: s,
+			peer:    Peer{ClientID: m.lastID, UserID: userID, Username: username},
+			outbox:  make(chan Message, outboxSize),
+		}
+		m.mu.Unlock()
+
+		s.mu.Lock()
+		if s.closed {
+			// The last editor left while we were joining: start over.
+			s.mu.Unlock()
+			continue
+		}
+		peers := []Peer{}
+		for _, other := range s.clients {
+			peers = append(peers, other.peer)
+		}
+		content := s.content
+		c.outbox <- Message{Type: "init", Version: len(s.history), Content: &content, ClientID: c.peer.ClientID, Peers: peers}
+		s.clients[c.peer.ClientID] = c
+		peer := c.peer
+		s.broadcastLocked(c, Message{Type: "presence", Peer: &peer})
+		s.mu.Unlock()
+		return c, nil
+	}
+}
+
+// session returns the snippet's running session, or loads the snippet and
+// starts one. Should another editor start it while the snippet is loading,
+// theirs is used.
+func (m *Manager) session(ctx context.Context, snippetID int) (*session, error) {
+	m.mu.Lock()
+	s, ok := m.sessions[snippetID]
+	m.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	content, revision, err := m.store.Load(ctx, snippetID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[snippetID]; ok {
+		return s, nil
+	}
+	s = &session{
+		manager:       m,
+		snippetID:     snippetID,
+		content:       content,
+		clients:       make(map[int]*Client),
+		savedContent:  content,
+		savedRevision: revision,
+		stop:          make(chan struct{}),
+	}
+	m.sessions[snippetID] = s
+	go s.checkpointLoop(m.interval)
+	return s, nil
+}
+
+// Leave disconnects the client. When it was the last one, the session is
+// checkpointed and, unless someone joined in the meantime, closed.
+func (c *Client) Leave() {
+	s := c.session
+	s.mu.Lock()
+	s.dropLocked(c)
+	empty := len(s.clients) == 0
+	s.mu.Unlock()
+	if !empty {
+		return
+	}
+
+	s.checkpoint()
+
+	m := s.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) > 0 || s.closed {
+		return
+	}
+	s.closed = true
+	close(s.stop)
+	delete(m.sessions, s.snippetID)
+}
+
+// Edit applies an operation the client made on version of the document.
+// It is transformed over the operations applied since, then acknowledged
+// to the client and broadcast to the others.
+func (c *Client) Edit(version int, op *Operation) error {
+	s := c.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.gone {
+		return nil
+	}
+
+	if version < 0 || version > len(s.history) {
+		return errors.New("unknown document version")
+	}
+	for _, concurrent := range s.history[version:] {
+		var err error
+		if op, _, err = Transform(op, concurrent); err != nil {
+			return err
+		}
+	}
+	content, err := op.Apply(s.content)
+	if err != nil {
+		return err
+	}
+	if len(content) > s.manager.maxBytes {
+		return errors.New("document too large")
+	}
+
+	s.applyLocked(op, content)
+	s.editors = append(slices.DeleteFunc(s.editors, func(id int) bool { return id == c.peer.UserID }), c.peer.UserID)
+	s.sendLocked(c, Message{Type: "ack", Version: len(s.history)})
+	s.broadcastLocked(c, Message{Type: "op", Version: len(s.history), Op: op, ClientID: c.peer.ClientID})
+	return nil
+}
+
+// MoveCursor sets the client's cursor and selection end, given on version
+// of the document, and tells the others.
+func (c *Client) MoveCursor(version, cursor, selectionEnd int) error {
+	s := c.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.gone {
+		return nil
+	}
+
+	if version < 0 || version > len(s.history) {
+		return errors.New("unknown document version")
+	}
+	for _, op := range s.history[version:] {
+		cursor, selectionEnd = op.TransformIndex(cursor), op.TransformIndex(selectionEnd)
+	}
+	c.peer.Cursor, c.peer.SelectionEnd = clamp(cursor, s.content), clamp(selectionEnd, s.content)
+	peer := c.peer
+	s.broadcastLocked(c, Message{Type: "presence", Peer: &peer})
+	return nil
+}
+
+func clamp(index int, content string) int {
+	return max(0, min(index, len([]rune(content))))
+}
+
+// applyLocked applies an already transformed operation and moves every
+// cursor over it.
+func (s *session) applyLocked(op *Operation, content string) {
+	s.content = content
+	s.history = append(s.history, op)
+	for _, c := range s.clients {
+		c.peer.Cursor, c.peer.SelectionEnd = op.TransformIndex(c.peer.Cursor), op.TransformIndex(c.peer.SelectionEnd)
+	}
+}
+
+// sendLocked queues a message for a client, disconnecting it if it fell
+// too far behind.
+func (s *session) sendLocked(c *Client, msg Message) {
+	select {
+	case c.outbox <- msg:
+	default:
+		s.dropLocked(c)
+	}
+}
+
+// broadcastLocked sends a message to every client but except, which may be
+// nil.
+func (s *session) broadcastLocked(except *Client, msg Message) {
+	for _, c := range s.clients {
+		if c != except {
+			s.sendLocked(c, msg)
+		}
+	}
+}
+
+func (s *session) dropLocked(c *Client) {
+	if c.gone {
+		return
+	}
+	c.gone = true
+	delete(s.clients, c.peer.ClientID)
+	close(c.outbox)
+	s.broadcastLocked(nil, Message{Type: "leave", ClientID: c.peer.ClientID})
+}
+
+func (s *session) checkpointLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkpoint()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// checkpoint re-checks the editors' access and saves the document as a new
+// revision if it changed. Edits made to the snippet outside the session
+// since it was last saved are merged in first, as an operation from the
+// server. The session is only locked between calls to the store, so it
+// keeps taking edits while the document is saved.
+func (s *session) checkpoint() {
+	s.saving.Lock()
+	defer s.saving.Unlock()
+
+	ctx := context.Background()
+	s.checkAccess(ctx)
+	for attempt := 0; attempt < 2; attempt++ {
+		s.mu.Lock()
+		if s.content == s.savedContent || len(s.editors) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		content, version, revision, editor := s.content, len(s.history), s.savedRevision, s.editors[len(s.editors)-1]
+		s.mu.Unlock()
+
+		stored, newRevision, err := s.manager.store.Save(ctx, s.snippetID, editor, revision, content)
+		if errors.Is(err, ErrStale) {
+			if err := s.mergeOutside(ctx); err != nil {
+				log.Println("Error merging outside edits into live session:", err)
+				return
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		if err != nil {
+			log.Println("Error checkpointing live session:", err)
+			s.broadcastLocked(nil, Message{Type: "error", Error: "Failed to save the document: " + err.Error()})
+			s.mu.Unlock()
+			return
+		}
+		switch {
+		case stored == content:
+			s.savedContent, s.savedVersion = stored, version
+		case version == len(s.history):
+			// The store changed what was saved, e.g. redacted a secret:
+			// show its version to the editors.
+			s.serverEditLocked(Replace(content, stored))
+			s.savedContent, s.savedVersion = stored, len(s.history)
+		default:
+			// The store changed what was saved but the document was edited
+			// meanwhile. The next checkpoint saves the edits over it, and
+			// the store changes them likewise.
+			s.savedContent, s.savedVersion = content, version
+		}
+		s.savedRevision = newRevision
+		s.broadcastLocked(nil, Message{Type: "checkpoint", Revision: newRevision})
+		s.mu.Unlock()
+		return
+	}
+}
+
+// checkAccess asks the store whether the session's users may still edit
+// the snippet. Those who may not are disconnected and no longer saved on
+// behalf of; if nobody left edited, their unsaved edits are undone.
+func (s *session) checkAccess(ctx context.Context) {
+	s.mu.Lock()
+	users := slices.Clone(s.editors)
+	for _, c := range s.clients {
+		users = append(users, c.peer.UserID)
+	}
+	s.mu.Unlock()
+	slices.Sort(users)
+
+	revoked := make(map[int]bool)
+	for _, userID := range slices.Compact(users) {
+		ok, err := s.manager.store.CanEdit(ctx, s.snippetID, userID)
+		if err != nil {
+			log.Println("Error checking access to live session:", err)
+			continue
+		}
+		if !ok {
+			revoked[userID] = true
+		}
+	}
+	if len(revoked) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		if revoked[c.peer.UserID] {
+			s.sendLocked(c, Message{Type: "error", Error: "You can no longer edit this snippet"})
+			s.dropLocked(c)
+		}
+	}
+	s.editors = slices.DeleteFunc(s.editors, func(id int) bool { return revoked[id] })
+	if len(s.editors) == 0 && s.content != s.savedContent {
+		s.serverEditLocked(Replace(s.content, s.savedContent))
+	}
+}
+
+// mergeOutside loads the snippet and merges the changes made to it since
+// the session last saved it.
+func (s *session) mergeOutside(ctx context.Context) error {
+	content, revision, err := s.manager.store.Load(ctx, s.snippetID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op := Replace(s.savedContent, content)
+	for _, concurrent := range s.history[s.savedVersion:] {
+		if op, _, err = Transform(op, concurrent); err != nil {
+			return err
+		}
+	}
+	s.serverEditLocked(op)
+	// The stored revision is now the outside one; the merge is saved over
+	// it by the caller's next attempt.
+	s.savedContent, s.savedVersion, s.savedRevision = content, len(s.history), revision
+	return nil
+}
+
+// serverEditLocked applies an operation made by the server and broadcasts
+// it to every client.
+func (s *session) serverEditLocked(op *Operation) {
+	if op.IsNoop() {
+		return
+	}
+	content, err := op.Apply(s.content)
+	if err != nil {
+		log.Println("Error applying server edit to live session:", err)
+		return
+	}
+	s.applyLocked(op, content)
+	s.broadcastLocked(nil, Message{Type: "op", Version: len(s.history), Op: op})
+}
+
+// Close disconnects every session's clients and checkpoints it, for server
+// shutdown.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	sessions := make([]*session, 0, len(m.sessions))
+	for id, s := range m.sessions {
+		sessions = append(sessions, s)
+		delete(m.sessions, id)
+	}
+	m.mu.Unlock()
+
+	for _, s := range sessions {
+		s.mu.Lock()
+		for _, c := range s.clients {
+			s.dropLocked(c)
+		}
+		if !s.closed {
+			s.closed = true
+			close(s.stop)
+		}
+		s.mu.Unlock()
+		s.checkpoint()
+	}
+}
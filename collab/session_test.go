@@ -0,0 +1,230 @@
+package collab
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore keeps one snippet in memory. Saves and loads wait for their
+// gate to be closed, when one is set.
+type fakeStore struct {
+	mu        sync.Mutex
+	content   string
+	revision  int
+	savedBy   []int
+	revoked   map[int]bool
+	saveGate  chan struct{}
+	loadGate  chan struct{}
+	saveCalls chan struct{}
+}
+
+func newFakeStore(content string) *fakeStore {
+	return &fakeStore{content: content, revision: 1, revoked: map[int]bool{}, saveCalls: make(chan struct{}, 16)}
+}
+
+func (f *fakeStore) Load(ctx context.Context, snippetID int) (string, int, error) {
+	if f.loadGate != nil && snippetID == 1 {
+		<-f.loadGate
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.content, f.revision, nil
+}
+
+func (f *fakeStore) Save(ctx context.Context, snippetID, userID, revision int, content string) (string, int, error) {
+	f.saveCalls <- struct{}{}
+	if f.saveGate != nil {
+		<-f.saveGate
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if revision != f.revision {
+		return "", 0, ErrStale
+	}
+	f.content, f.revision = content, f.revision+1
+	f.savedBy = append(f.savedBy, userID)
+	return content, f.revision, nil
+}
+
+func (f *fakeStore) CanEdit(ctx context.Context, snippetID, userID int) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.revoked[userID], nil
+}
+
+func (f *fakeStore) stored() (string, []int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.content, append([]int(nil), f.savedBy...)
+}
+
+// edit replaces the client's document, at version, with content.
+func edit(t *testing.T, c *Client, version int, before, after string) {
+	t.Helper()
+	if err := c.Edit(version, Replace(before, after)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// drain discards the messages a client was sent so far.
+func drain(c *Client) (types []string, open bool) {
+	for {
+		select {
+		case msg, ok := <-c.Outbox():
+			if !ok {
+				return types, false
+			}
+			types = append(types, msg.Type)
+		default:
+			return types, true
+		}
+	}
+}
+
+func withTimeout(t *testing.T, what string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s blocked", what)
+	}
+}
+
+func TestEditsContinueWhileSaving(t *testing.T) {
+	store := newFakeStore("hello")
+	store.saveGate = make(chan struct{})
+	m := NewManager(store, time.Hour, 1<<20)
+
+	c, err := m.Join(context.Background(), 1, 7, "ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(t, c, 0, "hello", "hello world")
+
+	saved := make(chan struct{})
+	go func() {
+		c.session.checkpoint()
+		close(saved)
+	}()
+	<-store.saveCalls
+
+	// The save is in flight: the session still takes edits and joins.
+	withTimeout(t, "editing during a save", func() { edit(t, c, 1, "hello world", "hello world!") })
+	withTimeout(t, "joining during a save", func() {
+		if _, err := m.Join(context.Background(), 1, 8, "bob"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	close(store.saveGate)
+	<-saved
+	if content, _ := store.stored(); content != "hello world" {
+		t.Errorf("first checkpoint stored %q, want the document as it was when saving began", content)
+	}
+	c.session.checkpoint()
+	if content, by := store.stored(); content != "hello world!" || len(by) != 2 || by[1] != 7 {
+		t.Errorf("second checkpoint stored %q by %v, want %q by 7", content, by, "hello world!")
+	}
+}
+
+func TestJoinDoesNotWaitForOtherSnippets(t *testing.T) {
+	store := newFakeStore("hello")
+	store.loadGate = make(chan struct{})
+	m := NewManager(store, time.Hour, 1<<20)
+
+	joined := make(chan error)
+	go func() {
+		_, err := m.Join(context.Background(), 1, 7, "ann")
+		joined <- err
+	}()
+	withTimeout(t, "joining another snippet while one loads", func() {
+		if _, err := m.Join(context.Background(), 2, 8, "bob"); err != nil {
+			t.Error(err)
+		}
+	})
+	close(store.loadGate)
+	if err := <-joined; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLostAccess(t *testing.T) {
+	store := newFakeStore("hello")
+	m := NewManager(store, time.Hour, 1<<20)
+	ann, err := m.Join(context.Background(), 1, 7, "ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := m.Join(context.Background(), 1, 8, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edit(t, ann, 0, "hello", "hello ann")
+	edit(t, bob, 1, "hello ann", "hello ann and bob")
+	store.revoked[8] = true
+	ann.session.checkpoint()
+
+	types, open := drain(bob)
+	if open || len(types) == 0 || types[len(types)-1] != "error" {
+		t.Errorf("bob got %v and is connected: %v; want an error and to be disconnected", types, open)
+	}
+	if content, by := store.stored(); content != "hello ann and bob" || len(by) != 1 || by[0] != 7 {
+		t.Errorf("stored %q by %v, want the document saved on behalf of ann, 7", content, by)
+	}
+}
+
+func TestLostAccessUndoesUnsavedEdits(t *testing.T) {
+	store := newFakeStore("hello")
+	m := NewManager(store, time.Hour, 1<<20)
+	ann, err := m.Join(context.Background(), 1, 7, "ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := m.Join(context.Background(), 1, 8, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edit(t, bob, 0, "hello", "hello bob")
+	store.revoked[8] = true
+	ann.session.checkpoint()
+
+	if _, by := store.stored(); len(by) != 0 {
+		t.Errorf("saved on behalf of %v, want nothing saved", by)
+	}
+	ann.session.mu.Lock()
+	content := ann.session.content
+	ann.session.mu.Unlock()
+	if content != "hello" {
+		t.Errorf("document is %q, want the edits of the user who lost access undone", content)
+	}
+}
+
+func TestLeaveCheckpointsAndCloses(t *testing.T) {
+	store := newFakeStore("hello")
+	m := NewManager(store, time.Hour, 1<<20)
+	c, err := m.Join(context.Background(), 1, 7, "ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(t, c, 0, "hello", "hello there")
+	withTimeout(t, "leaving", c.Leave)
+
+	if content, _ := store.stored(); content != "hello there" {
+		t.Errorf("stored %q, want %q", content, "hello there")
+	}
+	m.mu.Lock()
+	n := len(m.sessions)
+	m.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d sessions still running after the last editor left", n)
+	}
+}
@@ -1,16 +1,47 @@
 package configs
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+const (
+	defaultJanitorInterval = time.Minute
+	defaultSecretScanMode  = "warn"
+	defaultQueryTimeout    = 5 * time.Second
+	defaultInviteTTL       = 7 * 24 * time.Hour
+	defaultWebhookInterval = 5 * time.Second
+	defaultCheckpoint      = 30 * time.Second
+)
+
 type Config struct {
 	DbName             string
 	GithubClientId     string
 	GithubClientSecret string
+	JanitorInterval    time.Duration
+	// QueryTimeout is the default deadline for a single repo call.
+	QueryTimeout   time.Duration
+	SecretScanMode string
+	// SecretScanRules holds custom secret detectors, rule name to regular expression.
+	SecretScanRules map[string]string
+	// AdminUserIds lists the users allowed to call the /api/admin endpoints.
+	AdminUserIds []int
+	// InviteSecret signs organization invitation links. Invitations cannot
+	// be created while it is empty.
+	InviteSecret string
+	// InviteTTL is how long an invitation link stays valid.
+	InviteTTL time.Duration
+	// WebhookInterval is how often queued webhook deliveries are checked for.
+	WebhookInterval time.Duration
+	// CheckpointInterval is how often live editing sessions save their
+	// document as a new revision.
+	CheckpointInterval time.Duration
 }
 
 func LoadEnv() error {
@@ -28,10 +59,110 @@ func GetConfig() (*Config, error) {
 		return nil, err
 	}
 
+	janitorInterval, err := getDuration("JANITOR_INTERVAL", defaultJanitorInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	queryTimeout, err := getDuration("QUERY_TIMEOUT", defaultQueryTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	inviteTTL, err := getDuration("INVITE_TTL", defaultInviteTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	webhookInterval, err := getDuration("WEBHOOK_INTERVAL", defaultWebhookInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpointInterval, err := getDuration("CHECKPOINT_INTERVAL", defaultCheckpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	secretScanRules, err := getSecretScanRules(os.Getenv("SECRET_SCAN_RULES_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	adminUserIds, err := getIntList("ADMIN_USER_IDS")
+	if err != nil {
+		return nil, err
+	}
+
+	secretScanMode := os.Getenv("SECRET_SCAN_MODE")
+	if secretScanMode == "" {
+		secretScanMode = defaultSecretScanMode
+	}
+
 	cfg := Config{
 		GithubClientId:     os.Getenv("GITHUB_CLIENT_ID"),
 		GithubClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+		JanitorInterval:    janitorInterval,
+		QueryTimeout:       queryTimeout,
+		SecretScanMode:     secretScanMode,
+		SecretScanRules:    secretScanRules,
+		AdminUserIds:       adminUserIds,
+		InviteSecret:       os.Getenv("INVITE_SECRET"),
+		InviteTTL:          inviteTTL,
+		WebhookInterval:    webhookInterval,
+		CheckpointInterval: checkpointInterval,
 	}
 
 	return &cfg, nil
 }
+
+// getDuration reads a time.ParseDuration value from the environment,
+// falling back to def when the variable is unset.
+func getDuration(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid %s: must be positive", key)
+	}
+	return d, nil
+}
+
+// getSecretScanRules reads custom secret detectors from a JSON file mapping
+// rule names to regular expressions. An empty path means no custom rules.
+func getSecretScanRules(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secret scan rules: %v", err)
+	}
+	var rules map[string]string
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing secret scan rules: %v", err)
+	}
+	return rules, nil
+}
+
+// getIntList reads a comma-separated list of integers from the environment.
+func getIntList(key string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(os.Getenv(key), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
@@ -1,29 +1,47 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"os"
 	"snippetier/db/repo"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 type Storage struct {
-	db           *sql.DB
-	Name         string
-	UsersRepo    *repo.UsersRepo
-	SnippetsRepo *repo.SnippetsRepo
+	db              *repo.Conn
+	timeout         time.Duration
+	Name            string
+	UsersRepo       *repo.UsersRepo
+	SnippetsRepo    *repo.SnippetsRepo
+	CommentsRepo    *repo.CommentsRepo
+	OrgsRepo        *repo.OrgsRepo
+	CollectionsRepo *repo.CollectionsRepo
+	ActivityRepo    *repo.ActivityRepo
+	SearchesRepo    *repo.SearchesRepo
+	WebhooksRepo    *repo.WebhooksRepo
 }
 
-func initRepos(db *sql.DB) *Storage {
-	usersRepo := repo.NewUsersRepo(db)
-	snippetsRepo := repo.NewSnippetsRepo(db)
+func initRepos(dbConn *sql.DB, queryTimeout time.Duration) *Storage {
+	db := repo.NewConn(dbConn)
+	usersRepo := repo.NewUsersRepo(db, queryTimeout)
+	snippetsRepo := repo.NewSnippetsRepo(db, queryTimeout)
+	commentsRepo := repo.NewCommentsRepo(db, queryTimeout)
+	orgsRepo := repo.NewOrgsRepo(db, queryTimeout)
+	collectionsRepo := repo.NewCollectionsRepo(db, queryTimeout)
+	activityRepo := repo.NewActivityRepo(db, queryTimeout)
+	searchesRepo := repo.NewSearchesRepo(db, queryTimeout)
+	webhooksRepo := repo.NewWebhooksRepo(db, queryTimeout)
 
-	return &Storage{db: db, UsersRepo: usersRepo, SnippetsRepo: snippetsRepo}
+	return &Storage{db: db, timeout: queryTimeout, UsersRepo: usersRepo, SnippetsRepo: snippetsRepo, CommentsRepo: commentsRepo, OrgsRepo: orgsRepo, CollectionsRepo: collectionsRepo, ActivityRepo: activityRepo, SearchesRepo: searchesRepo, WebhooksRepo: webhooksRepo}
 }
 
-func GetConnection() (*Storage, error) {
+// GetConnection connects to the database. Every repo query is bounded by
+// queryTimeout unless the caller's context sets an earlier deadline.
+func GetConnection(queryTimeout time.Duration) (*Storage, error) {
 	dbConn, err := sql.Open("mysql", os.Getenv("DSN"))
 
 	if err != nil {
@@ -34,14 +52,63 @@ func GetConnection() (*Storage, error) {
 		return nil, err
 	}
 
-	return initRepos(dbConn), nil
+	return initRepos(dbConn, queryTimeout), nil
+}
+
+// OnCommit sets the publisher told about the changes to snippets once they
+// are committed.
+func (s *Storage) OnCommit(publish repo.Publisher) {
+	s.db.OnCommit(publish)
 }
 
 func (s *Storage) CloseConnection() {
 	err := s.db.Close()
 	if err != nil {
-		log.Fatalf("could not close db")
+		log.Println("Error closing db:", err)
+	}
+}
+
+// Tx is a unit of work: repos whose statements all run in one transaction.
+type Tx struct {
+	UsersRepo       *repo.UsersRepo
+	SnippetsRepo    *repo.SnippetsRepo
+	CommentsRepo    *repo.CommentsRepo
+	OrgsRepo        *repo.OrgsRepo
+	CollectionsRepo *repo.CollectionsRepo
+	ActivityRepo    *repo.ActivityRepo
+	SearchesRepo    *repo.SearchesRepo
+	WebhooksRepo    *repo.WebhooksRepo
+}
+
+// WithTx runs fn with repos bound to a single transaction. The transaction
+// commits if fn returns nil and rolls back if it returns an error or panics.
+func (s *Storage) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	sqlTx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
 	}
+	defer sqlTx.Rollback()
+
+	tx := &Tx{
+		UsersRepo:       repo.NewUsersRepo(sqlTx, s.timeout),
+		SnippetsRepo:    repo.NewSnippetsRepo(sqlTx, s.timeout),
+		CommentsRepo:    repo.NewCommentsRepo(sqlTx, s.timeout),
+		OrgsRepo:        repo.NewOrgsRepo(sqlTx, s.timeout),
+		CollectionsRepo: repo.NewCollectionsRepo(sqlTx, s.timeout),
+		ActivityRepo:    repo.NewActivityRepo(sqlTx, s.timeout),
+		SearchesRepo:    repo.NewSearchesRepo(sqlTx, s.timeout),
+		WebhooksRepo:    repo.NewWebhooksRepo(sqlTx, s.timeout),
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := sqlTx.Commit(); err != nil {
+		log.Println("Error committing transaction:", err)
+		return err
+	}
+	return nil
 }
 
 func (s *Storage) SeedDb(seedFilePath string) error {
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"log"
+	"snippetier/db/repo"
+	"sync"
+	"time"
+)
+
+// Janitor periodically purges expired snippets from the database.
+type Janitor struct {
+	snippets *repo.SnippetsRepo
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewJanitor(snippets *repo.SnippetsRepo, interval time.Duration) *Janitor {
+	return &Janitor{
+		snippets: snippets,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs the purge loop in a background goroutine.
+func (j *Janitor) Start() {
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				j.purge()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop signals the purge loop to exit and waits for an in-flight purge to finish.
+func (j *Janitor) Stop() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+	<-j.done
+}
+
+func (j *Janitor) purge() {
+	purged, err := j.snippets.PurgeExpired(context.Background())
+	if err != nil {
+		log.Println("Janitor failed to purge expired snippets:", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Janitor purged %d expired snippets", purged)
+	}
+}
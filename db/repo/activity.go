@@ -0,0 +1,105 @@
+package repo
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Activity verbs.
+const (
+	ActivitySnippetCreated = "snippet.created"
+	ActivitySnippetUpdated = "snippet.updated"
+	ActivitySnippetForked  = "snippet.forked"
+	ActivitySnippetStarred = "snippet.starred"
+	ActivityCommentCreated = "comment.created"
+)
+
+// Activity is something a user did to a snippet. For forks SnippetID is the
+// new fork; for comments CommentID is set.
+type Activity struct {
+	ID            int    `json:"id"`
+	ActorID       int    `json:"actorId"`
+	ActorUsername string `json:"actorUsername"`
+	Verb          string `json:"verb"`
+	SnippetID     int    `json:"snippetId"`
+	SnippetName   string `json:"snippetName"`
+	CommentID     *int   `json:"commentId,omitempty"`
+	CreatedAt     string `json:"createdAt"`
+}
+
+// recordActivity logs an activity and announces it; a fork is announced
+// as a created snippet. It runs in the transaction of the change it
+// describes, so that both are written or neither is.
+func recordActivity(ctx context.Context, tx DBTX, actorID int, verb string, snippetID int, commentID *int) error {
+	query := "INSERT INTO activity (actor_id, verb, snippet_id, comment_id) VALUES (?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, actorID, verb, snippetID, commentID); err != nil {
+		log.Println("Error recording activity:", err)
+		return err
+	}
+
+	event := verb
+	if verb == ActivitySnippetForked {
+		event = EventSnippetCreated
+	}
+	return announce(ctx, tx, event, actorID, snippetID, commentID)
+}
+
+type ActivityRepo struct {
+	db      DBTX
+	timeout time.Duration
+}
+
+// NewActivityRepo returns a repo whose queries are bounded by timeout unless
+// the caller's context sets an earlier deadline. Zero means no default.
+func NewActivityRepo(db DBTX, timeout time.Duration) *ActivityRepo {
+	return &ActivityRepo{db, timeout}
+}
+
+// GetFeed lists, newest first, the activity of the users the user follows
+// and the activity on snippets of the organizations they belong to, leaving
+// out their own. Only activity older than the before cursor, an activity ID,
+// is listed unless it is zero.
+//
+// The feed is assembled on read from the activity log, so whatever is no
+// longer visible drops out by itself: activity on burn-after-read, expired
+// or deleted snippets is never listed, nor on snippets of organizations the
+// user is not in, and neither are deleted or removed comments.
+func (r *ActivityRepo) GetFeed(ctx context.Context, userID, before, limit int) (_ []Activity, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := `
+        SELECT activity.id, activity.actor_id, users.username, activity.verb, activity.snippet_id, snippets.name, activity.comment_id, activity.created_at
+        FROM activity
+        JOIN snippets ON snippets.id = activity.snippet_id
+        JOIN users ON users.id = activity.actor_id
+        WHERE (activity.actor_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)
+               OR snippets.org_id IN (SELECT org_id FROM org_members WHERE user_id = ?))
+          AND activity.snippet_id IN (SELECT id FROM snippets WHERE ` + visibleTo + `)
+          AND activity.actor_id <> ?
+          AND snippets.burn_after_read = 0 AND ` + notExpired + `
+          AND (activity.comment_id IS NULL
+               OR activity.comment_id IN (SELECT id FROM comments WHERE deleted = 0 AND removed_by IS NULL))
+          AND (? = 0 OR activity.id < ?)
+        ORDER BY activity.id DESC
+        LIMIT ?
+    `
+	rows, err := r.db.QueryContext(ctx, query, userID, userID, userID, userID, userID, before, before, limit)
+	if err != nil {
+		log.Println("Error retrieving feed:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	feed := []Activity{}
+	for rows.Next() {
+		var a Activity
+		if err := rows.Scan(&a.ID, &a.ActorID, &a.ActorUsername, &a.Verb, &a.SnippetID, &a.SnippetName, &a.CommentID, &a.CreatedAt); err != nil {
+			log.Println("Error scanning activity:", err)
+			return nil, err
+		}
+		feed = append(feed, a)
+	}
+	return feed, rows.Err()
+}
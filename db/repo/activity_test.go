@@ -0,0 +1,103 @@
+package repo
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestGetFeed(t *testing.T) {
+	ctx := context.Background()
+	conn := newTestConn(t)
+	reader := newTestUser(t, conn, "reader")
+	author := newTestUser(t, conn, "author")
+	member := newTestUser(t, conn, "member")
+
+	users := NewUsersRepo(conn, 0)
+	if err := users.Follow(ctx, reader, author); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Follow(ctx, member, author); err != nil {
+		t.Fatal(err)
+	}
+	orgs := NewOrgsRepo(conn, 0)
+	org, err := orgs.CreateOrg(ctx, author, "acme", "Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orgs.SetMember(ctx, org.ID, member, RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	public := newTestSnippet(t, conn, author, Snippet{Name: "public", Content: "echo public"})
+	private := newTestSnippet(t, conn, author, Snippet{Name: "private", Content: "echo private", OrgID: &org.ID})
+	burned := newTestSnippet(t, conn, author, Snippet{Name: "burned", Content: "echo burned", BurnAfterRead: true})
+	expired := newTestSnippet(t, conn, author, Snippet{Name: "expired", Content: "echo expired"})
+	if _, err := conn.Exec("UPDATE snippets SET expires_at = '2000-01-01 00:00:00' WHERE id = ?", expired.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	comments := NewCommentsRepo(conn, 0)
+	addComment := func(body string) Comment {
+		t.Helper()
+		comment, err := comments.AddComment(ctx, Comment{SnippetID: public.ID, AuthorID: author, Body: body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return comment
+	}
+	kept := addComment("kept")
+	deleted := addComment("deleted")
+	removed := addComment("removed")
+	if err := comments.DeleteComment(ctx, public.ID, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := comments.RemoveComment(ctx, public.ID, removed.ID, member); err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		verb      string
+		snippetID int
+		commentID int
+	}
+	feed := func(userID int) []entry {
+		t.Helper()
+		activity, err := NewActivityRepo(conn, 0).GetFeed(ctx, userID, 0, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []entry
+		for _, a := range activity {
+			e := entry{verb: a.Verb, snippetID: a.SnippetID}
+			if a.CommentID != nil {
+				e.commentID = *a.CommentID
+			}
+			entries = append(entries, e)
+		}
+		return entries
+	}
+
+	want := []entry{
+		{ActivityCommentCreated, public.ID, kept.ID},
+		{ActivitySnippetCreated, public.ID, 0},
+	}
+	if got := feed(reader); !slices.Equal(got, want) {
+		t.Errorf("feed of a follower outside the organization = %v, want %v", got, want)
+	}
+
+	want = []entry{
+		{ActivityCommentCreated, public.ID, kept.ID},
+		{ActivitySnippetCreated, private.ID, 0},
+		{ActivitySnippetCreated, public.ID, 0},
+	}
+	if got := feed(member); !slices.Equal(got, want) {
+		t.Errorf("feed of a follower in the organization = %v, want %v", got, want)
+	}
+
+	for _, e := range append(feed(reader), feed(member)...) {
+		if e.snippetID == burned.ID || e.snippetID == expired.ID {
+			t.Errorf("feed lists activity on snippet %d, which is burn-after-read or expired", e.snippetID)
+		}
+	}
+}
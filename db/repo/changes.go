@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+)
+
+// Change is a committed write to a snippet, as told to real-time
+// subscribers. Event is one of the webhook events.
+type Change struct {
+	Event     string `json:"event"`
+	ActorID   int    `json:"actorId"`
+	SnippetID int    `json:"snippetId"`
+	// UserID and OrgID are the snippet's owner and organization, for
+	// filtering and visibility checks.
+	UserID        int  `json:"userId"`
+	OrgID         *int `json:"orgId,omitempty"`
+	CommentID     *int `json:"commentId,omitempty"`
+	Revision      int  `json:"revision"`
+	BurnAfterRead bool `json:"-"`
+}
+
+// Publisher is told about changes once the transaction that made them has
+// committed. It must not block.
+type Publisher func(Change)
+
+// Conn is the connection pool the repos run on. Transactions begun on it
+// collect the changes made in them and publish them on commit, so that
+// subscribers never hear of a change that was rolled back.
+type Conn struct {
+	*sql.DB
+
+	mu      sync.RWMutex
+	publish Publisher
+}
+
+func NewConn(db *sql.DB) *Conn {
+	return &Conn{DB: db}
+}
+
+// OnCommit sets the publisher committed changes are sent to.
+func (c *Conn) OnCommit(publish Publisher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.publish = publish
+}
+
+// BeginTx starts a transaction that publishes its changes on commit.
+func (c *Conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*ConnTx, error) {
+	tx, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ConnTx{Tx: tx, conn: c}, nil
+}
+
+// ConnTx is a transaction begun on a Conn.
+type ConnTx struct {
+	*sql.Tx
+
+	conn    *Conn
+	changes []Change
+}
+
+// Commit commits the transaction and then publishes its changes.
+func (t *ConnTx) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		return err
+	}
+	t.conn.mu.RLock()
+	publish := t.conn.publish
+	t.conn.mu.RUnlock()
+	if publish != nil {
+		for _, change := range t.changes {
+			publish(change)
+		}
+	}
+	t.changes = nil
+	return nil
+}
+
+// announce tells about an event on a snippet: it queues the webhook
+// deliveries the event triggers and notes the change for real-time
+// subscribers. It runs in the transaction of the change, so that the event
+// goes out if and only if the change is committed, and must be called
+// before a snippet is deleted.
+func announce(ctx context.Context, tx DBTX, event string, actorID, snippetID int, commentID *int) error {
+	var s webhookSnippet
+	var burnAfterRead bool
+	query := "SELECT id, name, language, revision, user_id, org_id, burn_after_read, updated_at FROM snippets WHERE id = ?"
+	err := tx.QueryRowContext(ctx, query, snippetID).Scan(&s.ID, &s.Name, &s.Language, &s.Revision, &s.UserID, &s.OrgID, &burnAfterRead, &s.UpdatedAt)
+	if err != nil {
+		log.Println("Error retrieving snippet for announcement:", err)
+		return err
+	}
+
+	if err := queueWebhooks(ctx, tx, event, actorID, s, commentID); err != nil {
+		return err
+	}
+	addChange(tx, Change{
+		Event:         event,
+		ActorID:       actorID,
+		SnippetID:     s.ID,
+		UserID:        s.UserID,
+		OrgID:         s.OrgID,
+		CommentID:     commentID,
+		Revision:      s.Revision,
+		BurnAfterRead: burnAfterRead,
+	})
+	return nil
+}
+
+// addChange notes a change made in tx, to be published when it commits.
+// Changes made outside a ConnTx are not published.
+func addChange(tx DBTX, change Change) {
+	if t, ok := tx.(*ConnTx); ok {
+		t.changes = append(t.changes, change)
+	}
+}
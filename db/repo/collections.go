@@ -0,0 +1,540 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"snippetier/apperr"
+	"time"
+)
+
+// Collection is an ordered, nestable group of snippets. A collection can
+// hold snippets of any owner; only the collection itself belongs to UserID.
+type Collection struct {
+	ID          int    `json:"id"`
+	UserID      int    `json:"userId"`
+	ParentID    *int   `json:"parentId,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+	// Position orders a collection among its siblings, starting at 0.
+	Position int `json:"position"`
+	// ShareToken lets anyone holding it read the collection and everything
+	// nested in it, whatever their visibility.
+	ShareToken *string `json:"shareToken,omitempty"`
+	CreatedAt  string  `json:"createdAt"`
+	UpdatedAt  string  `json:"updatedAt"`
+}
+
+// Collection visibilities. Private collections are only shown to their
+// owner and to holders of a share link.
+const (
+	VisibilityPrivate = "private"
+	VisibilityPublic  = "public"
+)
+
+// CollectionTree is a collection with its snippets and nested collections,
+// both in position order.
+type CollectionTree struct {
+	Collection
+	Snippets []Snippet        `json:"snippets"`
+	Children []CollectionTree `json:"children"`
+}
+
+var (
+	// ErrCollectionNotFound is returned when a collection does not exist.
+	ErrCollectionNotFound = apperr.New(apperr.ErrNotFound, "collection not found")
+	// ErrCollectionCycle is returned when moving a collection into itself
+	// or into one of its descendants.
+	ErrCollectionCycle = apperr.New(apperr.ErrConflict, "a collection cannot be moved into itself")
+)
+
+const collectionColumns = "id, user_id, parent_id, name, description, visibility, position, share_token, created_at, updated_at"
+
+func scanCollection(row rowScanner) (Collection, error) {
+	var c Collection
+	err := row.Scan(&c.ID, &c.UserID, &c.ParentID, &c.Name, &c.Description, &c.Visibility, &c.Position, &c.ShareToken, &c.CreatedAt, &c.UpdatedAt)
+	return c, err
+}
+
+type CollectionsRepo struct {
+	db      DBTX
+	timeout time.Duration
+}
+
+// NewCollectionsRepo returns a repo whose queries are bounded by timeout
+// unless the caller's context sets an earlier deadline. Zero means no
+// default.
+func NewCollectionsRepo(db DBTX, timeout time.Duration) *CollectionsRepo {
+	return &CollectionsRepo{db, timeout}
+}
+
+// CreateCollection adds a collection after its last sibling. A parent must
+// belong to the same user.
+func (r *CollectionsRepo) CreateCollection(ctx context.Context, collection Collection) (_ Collection, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		if collection.ParentID != nil {
+			if _, err := ownedCollection(ctx, tx, collection.UserID, *collection.ParentID); err != nil {
+				return err
+			}
+		}
+
+		query := "SELECT COUNT(*) FROM collections WHERE user_id = ? AND " + sameParent
+		var position int
+		if err := tx.QueryRowContext(ctx, query, collection.UserID, collection.ParentID, collection.ParentID).Scan(&position); err != nil {
+			log.Println("Error counting collections:", err)
+			return err
+		}
+
+		query = `
+            INSERT INTO collections (user_id, parent_id, name, description, visibility, position)
+            VALUES (?, ?, ?, ?, ?, ?)
+        `
+		res, err := tx.ExecContext(ctx, query, collection.UserID, collection.ParentID, collection.Name, collection.Description, collection.Visibility, position)
+		if err != nil {
+			log.Println("Error creating collection:", err)
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			log.Println("Error getting last insert ID:", err)
+			return err
+		}
+
+		collection, err = getCollection(ctx, tx, "id = ?", id)
+		return err
+	})
+	if err != nil {
+		return Collection{}, err
+	}
+	return collection, nil
+}
+
+// sameParent matches collections under the given parent, which may be NULL
+// for top-level ones. It takes the parent ID twice.
+const sameParent = "(parent_id = ? OR (? IS NULL AND parent_id IS NULL))"
+
+// GetCollection retrieves a collection by ID.
+func (r *CollectionsRepo) GetCollection(ctx context.Context, id int) (_ Collection, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return getCollection(ctx, r.db, "id = ?", id)
+}
+
+// GetCollectionByShareToken retrieves the collection a share link points at.
+func (r *CollectionsRepo) GetCollectionByShareToken(ctx context.Context, token string) (_ Collection, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return getCollection(ctx, r.db, "share_token = ?", token)
+}
+
+func getCollection(ctx context.Context, db DBTX, where string, args ...any) (Collection, error) {
+	query := "SELECT " + collectionColumns + " FROM collections WHERE " + where
+	collection, err := scanCollection(db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Collection{}, ErrCollectionNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving collection:", err)
+		return Collection{}, err
+	}
+	return collection, nil
+}
+
+// ownedCollection retrieves a collection of the user, reporting other
+// users' collections as not found.
+func ownedCollection(ctx context.Context, db DBTX, userID, id int) (Collection, error) {
+	return getCollection(ctx, db, "id = ? AND user_id = ?", id, userID)
+}
+
+// GetCollectionsByUser lists every collection of a user, parents before
+// their children and siblings in position order.
+func (r *CollectionsRepo) GetCollectionsByUser(ctx context.Context, userID int) (_ []Collection, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	collections, err := queryCollections(ctx, r.db, "user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	byParent := map[int][]Collection{}
+	for _, c := range collections {
+		parent := 0
+		if c.ParentID != nil {
+			parent = *c.ParentID
+		}
+		byParent[parent] = append(byParent[parent], c)
+	}
+
+	ordered := make([]Collection, 0, len(collections))
+	var walk func(parent int)
+	walk = func(parent int) {
+		for _, c := range byParent[parent] {
+			ordered = append(ordered, c)
+			walk(c.ID)
+		}
+	}
+	walk(0)
+	return ordered, nil
+}
+
+func queryCollections(ctx context.Context, db DBTX, where string, args ...any) ([]Collection, error) {
+	query := "SELECT " + collectionColumns + " FROM collections WHERE " + where + " ORDER BY position, id"
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Error retrieving collections:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			log.Println("Error scanning collection:", err)
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// UpdateCollection changes the name, description and visibility of a
+// collection the user owns.
+func (r *CollectionsRepo) UpdateCollection(ctx context.Context, userID, id int, collection Collection) (_ Collection, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		if _, err := ownedCollection(ctx, tx, userID, id); err != nil {
+			return err
+		}
+		query := "UPDATE collections SET name = ?, description = ?, visibility = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?"
+		if _, err := tx.ExecContext(ctx, query, collection.Name, collection.Description, collection.Visibility, id); err != nil {
+			log.Println("Error updating collection:", err)
+			return err
+		}
+		var err error
+		collection, err = getCollection(ctx, tx, "id = ?", id)
+		return err
+	})
+	if err != nil {
+		return Collection{}, err
+	}
+	return collection, nil
+}
+
+// MoveCollection moves a collection the user owns under a new parent, nil
+// for the top level, at the given position among its new siblings.
+// Positions out of range move it to the end.
+func (r *CollectionsRepo) MoveCollection(ctx context.Context, userID, id int, parentID *int, position int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		collection, err := ownedCollection(ctx, tx, userID, id)
+		if err != nil {
+			return err
+		}
+
+		// Walk up from the new parent: meeting the collection on the way
+		// means it would end up inside itself.
+		for ancestor := parentID; ancestor != nil; {
+			if *ancestor == id {
+				return ErrCollectionCycle
+			}
+			parent, err := ownedCollection(ctx, tx, userID, *ancestor)
+			if err != nil {
+				return err
+			}
+			ancestor = parent.ParentID
+		}
+
+		query := "UPDATE collections SET parent_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?"
+		if _, err := tx.ExecContext(ctx, query, parentID, id); err != nil {
+			log.Println("Error moving collection:", err)
+			return err
+		}
+		if err := renumberCollections(ctx, tx, userID, collection.ParentID, 0, -1); err != nil {
+			return err
+		}
+		return renumberCollections(ctx, tx, userID, parentID, id, position)
+	})
+}
+
+// renumberCollections rewrites the positions of the user's collections
+// under parent so that they are contiguous, placing the collection with
+// the given ID, if any, at position.
+func renumberCollections(ctx context.Context, tx DBTX, userID int, parentID *int, id, position int) error {
+	siblings, err := queryCollections(ctx, tx, "user_id = ? AND "+sameParent, userID, parentID, parentID)
+	if err != nil {
+		return err
+	}
+	ids := make([]int, 0, len(siblings))
+	for _, c := range siblings {
+		if c.ID != id {
+			ids = append(ids, c.ID)
+		}
+	}
+	if id != 0 {
+		ids = insertAt(ids, id, position)
+	}
+	for i, siblingID := range ids {
+		if _, err := tx.ExecContext(ctx, "UPDATE collections SET position = ? WHERE id = ?", i, siblingID); err != nil {
+			log.Println("Error ordering collections:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteCollection deletes a collection the user owns together with every
+// collection nested in it. The snippets themselves are left alone.
+func (r *CollectionsRepo) DeleteCollection(ctx context.Context, userID, id int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		collection, err := ownedCollection(ctx, tx, userID, id)
+		if err != nil {
+			return err
+		}
+		ids, err := subtreeIDs(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		// Children go first so that no collection is left pointing at a
+		// deleted parent.
+		for i := len(ids) - 1; i >= 0; i-- {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM collection_items WHERE collection_id = ?", ids[i]); err != nil {
+				log.Println("Error deleting collection items:", err)
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM collections WHERE id = ?", ids[i]); err != nil {
+				log.Println("Error deleting collection:", err)
+				return err
+			}
+		}
+		return renumberCollections(ctx, tx, userID, collection.ParentID, 0, -1)
+	})
+}
+
+// subtreeIDs returns the ID of a collection and of every collection nested
+// in it, parents before children.
+func subtreeIDs(ctx context.Context, db DBTX, id int) ([]int, error) {
+	ids := []int{id}
+	for i := 0; i < len(ids); i++ {
+		children, err := queryCollections(ctx, db, "parent_id = ?", ids[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids, nil
+}
+
+// SetShareToken sets or, with nil, revokes the share link of a collection
+// the user owns.
+func (r *CollectionsRepo) SetShareToken(ctx context.Context, userID, id int, token *string) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		if _, err := ownedCollection(ctx, tx, userID, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE collections SET share_token = ? WHERE id = ?", token, id); err != nil {
+			log.Println("Error sharing collection:", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// SetItem adds a snippet to a collection the user owns, or moves it if it
+// is already there. A nil position, or one out of range, puts it at the
+// end. Burn-after-read snippets cannot be collected, nor can snippets the
+// user may not see.
+func (r *CollectionsRepo) SetItem(ctx context.Context, userID, id, snippetID int, position *int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		if _, err := ownedCollection(ctx, tx, userID, id); err != nil {
+			return err
+		}
+		var visible bool
+		query := "SELECT EXISTS (SELECT 1 FROM snippets WHERE id = ? AND burn_after_read = 0 AND " + notExpired + " AND " + visibleTo + ")"
+		if err := tx.QueryRowContext(ctx, query, snippetID, userID, userID).Scan(&visible); err != nil {
+			log.Println("Error retrieving snippet:", err)
+			return err
+		}
+		if !visible {
+			return ErrSnippetNotFound
+		}
+
+		ids, err := itemIDs(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		found := false
+		for i, itemID := range ids {
+			if itemID == snippetID {
+				ids = append(ids[:i], ids[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			query := "INSERT INTO collection_items (collection_id, snippet_id, position) VALUES (?, ?, ?)"
+			if _, err := tx.ExecContext(ctx, query, id, snippetID, len(ids)); err != nil {
+				log.Println("Error adding snippet to collection:", err)
+				return err
+			}
+		}
+		if position == nil {
+			ids = append(ids, snippetID)
+		} else {
+			ids = insertAt(ids, snippetID, *position)
+		}
+		return renumberItems(ctx, tx, id, ids)
+	})
+}
+
+// RemoveItem takes a snippet out of a collection the user owns.
+func (r *CollectionsRepo) RemoveItem(ctx context.Context, userID, id, snippetID int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		if _, err := ownedCollection(ctx, tx, userID, id); err != nil {
+			return err
+		}
+		query := "DELETE FROM collection_items WHERE collection_id = ? AND snippet_id = ?"
+		if _, err := tx.ExecContext(ctx, query, id, snippetID); err != nil {
+			log.Println("Error removing snippet from collection:", err)
+			return err
+		}
+		ids, err := itemIDs(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return renumberItems(ctx, tx, id, ids)
+	})
+}
+
+func itemIDs(ctx context.Context, db DBTX, id int) ([]int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT snippet_id FROM collection_items WHERE collection_id = ? ORDER BY position, snippet_id", id)
+	if err != nil {
+		log.Println("Error retrieving collection items:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var snippetID int
+		if err := rows.Scan(&snippetID); err != nil {
+			log.Println("Error scanning collection item:", err)
+			return nil, err
+		}
+		ids = append(ids, snippetID)
+	}
+	return ids, rows.Err()
+}
+
+func renumberItems(ctx context.Context, tx DBTX, id int, snippetIDs []int) error {
+	query := "UPDATE collection_items SET position = ? WHERE collection_id = ? AND snippet_id = ?"
+	for i, snippetID := range snippetIDs {
+		if _, err := tx.ExecContext(ctx, query, i, id, snippetID); err != nil {
+			log.Println("Error ordering collection items:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// insertAt inserts id into ids at position, or appends it if position is
+// out of range.
+func insertAt(ids []int, id, position int) []int {
+	if position < 0 || position >= len(ids) {
+		return append(ids, id)
+	}
+	ids = append(ids[:position+1], ids[position:]...)
+	ids[position] = id
+	return ids
+}
+
+// GetTree loads a collection with its snippets and nested collections, as
+// seen by the viewer, zero for anonymous ones. With onlyPublic, private
+// nested collections and everything below them are left out. Expired and
+// burn-after-read snippets are never included, and neither are snippets
+// the viewer may not see, e.g. those of organizations the collection's
+// owner belongs to and the viewer does not.
+func (r *CollectionsRepo) GetTree(ctx context.Context, id, viewerID int, onlyPublic bool) (_ CollectionTree, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	collection, err := getCollection(ctx, r.db, "id = ?", id)
+	if err != nil {
+		return CollectionTree{}, err
+	}
+	return r.loadTree(ctx, collection, viewerID, onlyPublic)
+}
+
+func (r *CollectionsRepo) loadTree(ctx context.Context, collection Collection, viewerID int, onlyPublic bool) (CollectionTree, error) {
+	tree := CollectionTree{Collection: collection, Snippets: []Snippet{}, Children: []CollectionTree{}}
+
+	query := `
+        SELECT ` + snippetColumns + `
+        FROM snippets
+        WHERE id IN (SELECT snippet_id FROM collection_items WHERE collection_id = ?)
+          AND burn_after_read = 0 AND ` + notExpired + ` AND ` + visibleTo + `
+        ORDER BY (SELECT position FROM collection_items WHERE collection_items.snippet_id = snippets.id AND collection_items.collection_id = ?), id
+    `
+	rows, err := r.db.QueryContext(ctx, query, collection.ID, viewerID, viewerID, collection.ID)
+	if err != nil {
+		log.Println("Error retrieving collection snippets:", err)
+		return CollectionTree{}, err
+	}
+	for rows.Next() {
+		snippet, err := scanSnippet(rows)
+		if err != nil {
+			rows.Close()
+			log.Println("Error scanning snippet:", err)
+			return CollectionTree{}, err
+		}
+		tree.Snippets = append(tree.Snippets, snippet)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return CollectionTree{}, err
+	}
+	if err := loadTags(ctx, r.db, tree.Snippets); err != nil {
+		return CollectionTree{}, err
+	}
+
+	children, err := queryCollections(ctx, r.db, "parent_id = ?", collection.ID)
+	if err != nil {
+		return CollectionTree{}, err
+	}
+	for _, child := range children {
+		if onlyPublic && child.Visibility != VisibilityPublic {
+			continue
+		}
+		subtree, err := r.loadTree(ctx, child, viewerID, onlyPublic)
+		if err != nil {
+			return CollectionTree{}, err
+		}
+		tree.Children = append(tree.Children, subtree)
+	}
+	return tree, nil
+}
@@ -0,0 +1,358 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"snippetier/apperr"
+	"snippetier/diff"
+	"time"
+)
+
+// Comment is a comment on a snippet. Root comments start a thread and may be
+// anchored to lines of the snippet; replies belong to a root comment.
+type Comment struct {
+	ID        int     `json:"id"`
+	SnippetID int     `json:"snippetId"`
+	ParentID  *int    `json:"parentId,omitempty"`
+	AuthorID  int     `json:"authorId"`
+	Body      string  `json:"body"`
+	Anchor    *Anchor `json:"anchor,omitempty"`
+	// Deleted is set when the author deleted the comment and RemovedBy
+	// when a moderator removed it. Either way the body is no longer shown.
+	Deleted   bool   `json:"deleted"`
+	RemovedBy *int   `json:"removedBy,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// Anchor is an inclusive, 1-based line range of a snippet revision. When
+// the content changes the anchor follows its lines to the new revision, or
+// is marked outdated, keeping the revision it last applied to, if any of
+// them changed.
+type Anchor struct {
+	Revision  int  `json:"revision"`
+	LineStart int  `json:"lineStart"`
+	LineEnd   int  `json:"lineEnd"`
+	Outdated  bool `json:"outdated"`
+}
+
+// ErrCommentNotFound is returned when a comment does not exist on the snippet.
+var ErrCommentNotFound = apperr.New(apperr.ErrNotFound, "comment not found")
+
+const commentColumns = "id, snippet_id, parent_id, author_id, body, anchor_revision, line_start, line_end, outdated, deleted, removed_by, created_at, updated_at"
+
+func scanComment(row rowScanner) (Comment, error) {
+	var comment Comment
+	var revision, lineStart, lineEnd sql.NullInt64
+	var outdated bool
+	err := row.Scan(&comment.ID, &comment.SnippetID, &comment.ParentID, &comment.AuthorID, &comment.Body, &revision, &lineStart, &lineEnd, &outdated, &comment.Deleted, &comment.RemovedBy, &comment.CreatedAt, &comment.UpdatedAt)
+	if revision.Valid {
+		comment.Anchor = &Anchor{Revision: int(revision.Int64), LineStart: int(lineStart.Int64), LineEnd: int(lineEnd.Int64), Outdated: outdated}
+	}
+	return comment, err
+}
+
+type CommentsRepo struct {
+	db      DBTX
+	timeout time.Duration
+}
+
+// NewCommentsRepo returns a repo whose queries are bounded by timeout unless
+// the caller's context sets an earlier deadline. Zero means no default.
+func NewCommentsRepo(db DBTX, timeout time.Duration) *CommentsRepo {
+	return &CommentsRepo{db, timeout}
+}
+
+// AddComment stores a comment on a visible snippet. A reply to a reply is
+// attached to the root of the thread, and replies cannot be anchored. An
+// anchor without a revision refers to the current one, and an anchor on an
+// older revision is carried forward to the current one.
+func (r *CommentsRepo) AddComment(ctx context.Context, comment Comment) (_ Comment, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		var revision int
+		var content string
+		query := "SELECT revision, content FROM snippets WHERE id = ? AND " + notExpired
+		err := tx.QueryRowContext(ctx, query, comment.SnippetID).Scan(&revision, &content)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSnippetNotFound
+		}
+		if err != nil {
+			log.Println("Error retrieving snippet:", err)
+			return err
+		}
+
+		if comment.ParentID != nil {
+			parent, err := getComment(ctx, tx, comment.SnippetID, *comment.ParentID)
+			if err != nil {
+				return err
+			}
+			if parent.ParentID != nil {
+				comment.ParentID = parent.ParentID
+			}
+			comment.Anchor = nil
+		}
+
+		if comment.Anchor != nil {
+			if comment.Anchor.Revision == 0 {
+				comment.Anchor.Revision = revision
+			}
+			anchorContent := content
+			if comment.Anchor.Revision != revision {
+				rev, err := getRevision(ctx, tx, comment.SnippetID, comment.Anchor.Revision)
+				if err != nil {
+					return err
+				}
+				anchorContent = rev.Content
+			}
+			lines := len(diff.SplitLines(anchorContent))
+			if comment.Anchor.LineStart < 1 || comment.Anchor.LineEnd < comment.Anchor.LineStart || comment.Anchor.LineEnd > lines {
+				return apperr.Invalid("anchor", "must be a line range within the revision")
+			}
+			if comment.Anchor.Revision != revision {
+				remapAnchor(comment.Anchor, anchorContent, content, revision)
+			}
+		}
+
+		var anchorRevision, lineStart, lineEnd *int
+		var outdated bool
+		if a := comment.Anchor; a != nil {
+			anchorRevision, lineStart, lineEnd, outdated = &a.Revision, &a.LineStart, &a.LineEnd, a.Outdated
+		}
+		query = `
+            INSERT INTO comments (snippet_id, parent_id, author_id, body, anchor_revision, line_start, line_end, outdated)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        `
+		res, err := tx.ExecContext(ctx, query, comment.SnippetID, comment.ParentID, comment.AuthorID, comment.Body, anchorRevision, lineStart, lineEnd, outdated)
+		if err != nil {
+			log.Println("Error saving comment:", err)
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			log.Println("Error getting last insert ID:", err)
+			return err
+		}
+		commentID := int(id)
+		if err := recordActivity(ctx, tx, comment.AuthorID, ActivityCommentCreated, comment.SnippetID, &commentID); err != nil {
+			return err
+		}
+
+		comment, err = getComment(ctx, tx, comment.SnippetID, commentID)
+		return err
+	})
+	if err != nil {
+		return Comment{}, err
+	}
+	return comment, nil
+}
+
+// GetComments lists every comment on a snippet, oldest first.
+func (r *CommentsRepo) GetComments(ctx context.Context, snippetID int) (_ []Comment, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT " + commentColumns + " FROM comments WHERE snippet_id = ? ORDER BY id"
+	return r.listComments(ctx, query, snippetID)
+}
+
+// GetCommentsByAuthor lists the comments a user wrote that are still shown,
+// oldest first.
+func (r *CommentsRepo) GetCommentsByAuthor(ctx context.Context, authorID int) (_ []Comment, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT " + commentColumns + " FROM comments WHERE author_id = ? AND deleted = 0 AND removed_by IS NULL ORDER BY id"
+	return r.listComments(ctx, query, authorID)
+}
+
+func (r *CommentsRepo) listComments(ctx context.Context, query string, args ...any) ([]Comment, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Error retrieving comments:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			log.Println("Error scanning comment:", err)
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+// ImportComment stores a comment restored from a backup as it is, keeping
+// its anchor and timestamps. Its snippet, and parent if any, must already
+// be restored under their new IDs.
+func (r *CommentsRepo) ImportComment(ctx context.Context, comment Comment) (_ Comment, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	var anchorRevision, lineStart, lineEnd *int
+	var outdated bool
+	if a := comment.Anchor; a != nil {
+		anchorRevision, lineStart, lineEnd, outdated = &a.Revision, &a.LineStart, &a.LineEnd, a.Outdated
+	}
+	query := `
+        INSERT INTO comments (snippet_id, parent_id, author_id, body, anchor_revision, line_start, line_end, outdated, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, COALESCE(NULLIF(?, ''), CURRENT_TIMESTAMP), COALESCE(NULLIF(?, ''), CURRENT_TIMESTAMP))
+    `
+	res, err := r.db.ExecContext(ctx, query, comment.SnippetID, comment.ParentID, comment.AuthorID, comment.Body, anchorRevision, lineStart, lineEnd, outdated, comment.CreatedAt, comment.UpdatedAt)
+	if err != nil {
+		log.Println("Error importing comment:", err)
+		return Comment{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Println("Error getting last insert ID:", err)
+		return Comment{}, err
+	}
+	comment.ID = int(id)
+	return comment, nil
+}
+
+// GetComment retrieves a single comment on a snippet.
+func (r *CommentsRepo) GetComment(ctx context.Context, snippetID, id int) (_ Comment, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return getComment(ctx, r.db, snippetID, id)
+}
+
+func getComment(ctx context.Context, db DBTX, snippetID, id int) (Comment, error) {
+	query := "SELECT " + commentColumns + " FROM comments WHERE id = ? AND snippet_id = ?"
+	comment, err := scanComment(db.QueryRowContext(ctx, query, id, snippetID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Comment{}, ErrCommentNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving comment:", err)
+		return Comment{}, err
+	}
+	return comment, nil
+}
+
+// UpdateComment replaces the body of a comment that is still shown.
+func (r *CommentsRepo) UpdateComment(ctx context.Context, snippetID, id int, body string) (_ Comment, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	var comment Comment
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		query := `
+            UPDATE comments SET body = ?, updated_at = CURRENT_TIMESTAMP
+            WHERE id = ? AND snippet_id = ? AND deleted = 0 AND removed_by IS NULL
+        `
+		if _, err := tx.ExecContext(ctx, query, body, id, snippetID); err != nil {
+			log.Println("Error updating comment:", err)
+			return err
+		}
+		var err error
+		comment, err = getComment(ctx, tx, snippetID, id)
+		return err
+	})
+	if err != nil {
+		return Comment{}, err
+	}
+	return comment, nil
+}
+
+// DeleteComment hides a comment on behalf of its author. The row is kept,
+// with its body cleared, so that replies stay in their thread.
+func (r *CommentsRepo) DeleteComment(ctx context.Context, snippetID, id int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "UPDATE comments SET deleted = 1, body = '' WHERE id = ? AND snippet_id = ?"
+	if _, err = r.db.ExecContext(ctx, query, id, snippetID); err != nil {
+		log.Println("Error deleting comment:", err)
+	}
+	return err
+}
+
+// RemoveComment hides a comment on behalf of a moderator. The body is kept
+// for the audit trail but no longer shown.
+func (r *CommentsRepo) RemoveComment(ctx context.Context, snippetID, id, moderatorID int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "UPDATE comments SET removed_by = ? WHERE id = ? AND snippet_id = ? AND removed_by IS NULL"
+	if _, err = r.db.ExecContext(ctx, query, moderatorID, id, snippetID); err != nil {
+		log.Println("Error removing comment:", err)
+	}
+	return err
+}
+
+// remapAnchors carries the current anchors of a snippet's comments from its
+// old content over to its new content at the given revision. It runs in the
+// transaction that changes the content.
+func remapAnchors(ctx context.Context, tx DBTX, snippetID int, oldContent, newContent string, revision int) error {
+	query := "SELECT id, anchor_revision, line_start, line_end FROM comments WHERE snippet_id = ? AND anchor_revision IS NOT NULL AND outdated = 0"
+	rows, err := tx.QueryContext(ctx, query, snippetID)
+	if err != nil {
+		log.Println("Error retrieving anchors:", err)
+		return err
+	}
+	anchors := map[int]*Anchor{}
+	for rows.Next() {
+		var id int
+		a := &Anchor{}
+		if err := rows.Scan(&id, &a.Revision, &a.LineStart, &a.LineEnd); err != nil {
+			rows.Close()
+			log.Println("Error scanning anchor:", err)
+			return err
+		}
+		anchors[id] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(anchors) == 0 {
+		return nil
+	}
+
+	lineMap := diff.MapLines(diff.SplitLines(oldContent), diff.SplitLines(newContent))
+	for id, a := range anchors {
+		mapAnchor(a, lineMap, revision)
+		query := "UPDATE comments SET anchor_revision = ?, line_start = ?, line_end = ?, outdated = ? WHERE id = ?"
+		if _, err := tx.ExecContext(ctx, query, a.Revision, a.LineStart, a.LineEnd, a.Outdated, id); err != nil {
+			log.Println("Error updating anchor:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// remapAnchor carries a single anchor from oldContent to newContent.
+func remapAnchor(a *Anchor, oldContent, newContent string, revision int) {
+	mapAnchor(a, diff.MapLines(diff.SplitLines(oldContent), diff.SplitLines(newContent)), revision)
+}
+
+// mapAnchor moves the anchor to revision if every line in its range was
+// kept, in order and without insertions between them, and marks it
+// outdated otherwise.
+func mapAnchor(a *Anchor, lineMap []int, revision int) {
+	if a.LineStart < 1 || a.LineEnd > len(lineMap) {
+		a.Outdated = true
+		return
+	}
+	start := lineMap[a.LineStart-1]
+	for line := a.LineStart; line <= a.LineEnd; line++ {
+		if start < 0 || lineMap[line-1] != start+line-a.LineStart {
+			a.Outdated = true
+			return
+		}
+	}
+	a.Revision = revision
+	a.LineStart, a.LineEnd = start+1, start+1+a.LineEnd-a.LineStart
+}
@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestImportComment(t *testing.T) {
+	ctx := context.Background()
+	conn := newTestConn(t)
+	author := newTestUser(t, conn, "author")
+	snippet := newTestSnippet(t, conn, author, Snippet{Name: "s", Content: "a\nb\n"})
+
+	comments := NewCommentsRepo(conn, 0)
+	root, err := comments.ImportComment(ctx, Comment{SnippetID: snippet.ID, AuthorID: author, Body: "root", Anchor: &Anchor{Revision: 1, LineStart: 1, LineEnd: 2}, CreatedAt: "2026-01-02T03:04:05Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := comments.ImportComment(ctx, Comment{SnippetID: snippet.ID, ParentID: &root.ID, AuthorID: author, Body: "reply"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := comments.AddComment(ctx, Comment{SnippetID: snippet.ID, AuthorID: author, Body: "deleted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := comments.DeleteComment(ctx, snippet.ID, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := comments.GetCommentsByAuthor(ctx, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != root.ID || got[1].ID != reply.ID {
+		t.Fatalf("comments by author = %+v, want the root and the reply", got)
+	}
+	if got[0].Anchor == nil || *got[0].Anchor != *root.Anchor || got[0].CreatedAt != "2026-01-02T03:04:05Z" {
+		t.Errorf("imported root = %+v, want its anchor and timestamp kept", got[0])
+	}
+	if got[1].ParentID == nil || *got[1].ParentID != root.ID {
+		t.Errorf("imported reply = %+v, want a reply to %d", got[1], root.ID)
+	}
+}
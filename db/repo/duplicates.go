@@ -0,0 +1,443 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"snippetier/apperr"
+	"snippetier/dedup"
+	"sort"
+	"strings"
+)
+
+// maxDuplicates caps the likely duplicates listed for a snippet.
+const maxDuplicates = 10
+
+// Duplicate is a snippet that is likely a near-duplicate of another, with
+// the estimated similarity of their content, between 0 and 1. In a cluster
+// it is the similarity to the closest other member.
+type Duplicate struct {
+	ID         int     `json:"id"`
+	Name       string  `json:"name"`
+	UserID     int     `json:"userId"`
+	OrgID      *int    `json:"orgId,omitempty"`
+	Stars      int     `json:"stars"`
+	Similarity float64 `json:"similarity"`
+	CreatedAt  string  `json:"createdAt"`
+
+	forkedFrom *int
+	signature  dedup.Signature
+}
+
+// DuplicateCluster is a group of snippets that are near-duplicates of one
+// another, most starred first.
+type DuplicateCluster struct {
+	Snippets []Duplicate `json:"snippets"`
+}
+
+// duplicateColumns are read by scanDuplicate, from snippets joined with
+// their signatures.
+const duplicateColumns = "id, name, user_id, org_id, forked_from, " + starCount + ", created_at, signature"
+
+func scanDuplicate(row rowScanner) (Duplicate, error) {
+	var d Duplicate
+	var signature string
+	if err := row.Scan(&d.ID, &d.Name, &d.UserID, &d.OrgID, &d.forkedFrom, &d.Stars, &d.CreatedAt, &signature); err != nil {
+		return Duplicate{}, err
+	}
+	sig, err := dedup.ParseSignature(signature)
+	if err != nil {
+		return Duplicate{}, err
+	}
+	d.signature = sig
+	return d, nil
+}
+
+// forkOf reports whether one of the snippets is a fork of the other. Forks
+// are deliberate copies and not reported as duplicates.
+func forkOf(a, b Duplicate) bool {
+	return a.forkedFrom != nil && *a.forkedFrom == b.ID || b.forkedFrom != nil && *b.forkedFrom == a.ID
+}
+
+// setSignature stores the signature of a snippet's content, replacing the
+// previous one. Blank content gets none.
+func setSignature(ctx context.Context, db DBTX, snippetID int, content string) error {
+	for _, table := range []string{"snippet_bands", "snippet_signatures"} {
+		if _, err := db.ExecContext(ctx, "DELETE FROM "+table+" WHERE snippet_id = ?", snippetID); err != nil {
+			log.Println("Error clearing signature:", err)
+			return err
+		}
+	}
+
+	sig, ok := dedup.Sign(content)
+	if !ok {
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO snippet_signatures (snippet_id, signature) VALUES (?, ?)", snippetID, sig.String()); err != nil {
+		log.Println("Error saving signature:", err)
+		return err
+	}
+	buckets := sig.Buckets()
+	args := make([]any, 0, 3*len(buckets))
+	for band, bucket := range buckets {
+		args = append(args, snippetID, band, bucket)
+	}
+	query := "INSERT INTO snippet_bands (snippet_id, band, bucket) VALUES " + strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", len(buckets)), ", ")
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		log.Println("Error saving signature bands:", err)
+		return err
+	}
+	return nil
+}
+
+// FindDuplicates lists the snippets the user may see that are likely
+// near-duplicates of a snippet, most similar first. Burn-after-read
+// snippets and forks of one another are left out.
+func (r *SnippetsRepo) FindDuplicates(ctx context.Context, userId, snippetID int) (_ []Duplicate, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT " + duplicateColumns + " FROM snippets JOIN snippet_signatures ON snippet_id = id WHERE id = ?"
+	self, err := scanDuplicate(r.db.QueryRowContext(ctx, query, snippetID))
+	if errors.Is(err, sql.ErrNoRows) {
+		// Blank content has no signature, and no duplicates.
+		return []Duplicate{}, nil
+	}
+	if err != nil {
+		log.Println("Error retrieving signature:", err)
+		return nil, err
+	}
+
+	query = "SELECT " + duplicateColumns + `
+        FROM snippets JOIN snippet_signatures ON snippet_id = id
+        WHERE id IN (
+            SELECT other.snippet_id FROM snippet_bands AS other
+            JOIN snippet_bands AS mine ON mine.band = other.band AND mine.bucket = other.bucket
+            WHERE mine.snippet_id = ? AND other.snippet_id != mine.snippet_id
+        ) AND burn_after_read = 0 AND ` + notExpired + " AND " + visibleTo
+	rows, err := r.db.QueryContext(ctx, query, snippetID, userId, userId)
+	if err != nil {
+		log.Println("Error retrieving duplicate candidates:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	duplicates := []Duplicate{}
+	for rows.Next() {
+		d, err := scanDuplicate(rows)
+		if err != nil {
+			log.Println("Error scanning duplicate candidate:", err)
+			return nil, err
+		}
+		d.Similarity = dedup.Similarity(self.signature, d.signature)
+		if d.Similarity >= dedup.Threshold && !forkOf(self, d) {
+			duplicates = append(duplicates, d)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].Similarity != duplicates[j].Similarity {
+			return duplicates[i].Similarity > duplicates[j].Similarity
+		}
+		return duplicates[i].ID < duplicates[j].ID
+	})
+	if len(duplicates) > maxDuplicates {
+		duplicates = duplicates[:maxDuplicates]
+	}
+	return duplicates, nil
+}
+
+// GetDuplicateClusters groups every snippet into clusters of likely
+// near-duplicates, largest cluster first, for an administrator to review.
+// Snippets saved before signatures were kept are signed first.
+func (r *SnippetsRepo) GetDuplicateClusters(ctx context.Context) (_ []DuplicateCluster, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	if err := r.signUnsigned(ctx); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + duplicateColumns + `
+        FROM snippets JOIN snippet_signatures ON snippet_id = id
+        WHERE id IN (
+            SELECT a.snippet_id FROM snippet_bands AS a
+            JOIN snippet_bands AS b ON b.band = a.band AND b.bucket = a.bucket AND b.snippet_id != a.snippet_id
+        ) AND burn_after_read = 0 AND ` + notExpired
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		log.Println("Error retrieving duplicate candidates:", err)
+		return nil, err
+	}
+	candidates := make(map[int]*Duplicate)
+	for rows.Next() {
+		d, err := scanDuplicate(rows)
+		if err != nil {
+			rows.Close()
+			log.Println("Error scanning duplicate candidate:", err)
+			return nil, err
+		}
+		candidates[d.ID] = &d
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+        SELECT DISTINCT a.snippet_id, b.snippet_id FROM snippet_bands AS a
+        JOIN snippet_bands AS b ON b.band = a.band AND b.bucket = a.bucket AND b.snippet_id > a.snippet_id
+    `
+	rows, err = r.db.QueryContext(ctx, query)
+	if err != nil {
+		log.Println("Error retrieving duplicate pairs:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Join the confirmed pairs into clusters with a union-find.
+	parent := make(map[int]int)
+	var find func(int) int
+	find = func(id int) int {
+		if p, ok := parent[id]; ok && p != id {
+			parent[id] = find(p)
+			return parent[id]
+		}
+		parent[id] = id
+		return id
+	}
+	for rows.Next() {
+		var aID, bID int
+		if err := rows.Scan(&aID, &bID); err != nil {
+			log.Println("Error scanning duplicate pair:", err)
+			return nil, err
+		}
+		a, b := candidates[aID], candidates[bID]
+		if a == nil || b == nil || forkOf(*a, *b) {
+			continue
+		}
+		similarity := dedup.Similarity(a.signature, b.signature)
+		if similarity < dedup.Threshold {
+			continue
+		}
+		a.Similarity, b.Similarity = max(a.Similarity, similarity), max(b.Similarity, similarity)
+		parent[find(aID)] = find(bID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	members := make(map[int][]Duplicate)
+	for id := range parent {
+		root := find(id)
+		members[root] = append(members[root], *candidates[id])
+	}
+	clusters := make([]DuplicateCluster, 0, len(members))
+	for _, snippets := range members {
+		sort.Slice(snippets, func(i, j int) bool {
+			if snippets[i].Stars != snippets[j].Stars {
+				return snippets[i].Stars > snippets[j].Stars
+			}
+			return snippets[i].ID < snippets[j].ID
+		})
+		clusters = append(clusters, DuplicateCluster{Snippets: snippets})
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		a, b := clusters[i].Snippets, clusters[j].Snippets
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a[0].ID < b[0].ID
+	})
+	return clusters, nil
+}
+
+// signUnsigned stores the signatures of the snippets that have none.
+func (r *SnippetsRepo) signUnsigned(ctx context.Context) error {
+	query := "SELECT id, content FROM snippets WHERE id NOT IN (SELECT snippet_id FROM snippet_signatures) AND " + notExpired
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		log.Println("Error retrieving unsigned snippets:", err)
+		return err
+	}
+	type unsigned struct {
+		id      int
+		content string
+	}
+	var pending []unsigned
+	for rows.Next() {
+		var u unsigned
+		if err := rows.Scan(&u.id, &u.content); err != nil {
+			rows.Close()
+			log.Println("Error scanning unsigned snippet:", err)
+			return err
+		}
+		pending = append(pending, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		for _, u := range pending {
+			if err := setSignature(ctx, tx, u.id, u.content); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+var (
+	// ErrMergeSelf is returned when a snippet would be merged into itself.
+	ErrMergeSelf = apperr.New(apperr.ErrConflict, "a snippet cannot be merged into itself")
+	// ErrMergeBurnAfterRead is returned when a burn-after-read snippet
+	// would be merged, which would keep its content around.
+	ErrMergeBurnAfterRead = apperr.New(apperr.ErrConflict, "burn-after-read snippets cannot be merged")
+	// ErrMergeAudience is returned when snippets shared with different
+	// audiences would be merged, e.g. a public snippet and one in an
+	// organization's library.
+	ErrMergeAudience = apperr.New(apperr.ErrConflict, "only snippets in the same organization, or both public, can be merged")
+)
+
+// MergeSnippets merges duplicates into a target snippet on behalf of
+// actorID, an administrator. The target gains the duplicates' stars and
+// tags, their collection entries, their histories, kept as merged
+// revisions, their comments, with anchors marked outdated, and their forks. The duplicates are then deleted, and their IDs, as well as
+// those of earlier duplicates merged into them, redirect to the target.
+// Burn-after-read snippets are never merged, and neither are snippets
+// whose organization differs from the target's.
+func (r *SnippetsRepo) MergeSnippets(ctx context.Context, actorID, targetID int, duplicateIDs []int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		target, err := mergeCandidate(ctx, tx, targetID)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range duplicateIDs {
+			if id == targetID {
+				return ErrMergeSelf
+			}
+			duplicate, err := mergeCandidate(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if !sameOrg(duplicate.OrgID, target.OrgID) {
+				return ErrMergeAudience
+			}
+			if err := mergeInto(ctx, tx, actorID, targetID, id); err != nil {
+				return err
+			}
+		}
+		return announce(ctx, tx, EventSnippetUpdated, actorID, targetID, nil)
+	})
+}
+
+// mergeCandidate loads the audience of a snippet about to be merged,
+// rejecting burn-after-read snippets.
+func mergeCandidate(ctx context.Context, tx DBTX, id int) (Snippet, error) {
+	var s Snippet
+	query := "SELECT id, org_id, burn_after_read FROM snippets WHERE id = ? AND " + notExpired
+	err := tx.QueryRowContext(ctx, query, id).Scan(&s.ID, &s.OrgID, &s.BurnAfterRead)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Snippet{}, ErrSnippetNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving snippet:", err)
+		return Snippet{}, err
+	}
+	if s.BurnAfterRead {
+		return Snippet{}, ErrMergeBurnAfterRead
+	}
+	return s, nil
+}
+
+func sameOrg(a, b *int) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+func mergeInto(ctx context.Context, tx DBTX, actorID, targetID, id int) error {
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`
+            INSERT INTO stars (user_id, snippet_id, created_at)
+            SELECT user_id, ?, created_at FROM stars AS s
+            WHERE snippet_id = ? AND NOT EXISTS (SELECT 1 FROM stars WHERE user_id = s.user_id AND snippet_id = ?)
+        `, []any{targetID, id, targetID}},
+		{`
+            INSERT INTO snippet_tags (snippet_id, tag)
+            SELECT ?, tag FROM snippet_tags AS t
+            WHERE snippet_id = ? AND NOT EXISTS (SELECT 1 FROM snippet_tags WHERE snippet_id = ? AND tag = t.tag)
+        `, []any{targetID, id, targetID}},
+		{`
+            INSERT INTO collection_items (collection_id, snippet_id, position, added_at)
+            SELECT collection_id, ?, position, added_at FROM collection_items AS i
+            WHERE snippet_id = ? AND NOT EXISTS (SELECT 1 FROM collection_items WHERE collection_id = i.collection_id AND snippet_id = ?)
+        `, []any{targetID, id, targetID}},
+		// The duplicate's own history is marked with its ID; histories
+		// merged into it earlier keep theirs.
+		{`
+            UPDATE snippet_revisions
+            SET snippet_id = ?, merged_from = CASE WHEN merged_from = 0 THEN ? ELSE merged_from END
+            WHERE snippet_id = ?
+        `, []any{targetID, id, id}},
+		// Anchors point at lines of the duplicate's revisions, which the
+		// target's do not share.
+		{`
+            UPDATE comments
+            SET snippet_id = ?, outdated = CASE WHEN anchor_revision IS NULL THEN outdated ELSE 1 END
+            WHERE snippet_id = ?
+        `, []any{targetID, id}},
+		// Forks follow the target from its current revision on.
+		{`
+            UPDATE snippets
+            SET forked_from = ?, forked_revision = (SELECT revision FROM snippets WHERE id = ?)
+            WHERE forked_from = ? AND id <> ?
+        `, []any{targetID, targetID, id, targetID}},
+		{"UPDATE snippet_redirects SET new_id = ? WHERE new_id = ?", []any{targetID, id}},
+		{"INSERT INTO snippet_redirects (old_id, new_id, merged_by) VALUES (?, ?, ?)", []any{id, targetID, actorID}},
+	}
+	for _, s := range statements {
+		if _, err := tx.ExecContext(ctx, s.query, s.args...); err != nil {
+			log.Println("Error merging snippet:", err)
+			return err
+		}
+	}
+
+	if err := announce(ctx, tx, EventSnippetDeleted, actorID, id, nil); err != nil {
+		return err
+	}
+	_, err := deleteSnippets(ctx, tx, "id = ?", id)
+	return err
+}
+
+// ResolveRedirect returns the snippet a merged duplicate's old ID now
+// points at, or ErrSnippetNotFound when the ID was never merged.
+func (r *SnippetsRepo) ResolveRedirect(ctx context.Context, id int) (_ int, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	var newID int
+	err = r.db.QueryRowContext(ctx, "SELECT new_id FROM snippet_redirects WHERE old_id = ?", id).Scan(&newID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrSnippetNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving redirect:", err)
+		return 0, err
+	}
+	return newID, nil
+}
@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMergeSnippetsRejectsUnsafeMerges(t *testing.T) {
+	ctx := context.Background()
+	conn := newTestConn(t)
+	author := newTestUser(t, conn, "author")
+	org, err := NewOrgsRepo(conn, 0).CreateOrg(ctx, author, "acme", "Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestSnippet(t, conn, author, Snippet{Name: "target", Content: "echo hello"})
+	burned := newTestSnippet(t, conn, author, Snippet{Name: "burned", Content: "echo hello", BurnAfterRead: true})
+	private := newTestSnippet(t, conn, author, Snippet{Name: "private", Content: "echo hello", OrgID: &org.ID})
+	public := newTestSnippet(t, conn, author, Snippet{Name: "public", Content: "echo hello"})
+
+	snippets := NewSnippetsRepo(conn, 0)
+	for _, tt := range []struct {
+		name       string
+		target     int
+		duplicates []int
+		want       error
+	}{
+		{"burn-after-read duplicate", target.ID, []int{burned.ID}, ErrMergeBurnAfterRead},
+		{"burn-after-read target", burned.ID, []int{public.ID}, ErrMergeBurnAfterRead},
+		{"organization snippet into public one", target.ID, []int{private.ID}, ErrMergeAudience},
+		{"public snippet into organization one", private.ID, []int{public.ID}, ErrMergeAudience},
+		{"after a valid duplicate", target.ID, []int{public.ID, private.ID}, ErrMergeAudience},
+	} {
+		if err := snippets.MergeSnippets(ctx, author, tt.target, tt.duplicates); !errors.Is(err, tt.want) {
+			t.Errorf("%s: MergeSnippets = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Nothing was merged.
+	for _, s := range []Snippet{target, burned, private, public} {
+		if _, err := snippets.GetSnippetByID(ctx, s.ID); err != nil {
+			t.Errorf("snippet %q after the rejected merges: %v", s.Name, err)
+		}
+	}
+
+	if err := snippets.MergeSnippets(ctx, author, target.ID, []int{public.ID}); err != nil {
+		t.Fatalf("merging a public duplicate into a public snippet: %v", err)
+	}
+	if _, err := snippets.GetSnippetByID(ctx, public.ID); !errors.Is(err, ErrSnippetNotFound) {
+		t.Errorf("merged duplicate: err = %v, want ErrSnippetNotFound", err)
+	}
+}
+
+func TestMergeSnippetsMovesCommentsAndForks(t *testing.T) {
+	ctx := context.Background()
+	conn := newTestConn(t)
+	author := newTestUser(t, conn, "author")
+	reader := newTestUser(t, conn, "reader")
+
+	target := newTestSnippet(t, conn, author, Snippet{Name: "target", Content: "echo hello"})
+	duplicate := newTestSnippet(t, conn, author, Snippet{Name: "duplicate", Content: "echo hello\n"})
+
+	comments := NewCommentsRepo(conn, 0)
+	anchored, err := comments.AddComment(ctx, Comment{SnippetID: duplicate.ID, AuthorID: reader, Body: "this line", Anchor: &Anchor{LineStart: 1, LineEnd: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := comments.AddComment(ctx, Comment{SnippetID: duplicate.ID, ParentID: &anchored.ID, AuthorID: author, Body: "agreed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snippets := NewSnippetsRepo(conn, 0)
+	fork, err := snippets.ForkSnippet(ctx, reader, duplicate.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := snippets.MergeSnippets(ctx, author, target.ID, []int{duplicate.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := comments.GetComments(ctx, target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 2 || moved[0].ID != anchored.ID || moved[1].ID != reply.ID {
+		t.Fatalf("comments on the target = %+v, want the duplicate's", moved)
+	}
+	if moved[0].Anchor == nil || !moved[0].Anchor.Outdated {
+		t.Errorf("moved anchor = %+v, want it outdated", moved[0].Anchor)
+	}
+	if moved[1].ParentID == nil || *moved[1].ParentID != anchored.ID {
+		t.Errorf("moved reply = %+v, want it still in its thread", moved[1])
+	}
+
+	fork, err = snippets.GetSnippetByID(ctx, fork.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.ForkedFrom == nil || *fork.ForkedFrom != target.ID || fork.ForkedRevision == nil || *fork.ForkedRevision != target.Revision {
+		t.Errorf("fork follows %v at revision %v, want %d at %d", fork.ForkedFrom, fork.ForkedRevision, target.ID, target.Revision)
+	}
+}
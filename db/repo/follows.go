@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"context"
+	"log"
+	"snippetier/apperr"
+)
+
+// Follow makes the follower follow another user. Following a user twice is
+// a no-op.
+func (r *UsersRepo) Follow(ctx context.Context, followerID, followeeID int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	if followerID == followeeID {
+		return apperr.Invalid("id", "users cannot follow themselves")
+	}
+
+	query := `
+        INSERT INTO follows (follower_id, followee_id)
+        SELECT ?, id FROM users
+        WHERE id = ?
+          AND NOT EXISTS (SELECT 1 FROM follows WHERE follower_id = ? AND followee_id = ?)
+    `
+	res, err := r.db.ExecContext(ctx, query, followerID, followeeID, followerID, followeeID)
+	if err != nil {
+		log.Println("Error following user:", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	// Nothing was inserted: either the follow already exists or the user
+	// does not.
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)", followeeID).Scan(&exists); err != nil {
+		log.Println("Error retrieving user:", err)
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Unfollow stops the follower following another user, if they did.
+func (r *UsersRepo) Unfollow(ctx context.Context, followerID, followeeID int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	_, err = r.db.ExecContext(ctx, "DELETE FROM follows WHERE follower_id = ? AND followee_id = ?", followerID, followeeID)
+	if err != nil {
+		log.Println("Error unfollowing user:", err)
+	}
+	return err
+}
+
+// GetFollowers lists the users following a user, most recent first.
+func (r *UsersRepo) GetFollowers(ctx context.Context, userID int) (_ []User, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return r.followUsers(ctx, "follower_id", "followee_id", userID)
+}
+
+// GetFollowing lists the users a user follows, most recent first.
+func (r *UsersRepo) GetFollowing(ctx context.Context, userID int) (_ []User, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return r.followUsers(ctx, "followee_id", "follower_id", userID)
+}
+
+// followUsers lists the users in the selected column of the follows whose
+// other column is userID.
+func (r *UsersRepo) followUsers(ctx context.Context, selected, by string, userID int) ([]User, error) {
+	query := `
+        SELECT users.id, users.username, users.email, users.full_name, users.created_at, users.updated_at
+        FROM follows JOIN users ON users.id = follows.` + selected + `
+        WHERE follows.` + by + ` = ?
+        ORDER BY follows.created_at DESC, users.id DESC
+    `
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		log.Println("Error retrieving follows:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.FullName, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			log.Println("Error scanning user:", err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
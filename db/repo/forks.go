@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"snippetier/apperr"
+)
+
+// ErrForkBurnAfterRead is returned when forking a burn-after-read snippet,
+// whose content must only ever be read once.
+var ErrForkBurnAfterRead = apperr.New(apperr.ErrConflict, "burn-after-read snippets cannot be forked")
+
+// ForkSnippet copies a snippet the user may see, with its tags, under them
+// and links the copy to the original and its current revision. The fork
+// starts its own history at revision 1 and does not inherit the expiry.
+func (r *SnippetsRepo) ForkSnippet(ctx context.Context, userId, id int) (_ Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	var fork Snippet
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		query := "SELECT " + snippetColumns + " FROM snippets WHERE id = ? AND " + notExpired + " AND " + visibleTo
+		source, err := scanSnippet(tx.QueryRowContext(ctx, query, id, userId, userId))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSnippetNotFound
+		}
+		if err != nil {
+			log.Println("Error retrieving snippet:", err)
+			return err
+		}
+		if source.BurnAfterRead {
+			return ErrForkBurnAfterRead
+		}
+		sources := []Snippet{source}
+		if err := loadTags(ctx, tx, sources); err != nil {
+			return err
+		}
+		source = sources[0]
+
+		fork = Snippet{
+			Name:           source.Name,
+			Description:    source.Description,
+			Content:        source.Content,
+			Language:       source.Language,
+			Tags:           source.Tags,
+			Revision:       1,
+			UserId:         userId,
+			ForkedFrom:     &source.ID,
+			ForkedRevision: &source.Revision,
+		}
+		query = `
+            INSERT INTO snippets (name, description, content, language, user_id, forked_from, forked_revision)
+            VALUES (?, ?, ?, ?, ?, ?, ?)
+        `
+		res, err := tx.ExecContext(ctx, query, fork.Name, fork.Description, fork.Content, fork.Language, userId, source.ID, source.Revision)
+		if err != nil {
+			log.Println("Error forking snippet:", err)
+			return err
+		}
+		newID, err := res.LastInsertId()
+		if err != nil {
+			log.Println("Error getting last insert ID:", err)
+			return err
+		}
+		fork.ID = int(newID)
+
+		if err := addRevision(ctx, tx, fork.ID, fork.Revision, userId, fork.Content); err != nil {
+			return err
+		}
+		if err := setTags(ctx, tx, fork.ID, fork.Tags); err != nil {
+			return err
+		}
+		if err := setSignature(ctx, tx, fork.ID, fork.Content); err != nil {
+			return err
+		}
+		return recordActivity(ctx, tx, userId, ActivitySnippetForked, fork.ID, nil)
+	})
+	if err != nil {
+		return Snippet{}, err
+	}
+	return fork, nil
+}
+
+// GetForks lists the visible forks of a snippet, newest first. Like every
+// other list it leaves out burn-after-read snippets.
+func (r *SnippetsRepo) GetForks(ctx context.Context, id int) (_ []Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT " + snippetColumns + " FROM snippets WHERE forked_from = ? AND burn_after_read = 0 AND " + notExpired + " ORDER BY id DESC"
+	rows, err := r.db.QueryContext(ctx, query, id)
+	if err != nil {
+		log.Println("Error retrieving forks:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var forks []Snippet
+	for rows.Next() {
+		fork, err := scanSnippet(rows)
+		if err != nil {
+			log.Println("Error scanning snippet:", err)
+			return nil, err
+		}
+		forks = append(forks, fork)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadTags(ctx, r.db, forks); err != nil {
+		return nil, err
+	}
+	return forks, nil
+}
+
+// SetForkedRevision records that the user's fork is now synced with the
+// given upstream revision. Callers check that the fork exists first.
+func (r *SnippetsRepo) SetForkedRevision(ctx context.Context, userId, id, revision int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "UPDATE snippets SET forked_revision = ? WHERE id = ? AND user_id = ? AND forked_from IS NOT NULL AND " + notExpired
+	_, err = r.db.ExecContext(ctx, query, revision, id, userId)
+	if err != nil {
+		log.Println("Error updating fork:", err)
+	}
+	return err
+}
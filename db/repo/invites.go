@@ -0,0 +1,341 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"snippetier/apperr"
+	"strings"
+)
+
+// Invite is an invitation to join an organization, addressed either to an
+// email address or to a GitHub username.
+type Invite struct {
+	ID             int     `json:"id"`
+	OrgID          int     `json:"orgId"`
+	Email          string  `json:"email,omitempty"`
+	GithubUsername string  `json:"githubUsername,omitempty"`
+	Role           Role    `json:"role"`
+	InvitedBy      int     `json:"invitedBy"`
+	Status         string  `json:"status"`
+	RespondedBy    *int    `json:"respondedBy,omitempty"`
+	ExpiresAt      string  `json:"expiresAt"`
+	CreatedAt      string  `json:"createdAt"`
+	RespondedAt    *string `json:"respondedAt,omitempty"`
+	// TokenHash is the hash of the nonce in the invitation's token.
+	TokenHash string `json:"-"`
+}
+
+// Invitation statuses. An invitation is expired when it was still pending
+// at its expiry; that status is computed, not stored.
+const (
+	InvitePending  = "pending"
+	InviteAccepted = "accepted"
+	InviteDeclined = "declined"
+	InviteRevoked  = "revoked"
+	InviteExpired  = "expired"
+)
+
+// AuditEntry records a change to an organization's membership.
+type AuditEntry struct {
+	ID       int    `json:"id"`
+	OrgID    int    `json:"orgId"`
+	ActorID  int    `json:"actorId"`
+	Action   string `json:"action"`
+	Subject  string `json:"subject"`
+	InviteID *int   `json:"inviteId,omitempty"`
+	// CreatedAt is when the change happened.
+	CreatedAt string `json:"createdAt"`
+}
+
+// Audit actions.
+const (
+	AuditInviteCreated  = "invite.created"
+	AuditInviteRevoked  = "invite.revoked"
+	AuditInviteAccepted = "invite.accepted"
+	AuditInviteDeclined = "invite.declined"
+)
+
+var (
+	// ErrInviteNotFound is returned when no invitation has the ID or the
+	// token does not belong to it.
+	ErrInviteNotFound = apperr.New(apperr.ErrNotFound, "invitation not found")
+	// ErrInviteNotPending is returned for invitations that were already
+	// accepted, declined, revoked or have expired.
+	ErrInviteNotPending = apperr.New(apperr.ErrConflict, "invitation is no longer pending")
+	// ErrInviteRecipient is returned when a user responds to an invitation
+	// addressed to somebody else.
+	ErrInviteRecipient = apperr.New(apperr.ErrForbidden, "invitation is addressed to another user")
+	// ErrAlreadyMember is returned when inviting a user who is a member.
+	ErrAlreadyMember = apperr.New(apperr.ErrConflict, "user is already a member")
+	// ErrInviteExists is returned when the recipient has a pending invitation.
+	ErrInviteExists = apperr.New(apperr.ErrConflict, "recipient already has a pending invitation")
+)
+
+const inviteColumns = `id, org_id, COALESCE(email, ''), COALESCE(github_username, ''), role, invited_by,
+        CASE WHEN status = 'pending' AND expires_at <= CURRENT_TIMESTAMP THEN 'expired' ELSE status END,
+        responded_by, expires_at, created_at, responded_at, token_hash`
+
+func scanInvite(row rowScanner) (Invite, error) {
+	var invite Invite
+	err := row.Scan(&invite.ID, &invite.OrgID, &invite.Email, &invite.GithubUsername, &invite.Role, &invite.InvitedBy, &invite.Status, &invite.RespondedBy, &invite.ExpiresAt, &invite.CreatedAt, &invite.RespondedAt, &invite.TokenHash)
+	return invite, err
+}
+
+// recipientMatch matches users by the email or GitHub username an
+// invitation is addressed to, ignoring case. It takes both, one of them
+// empty.
+const recipientMatch = "((? <> '' AND LOWER(email) = LOWER(?)) OR (? <> '' AND LOWER(username) = LOWER(?)))"
+
+// CreateInvite stores a pending invitation and records who sent it.
+// ExpiresAt must be a UTC DATETIME and TokenHash the hash of the nonce in
+// the invitation's token.
+func (r *OrgsRepo) CreateInvite(ctx context.Context, invite Invite) (_ Invite, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	email, github := invite.Email, invite.GithubUsername
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		var member bool
+		query := "SELECT EXISTS (SELECT 1 FROM org_members WHERE org_id = ? AND user_id IN (SELECT id FROM users WHERE " + recipientMatch + "))"
+		if err := tx.QueryRowContext(ctx, query, invite.OrgID, email, email, github, github).Scan(&member); err != nil {
+			log.Println("Error checking membership:", err)
+			return err
+		}
+		if member {
+			return ErrAlreadyMember
+		}
+
+		var pending bool
+		query = `
+            SELECT EXISTS (SELECT 1 FROM org_invites
+            WHERE org_id = ? AND status = 'pending' AND expires_at > CURRENT_TIMESTAMP
+              AND LOWER(COALESCE(email, '')) = LOWER(?) AND LOWER(COALESCE(github_username, '')) = LOWER(?))
+        `
+		if err := tx.QueryRowContext(ctx, query, invite.OrgID, email, github).Scan(&pending); err != nil {
+			log.Println("Error checking invitations:", err)
+			return err
+		}
+		if pending {
+			return ErrInviteExists
+		}
+
+		query = `
+            INSERT INTO org_invites (org_id, email, github_username, role, invited_by, token_hash, expires_at)
+            VALUES (?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?)
+        `
+		res, err := tx.ExecContext(ctx, query, invite.OrgID, email, github, invite.Role, invite.InvitedBy, invite.TokenHash, invite.ExpiresAt)
+		if err != nil {
+			log.Println("Error creating invitation:", err)
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			log.Println("Error getting last insert ID:", err)
+			return err
+		}
+		invite.ID = int(id)
+
+		if err := audit(ctx, tx, invite.OrgID, invite.InvitedBy, AuditInviteCreated, recipient(invite), invite.ID); err != nil {
+			return err
+		}
+		invite, err = getInvite(ctx, tx, invite.ID)
+		return err
+	})
+	if err != nil {
+		return Invite{}, err
+	}
+	return invite, nil
+}
+
+// GetInvite retrieves an invitation by ID.
+func (r *OrgsRepo) GetInvite(ctx context.Context, id int) (_ Invite, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return getInvite(ctx, r.db, id)
+}
+
+func getInvite(ctx context.Context, db DBTX, id int) (Invite, error) {
+	query := "SELECT " + inviteColumns + " FROM org_invites WHERE id = ?"
+	invite, err := scanInvite(db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Invite{}, ErrInviteNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving invitation:", err)
+		return Invite{}, err
+	}
+	return invite, nil
+}
+
+// GetInvites lists every invitation of an organization, newest first.
+func (r *OrgsRepo) GetInvites(ctx context.Context, orgID int) (_ []Invite, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT " + inviteColumns + " FROM org_invites WHERE org_id = ? ORDER BY id DESC"
+	rows, err := r.db.QueryContext(ctx, query, orgID)
+	if err != nil {
+		log.Println("Error retrieving invitations:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			log.Println("Error scanning invitation:", err)
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// RevokeInvite withdraws a pending invitation so its link stops working.
+func (r *OrgsRepo) RevokeInvite(ctx context.Context, orgID, id, actorID int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		invite, err := getInvite(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if invite.OrgID != orgID {
+			return ErrInviteNotFound
+		}
+		if err := closeInvite(ctx, tx, id, InviteRevoked, actorID); err != nil {
+			return err
+		}
+		return audit(ctx, tx, orgID, actorID, AuditInviteRevoked, recipient(invite), id)
+	})
+}
+
+// RespondToInvite accepts or declines a pending invitation on behalf of the
+// user it is addressed to. Accepting adds them to the organization with the
+// invited role, unless they already joined in the meantime. Each invitation
+// can only be answered once; the caller verifies the token beforehand.
+func (r *OrgsRepo) RespondToInvite(ctx context.Context, id, userID int, accept bool) (_ Invite, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	var invite Invite
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		var err error
+		invite, err = getInvite(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if invite.Status != InvitePending {
+			return ErrInviteNotPending
+		}
+
+		var addressed bool
+		email, github := invite.Email, invite.GithubUsername
+		query := "SELECT EXISTS (SELECT 1 FROM users WHERE id = ? AND " + recipientMatch + ")"
+		if err := tx.QueryRowContext(ctx, query, userID, email, email, github, github).Scan(&addressed); err != nil {
+			log.Println("Error retrieving user:", err)
+			return err
+		}
+		if !addressed {
+			return ErrInviteRecipient
+		}
+
+		status, action := InviteDeclined, AuditInviteDeclined
+		if accept {
+			status, action = InviteAccepted, AuditInviteAccepted
+		}
+		if err := closeInvite(ctx, tx, id, status, userID); err != nil {
+			return err
+		}
+		if accept {
+			_, err := getRole(ctx, tx, invite.OrgID, userID)
+			if errors.Is(err, ErrMemberNotFound) {
+				query := "INSERT INTO org_members (org_id, user_id, role) VALUES (?, ?, ?)"
+				_, err = tx.ExecContext(ctx, query, invite.OrgID, userID, invite.Role)
+			}
+			if err != nil {
+				log.Println("Error adding member:", err)
+				return err
+			}
+		}
+		if err := audit(ctx, tx, invite.OrgID, userID, action, recipient(invite), id); err != nil {
+			return err
+		}
+		invite, err = getInvite(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return Invite{}, err
+	}
+	return invite, nil
+}
+
+// closeInvite moves a pending, unexpired invitation to its final status.
+// The status is checked in the update itself so that concurrent responses
+// cannot both succeed.
+func closeInvite(ctx context.Context, tx DBTX, id int, status string, userID int) error {
+	query := `
+        UPDATE org_invites SET status = ?, responded_by = ?, responded_at = CURRENT_TIMESTAMP
+        WHERE id = ? AND status = 'pending' AND expires_at > CURRENT_TIMESTAMP
+    `
+	res, err := tx.ExecContext(ctx, query, status, userID, id)
+	if err != nil {
+		log.Println("Error updating invitation:", err)
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInviteNotPending
+	}
+	return nil
+}
+
+// GetAuditLog lists the membership changes of an organization, newest first.
+func (r *OrgsRepo) GetAuditLog(ctx context.Context, orgID int) (_ []AuditEntry, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT id, org_id, actor_id, action, subject, invite_id, created_at FROM org_audit WHERE org_id = ? ORDER BY id DESC"
+	rows, err := r.db.QueryContext(ctx, query, orgID)
+	if err != nil {
+		log.Println("Error retrieving audit log:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.OrgID, &entry.ActorID, &entry.Action, &entry.Subject, &entry.InviteID, &entry.CreatedAt); err != nil {
+			log.Println("Error scanning audit entry:", err)
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func audit(ctx context.Context, tx DBTX, orgID, actorID int, action, subject string, inviteID int) error {
+	query := "INSERT INTO org_audit (org_id, actor_id, action, subject, invite_id) VALUES (?, ?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, orgID, actorID, action, subject, inviteID); err != nil {
+		log.Println("Error writing audit entry:", err)
+		return err
+	}
+	return nil
+}
+
+// recipient describes who an invitation is addressed to, for the audit log.
+func recipient(invite Invite) string {
+	if invite.Email != "" {
+		return "email:" + strings.ToLower(invite.Email)
+	}
+	return "github:" + strings.ToLower(invite.GithubUsername)
+}
@@ -0,0 +1,317 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"snippetier/apperr"
+	"time"
+)
+
+// Org is an organization: a team of users sharing a snippet library.
+type Org struct {
+	ID        int    `json:"id"`
+	Slug      string `json:"slug"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// Role is a member's role in an organization.
+type Role string
+
+const (
+	// RoleOwner manages the organization and all of its members.
+	RoleOwner Role = "owner"
+	// RoleMaintainer edits the organization's snippets and adds members.
+	RoleMaintainer Role = "maintainer"
+	// RoleMember reads the library and adds snippets of their own to it.
+	RoleMember Role = "member"
+)
+
+// ParseRole validates a role given by a client.
+func ParseRole(s string) (Role, bool) {
+	switch Role(s) {
+	case RoleOwner, RoleMaintainer, RoleMember:
+		return Role(s), true
+	}
+	return "", false
+}
+
+// CanEdit reports whether the role may edit and delete every snippet of the
+// organization, not just the ones the member created.
+func (r Role) CanEdit() bool {
+	return r == RoleOwner || r == RoleMaintainer
+}
+
+// Member is a user's membership in an organization.
+type Member struct {
+	UserID    int    `json:"userId"`
+	Username  string `json:"username"`
+	Role      Role   `json:"role"`
+	CreatedAt string `json:"createdAt"`
+}
+
+var (
+	// ErrOrgNotFound is returned when no organization has the slug.
+	ErrOrgNotFound = apperr.New(apperr.ErrNotFound, "organization not found")
+	// ErrOrgExists is returned when the slug is already taken.
+	ErrOrgExists = apperr.New(apperr.ErrConflict, "an organization with this slug already exists")
+	// ErrMemberNotFound is returned when the user is not a member.
+	ErrMemberNotFound = apperr.New(apperr.ErrNotFound, "member not found")
+	// ErrLastOwner is returned when a change would leave an organization
+	// without an owner.
+	ErrLastOwner = apperr.New(apperr.ErrConflict, "an organization must keep at least one owner")
+)
+
+// editableBy restricts a snippet query to the snippets the user owns or
+// may edit as an owner or maintainer of their organization. It takes the
+// user ID twice.
+const editableBy = "(user_id = ? OR org_id IN (SELECT org_id FROM org_members WHERE user_id = ? AND role IN ('owner', 'maintainer')))"
+
+// visibleTo restricts a snippet query to the snippets the user may see:
+// public ones, their own and their organizations'. It takes the user ID
+// twice.
+const visibleTo = "(org_id IS NULL OR user_id = ? OR org_id IN (SELECT org_id FROM org_members WHERE user_id = ?))"
+
+type OrgsRepo struct {
+	db      DBTX
+	timeout time.Duration
+}
+
+// NewOrgsRepo returns a repo whose queries are bounded by timeout unless
+// the caller's context sets an earlier deadline. Zero means no default.
+func NewOrgsRepo(db DBTX, timeout time.Duration) *OrgsRepo {
+	return &OrgsRepo{db, timeout}
+}
+
+// CreateOrg creates an organization with the user as its first owner.
+func (r *OrgsRepo) CreateOrg(ctx context.Context, ownerID int, slug, name string) (_ Org, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	var org Org
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		var taken bool
+		query := "SELECT EXISTS (SELECT 1 FROM orgs WHERE LOWER(slug) = LOWER(?))"
+		if err := tx.QueryRowContext(ctx, query, slug).Scan(&taken); err != nil {
+			log.Println("Error checking organization slug:", err)
+			return err
+		}
+		if taken {
+			return ErrOrgExists
+		}
+
+		res, err := tx.ExecContext(ctx, "INSERT INTO orgs (slug, name) VALUES (?, ?)", slug, name)
+		if err != nil {
+			log.Println("Error creating organization:", err)
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			log.Println("Error getting last insert ID:", err)
+			return err
+		}
+
+		query = "INSERT INTO org_members (org_id, user_id, role) VALUES (?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, query, id, ownerID, RoleOwner); err != nil {
+			log.Println("Error adding organization owner:", err)
+			return err
+		}
+
+		org, err = getOrg(ctx, tx, "id = ?", id)
+		return err
+	})
+	if err != nil {
+		return Org{}, err
+	}
+	return org, nil
+}
+
+// GetOrg retrieves an organization by its slug, ignoring case.
+func (r *OrgsRepo) GetOrg(ctx context.Context, slug string) (_ Org, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return getOrg(ctx, r.db, "LOWER(slug) = LOWER(?)", slug)
+}
+
+// GetOrgByID retrieves an organization by ID.
+func (r *OrgsRepo) GetOrgByID(ctx context.Context, id int) (_ Org, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return getOrg(ctx, r.db, "id = ?", id)
+}
+
+func getOrg(ctx context.Context, db DBTX, where string, args ...any) (Org, error) {
+	var org Org
+	query := "SELECT id, slug, name, created_at FROM orgs WHERE " + where
+	err := db.QueryRowContext(ctx, query, args...).Scan(&org.ID, &org.Slug, &org.Name, &org.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Org{}, ErrOrgNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving organization:", err)
+		return Org{}, err
+	}
+	return org, nil
+}
+
+// GetOrgsByUser lists the organizations the user is a member of.
+func (r *OrgsRepo) GetOrgsByUser(ctx context.Context, userID int) (_ []Org, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := `
+        SELECT orgs.id, orgs.slug, orgs.name, orgs.created_at
+        FROM orgs JOIN org_members ON org_members.org_id = orgs.id
+        WHERE org_members.user_id = ?
+        ORDER BY orgs.slug
+    `
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		log.Println("Error retrieving organizations:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []Org{}
+	for rows.Next() {
+		var org Org
+		if err := rows.Scan(&org.ID, &org.Slug, &org.Name, &org.CreatedAt); err != nil {
+			log.Println("Error scanning organization:", err)
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+// GetMembers lists the members of an organization, owners first.
+func (r *OrgsRepo) GetMembers(ctx context.Context, orgID int) (_ []Member, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := `
+        SELECT org_members.user_id, users.username, org_members.role, org_members.created_at
+        FROM org_members JOIN users ON users.id = org_members.user_id
+        WHERE org_members.org_id = ?
+        ORDER BY CASE org_members.role WHEN 'owner' THEN 0 WHEN 'maintainer' THEN 1 ELSE 2 END, users.username
+    `
+	rows, err := r.db.QueryContext(ctx, query, orgID)
+	if err != nil {
+		log.Println("Error retrieving members:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []Member{}
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Role, &member.CreatedAt); err != nil {
+			log.Println("Error scanning member:", err)
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// GetRole returns the user's role in an organization, or ErrMemberNotFound
+// if they are not a member.
+func (r *OrgsRepo) GetRole(ctx context.Context, orgID, userID int) (_ Role, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return getRole(ctx, r.db, orgID, userID)
+}
+
+func getRole(ctx context.Context, db DBTX, orgID, userID int) (Role, error) {
+	var role Role
+	query := "SELECT role FROM org_members WHERE org_id = ? AND user_id = ?"
+	err := db.QueryRowContext(ctx, query, orgID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrMemberNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving role:", err)
+		return "", err
+	}
+	return role, nil
+}
+
+// SetMember adds the user to an organization or changes their role.
+// Demoting the last owner fails with ErrLastOwner.
+func (r *OrgsRepo) SetMember(ctx context.Context, orgID, userID int, role Role) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		current, err := getRole(ctx, tx, orgID, userID)
+		if errors.Is(err, ErrMemberNotFound) {
+			query := "INSERT INTO org_members (org_id, user_id, role) VALUES (?, ?, ?)"
+			if _, err := tx.ExecContext(ctx, query, orgID, userID, role); err != nil {
+				log.Println("Error adding member:", err)
+				return err
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if current == RoleOwner && role != RoleOwner {
+			if err := keepOwner(ctx, tx, orgID); err != nil {
+				return err
+			}
+		}
+		query := "UPDATE org_members SET role = ? WHERE org_id = ? AND user_id = ?"
+		if _, err := tx.ExecContext(ctx, query, role, orgID, userID); err != nil {
+			log.Println("Error updating member:", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// RemoveMember removes the user from an organization. The snippets they
+// added stay in the library. Removing the last owner fails with
+// ErrLastOwner.
+func (r *OrgsRepo) RemoveMember(ctx context.Context, orgID, userID int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		current, err := getRole(ctx, tx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if current == RoleOwner {
+			if err := keepOwner(ctx, tx, orgID); err != nil {
+				return err
+			}
+		}
+		query := "DELETE FROM org_members WHERE org_id = ? AND user_id = ?"
+		if _, err := tx.ExecContext(ctx, query, orgID, userID); err != nil {
+			log.Println("Error removing member:", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// keepOwner fails with ErrLastOwner unless the organization has another
+// owner besides the one about to be demoted or removed.
+func keepOwner(ctx context.Context, tx DBTX, orgID int) error {
+	var owners int
+	query := "SELECT COUNT(*) FROM org_members WHERE org_id = ? AND role = ?"
+	if err := tx.QueryRowContext(ctx, query, orgID, RoleOwner).Scan(&owners); err != nil {
+		log.Println("Error counting owners:", err)
+		return err
+	}
+	if owners < 2 {
+		return ErrLastOwner
+	}
+	return nil
+}
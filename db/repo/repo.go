@@ -0,0 +1,76 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DBTX is the part of *sql.DB and *sql.Tx the repos use, so that a repo can
+// run either on the connection pool or inside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// ErrCanceled is returned when the caller gave up, e.g. the client closed
+// the connection, before a query finished.
+var ErrCanceled = errors.New("query canceled")
+
+// ErrTimeout is returned when a query did not finish before its deadline.
+var ErrTimeout = errors.New("query timed out")
+
+// scope bounds ctx by the repo's default query deadline, unless the caller
+// already set an earlier one. The returned func must be deferred: it releases
+// the deadline and turns a failure caused by the context into ErrCanceled or
+// ErrTimeout.
+func scope(ctx context.Context, timeout time.Duration, err *error) (context.Context, func()) {
+	cancel := context.CancelFunc(func() {})
+	if deadline, ok := ctx.Deadline(); timeout > 0 && (!ok || time.Until(deadline) > timeout) {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {
+		*err = contextError(ctx, *err)
+		cancel()
+	}
+}
+
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ErrCanceled) || errors.Is(err, ErrTimeout) {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	return fmt.Errorf("%w: %v", ErrCanceled, err)
+}
+
+// inTx runs fn in a transaction. A repo that is already bound to a
+// transaction runs fn in it directly and leaves committing to its owner.
+func inTx(ctx context.Context, db DBTX, fn func(tx DBTX) error) error {
+	conn, ok := db.(*Conn)
+	if !ok {
+		return fn(db)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing transaction:", err)
+		return err
+	}
+	return nil
+}
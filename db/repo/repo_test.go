@@ -0,0 +1,49 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestConn opens a fresh SQLite database with the application schema.
+func newTestConn(t *testing.T) *Conn {
+	t.Helper()
+	schema, err := os.ReadFile("../sql/init.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	return NewConn(db)
+}
+
+// newTestUser creates a user named after username.
+func newTestUser(t *testing.T, conn *Conn, username string) int {
+	t.Helper()
+	user, err := NewUsersRepo(conn, 0).CreateUser(context.Background(), username, username+"@example.com", username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// newTestSnippet saves snippet for the user.
+func newTestSnippet(t *testing.T, conn *Conn, userID int, snippet Snippet) Snippet {
+	t.Helper()
+	saved, err := NewSnippetsRepo(conn, 0).SaveSnippet(context.Background(), userID, snippet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saved
+}
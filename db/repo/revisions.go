@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"snippetier/apperr"
+)
+
+// Revision is a historical version of a snippet's content.
+type Revision struct {
+	SnippetID int    `json:"snippetId"`
+	Revision  int    `json:"revision"`
+	AuthorID  int    `json:"authorId"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"createdAt"`
+	// MergedFrom is set on the history of a duplicate merged into the
+	// snippet, to the duplicate's old ID.
+	MergedFrom int `json:"mergedFrom,omitempty"`
+}
+
+func addRevision(ctx context.Context, db DBTX, snippetID, revision, authorID int, content string) error {
+	query := `
+        INSERT INTO snippet_revisions (snippet_id, revision, author_id, content)
+        VALUES (?, ?, ?, ?)
+    `
+	_, err := db.ExecContext(ctx, query, snippetID, revision, authorID, content)
+	if err != nil {
+		log.Println("Error saving revision:", err)
+	}
+	return err
+}
+
+// GetRevisions lists the content history of a snippet, newest first.
+func (r *SnippetsRepo) GetRevisions(ctx context.Context, snippetID int) (_ []Revision, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := `
+        SELECT snippet_id, revision, author_id, content, created_at
+        FROM snippet_revisions
+        WHERE snippet_id = ? AND merged_from = 0
+        ORDER BY revision DESC
+    `
+	rows, err := r.db.QueryContext(ctx, query, snippetID)
+	if err != nil {
+		log.Println("Error retrieving revisions:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var rev Revision
+		if err := rows.Scan(&rev.SnippetID, &rev.Revision, &rev.AuthorID, &rev.Content, &rev.CreatedAt); err != nil {
+			log.Println("Error scanning revision:", err)
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// GetMergedRevisions lists the histories of the duplicates merged into a
+// snippet, by old ID and then newest first.
+func (r *SnippetsRepo) GetMergedRevisions(ctx context.Context, snippetID int) (_ []Revision, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := `
+        SELECT snippet_id, merged_from, revision, author_id, content, created_at
+        FROM snippet_revisions
+        WHERE snippet_id = ? AND merged_from != 0
+        ORDER BY merged_from, revision DESC
+    `
+	rows, err := r.db.QueryContext(ctx, query, snippetID)
+	if err != nil {
+		log.Println("Error retrieving merged revisions:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var rev Revision
+		if err := rows.Scan(&rev.SnippetID, &rev.MergedFrom, &rev.Revision, &rev.AuthorID, &rev.Content, &rev.CreatedAt); err != nil {
+			log.Println("Error scanning revision:", err)
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// ErrRevisionNotFound is returned when a snippet has no such revision.
+var ErrRevisionNotFound = apperr.New(apperr.ErrNotFound, "revision not found")
+
+// GetRevision retrieves a single revision of a snippet.
+func (r *SnippetsRepo) GetRevision(ctx context.Context, snippetID, revision int) (_ Revision, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return getRevision(ctx, r.db, snippetID, revision)
+}
+
+func getRevision(ctx context.Context, db DBTX, snippetID, revision int) (Revision, error) {
+	query := `
+        SELECT snippet_id, revision, author_id, content, created_at
+        FROM snippet_revisions
+        WHERE snippet_id = ? AND merged_from = 0 AND revision = ?
+    `
+	var rev Revision
+	err := db.QueryRowContext(ctx, query, snippetID, revision).Scan(&rev.SnippetID, &rev.Revision, &rev.AuthorID, &rev.Content, &rev.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Revision{}, ErrRevisionNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving revision:", err)
+		return Revision{}, err
+	}
+	return rev, nil
+}
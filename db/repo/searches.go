@@ -0,0 +1,133 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"snippetier/apperr"
+	"time"
+)
+
+// SavedSearch is a snippet search a user kept. Its fields mirror the
+// search parameters of SnippetFilter.
+type SavedSearch struct {
+	ID        int    `json:"id"`
+	UserID    int    `json:"userId"`
+	Name      string `json:"name"`
+	Query     string `json:"query"`
+	Language  string `json:"language"`
+	Tag       string `json:"tag"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// Filter returns the snippet filter the search runs.
+func (s SavedSearch) Filter() SnippetFilter {
+	return SnippetFilter{Query: s.Query, Language: s.Language, Tag: s.Tag}
+}
+
+// ErrSearchNotFound is returned when a saved search does not exist or
+// belongs to someone else.
+var ErrSearchNotFound = apperr.New(apperr.ErrNotFound, "saved search not found")
+
+const searchColumns = "id, user_id, name, query, language, tag, created_at"
+
+func scanSearch(row rowScanner) (SavedSearch, error) {
+	var s SavedSearch
+	err := row.Scan(&s.ID, &s.UserID, &s.Name, &s.Query, &s.Language, &s.Tag, &s.CreatedAt)
+	return s, err
+}
+
+type SearchesRepo struct {
+	db      DBTX
+	timeout time.Duration
+}
+
+// NewSearchesRepo returns a repo whose queries are bounded by timeout unless
+// the caller's context sets an earlier deadline. Zero means no default.
+func NewSearchesRepo(db DBTX, timeout time.Duration) *SearchesRepo {
+	return &SearchesRepo{db, timeout}
+}
+
+// CreateSearch saves a search for its user and returns it.
+func (r *SearchesRepo) CreateSearch(ctx context.Context, search SavedSearch) (_ SavedSearch, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "INSERT INTO saved_searches (user_id, name, query, language, tag) VALUES (?, ?, ?, ?, ?)"
+	res, err := r.db.ExecContext(ctx, query, search.UserID, search.Name, search.Query, normalizeLanguage(search.Language), search.Tag)
+	if err != nil {
+		log.Println("Error saving search:", err)
+		return SavedSearch{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	return r.getSearch(ctx, int(id))
+}
+
+// GetSearch retrieves a saved search by ID.
+func (r *SearchesRepo) GetSearch(ctx context.Context, id int) (_ SavedSearch, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return r.getSearch(ctx, id)
+}
+
+func (r *SearchesRepo) getSearch(ctx context.Context, id int) (SavedSearch, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+searchColumns+" FROM saved_searches WHERE id = ?", id)
+	search, err := scanSearch(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SavedSearch{}, ErrSearchNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving saved search:", err)
+		return SavedSearch{}, err
+	}
+	return search, nil
+}
+
+// GetSearchesByUser lists a user's saved searches, oldest first.
+func (r *SearchesRepo) GetSearchesByUser(ctx context.Context, userID int) (_ []SavedSearch, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+searchColumns+" FROM saved_searches WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		log.Println("Error retrieving saved searches:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []SavedSearch{}
+	for rows.Next() {
+		search, err := scanSearch(rows)
+		if err != nil {
+			log.Println("Error scanning saved search:", err)
+			return nil, err
+		}
+		searches = append(searches, search)
+	}
+	return searches, rows.Err()
+}
+
+// DeleteSearch deletes one of the user's saved searches.
+func (r *SearchesRepo) DeleteSearch(ctx context.Context, userID, id int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	res, err := r.db.ExecContext(ctx, "DELETE FROM saved_searches WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		log.Println("Error deleting saved search:", err)
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSearchNotFound
+	}
+	return nil
+}
@@ -1,36 +1,136 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"snippetier/apperr"
+	"strings"
+	"time"
 )
 
 type Snippet struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Content     string `json:"content"`
-	UserId      int    `json:"userId"`
-	CreatedAt   string `json:"createdAt"`
-	UpdatedAt   string `json:"updatedAt"`
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Content     string   `json:"content"`
+	Language    string   `json:"language"`
+	Tags        []string `json:"tags"`
+	Revision    int      `json:"revision"`
+	UserId      int      `json:"userId"`
+	// OrgID is set for snippets in an organization's library. Their owner
+	// is the member who added them.
+	OrgID         *int    `json:"orgId,omitempty"`
+	Stars         int     `json:"stars"`
+	ExpiresAt     *string `json:"expiresAt,omitempty"`
+	BurnAfterRead bool    `json:"burnAfterRead"`
+	// ForkedFrom is the snippet this one was forked from, which may since
+	// have been deleted, and ForkedRevision the upstream revision the fork
+	// was last synced with.
+	ForkedFrom     *int   `json:"forkedFrom,omitempty"`
+	ForkedRevision *int   `json:"forkedRevision,omitempty"`
+	Forks          int    `json:"forks"`
+	CreatedAt      string `json:"createdAt"`
+	UpdatedAt      string `json:"updatedAt"`
+}
+
+// MaxContentBytes caps the content of a snippet, however it is created:
+// content above it could never be updated through the API again.
+const MaxContentBytes = 256 << 10
+
+// ErrSnippetNotFound is returned when a snippet does not exist, has expired
+// or has already been burned by an earlier read.
+var ErrSnippetNotFound = apperr.New(apperr.ErrNotFound, "snippet not found")
+
+// snippetColumns ends with the star and fork counts, computed by indexed
+// subqueries so that lists get their counts in the same query and can sort
+// by them.
+const snippetColumns = "id, name, description, content, language, revision, user_id, org_id, expires_at, burn_after_read, forked_from, forked_revision, created_at, updated_at, " + starCount + ", " + forkCount
+
+const (
+	starCount = "(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id) AS stars"
+	forkCount = "(SELECT COUNT(*) FROM snippets AS forks WHERE forks.forked_from = snippets.id) AS forks"
+)
+
+// notExpired filters out snippets whose expiry has passed. Every query that
+// reads snippets must include it so expired rows stay invisible until the
+// janitor purges them.
+const notExpired = "(expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSnippet(row rowScanner) (Snippet, error) {
+	var snippet Snippet
+	err := row.Scan(&snippet.ID, &snippet.Name, &snippet.Description, &snippet.Content, &snippet.Language, &snippet.Revision, &snippet.UserId, &snippet.OrgID, &snippet.ExpiresAt, &snippet.BurnAfterRead, &snippet.ForkedFrom, &snippet.ForkedRevision, &snippet.CreatedAt, &snippet.UpdatedAt, &snippet.Stars, &snippet.Forks)
+	return snippet, err
 }
 
 type SnippetsRepo struct {
-	db *sql.DB
+	db      DBTX
+	timeout time.Duration
 }
 
-func NewSnippetsRepo(db *sql.DB) *SnippetsRepo {
-	return &SnippetsRepo{db}
+// NewSnippetsRepo returns a repo whose queries are bounded by timeout unless
+// the caller's context sets an earlier deadline. Zero means no default.
+func NewSnippetsRepo(db DBTX, timeout time.Duration) *SnippetsRepo {
+	return &SnippetsRepo{db, timeout}
 }
 
-func (r *SnippetsRepo) GetAllSnippets() ([]Snippet, error) {
-	// Define the SQL query to select all rows from the "snippets" table
-	query := "SELECT * FROM snippets"
+// SnippetSort selects the order of snippet lists.
+type SnippetSort string
+
+const (
+	// SortUpdated lists recently updated snippets first. It is the default.
+	SortUpdated SnippetSort = ""
+	// SortStars lists the most starred snippets first.
+	SortStars SnippetSort = "stars"
+)
+
+// ParseSnippetSort validates a sort order given by a client.
+func ParseSnippetSort(s string) (SnippetSort, error) {
+	switch SnippetSort(s) {
+	case SortUpdated, "updated":
+		return SortUpdated, nil
+	case SortStars:
+		return SortStars, nil
+	}
+	return "", fmt.Errorf("unknown sort order %q", s)
+}
+
+func (s SnippetSort) orderBy() string {
+	if s == SortStars {
+		return " ORDER BY stars DESC, updated_at DESC, id DESC"
+	}
+	return " ORDER BY updated_at DESC, id DESC"
+}
+
+// GetAllSnippets lists every visible snippet the user may see in the given
+// order: public ones, their own and their organizations'. Burn-after-read
+// snippets are left out so that listing them does not leak their content.
+func (r *SnippetsRepo) GetAllSnippets(ctx context.Context, userId int, sort SnippetSort) ([]Snippet, error) {
+	return r.listSnippets(ctx, " AND "+visibleTo+sort.orderBy(), userId, userId)
+}
+
+// GetIndexedSnippets lists every visible snippet whoever may see it, for
+// indexes that check the audience of their results themselves.
+func (r *SnippetsRepo) GetIndexedSnippets(ctx context.Context) ([]Snippet, error) {
+	return r.listSnippets(ctx, SortUpdated.orderBy())
+}
+
+func (r *SnippetsRepo) listSnippets(ctx context.Context, where string, args ...any) (_ []Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT " + snippetColumns + " FROM snippets WHERE burn_after_read = 0 AND " + notExpired + where
 
 	// Execute the query
-	rows, err := r.db.Query(query)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Fatal(err)
+		log.Println("Error retrieving snippets:", err)
 		return nil, err
 	}
 	defer func(rows *sql.Rows) {
@@ -42,9 +142,9 @@ func (r *SnippetsRepo) GetAllSnippets() ([]Snippet, error) {
 
 	// Iterate over the result set and scan each row into a Snippet struct
 	for rows.Next() {
-		var snippet Snippet
-		if err := rows.Scan(&snippet.ID, &snippet.Name, &snippet.Description, &snippet.Content, &snippet.UserId, &snippet.CreatedAt, &snippet.UpdatedAt); err != nil {
-			log.Fatal(err)
+		snippet, err := scanSnippet(rows)
+		if err != nil {
+			log.Println("Error scanning snippet:", err)
 			return nil, err
 		}
 		snippets = append(snippets, snippet)
@@ -52,81 +152,445 @@ func (r *SnippetsRepo) GetAllSnippets() ([]Snippet, error) {
 
 	// Check for errors from iterating over rows
 	if err := rows.Err(); err != nil {
-		log.Fatal(err)
+		log.Println("Error iterating snippets:", err)
+		return nil, err
+	}
+
+	if err := loadTags(ctx, r.db, snippets); err != nil {
 		return nil, err
 	}
 
 	return snippets, nil
 }
 
-// SaveSnippet saves a single snippet to the "snippets" table using prepared statements.
-func (r *SnippetsRepo) SaveSnippet(userId int, name, description, content string) (Snippet, error) {
-	query := `
-        INSERT INTO snippets (name, description, content, user_id)
-        VALUES (?, ?, ?, ?)
-    `
-	stmt, err := r.db.Prepare(query)
+// SnippetFilter narrows down a snippet search. Zero values match everything.
+type SnippetFilter struct {
+	Query    string
+	Language string
+	Tag      string
+	UserID   int
+	OrgID    int
+	Sort     SnippetSort
+	// PublicOnly leaves out snippets in organization libraries, which are
+	// shared with the organization's members only.
+	PublicOnly bool
+	// Limit caps the number of snippets listed. Zero means no limit.
+	Limit int
+}
+
+// SearchSnippets lists visible snippets matching filter among those the
+// user may see. Query is matched as a substring of the name, description
+// or content. Like GetAllSnippets, burn-after-read snippets are never
+// listed.
+func (r *SnippetsRepo) SearchSnippets(ctx context.Context, userId int, filter SnippetFilter) (_ []Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT " + snippetColumns + " FROM snippets WHERE burn_after_read = 0 AND " + notExpired + " AND " + visibleTo
+	args := []any{userId, userId}
+
+	if filter.Query != "" {
+		pattern := "%" + escapeLike(filter.Query) + "%"
+		query += " AND (name LIKE ? ESCAPE '!' OR description LIKE ? ESCAPE '!' OR content LIKE ? ESCAPE '!')"
+		args = append(args, pattern, pattern, pattern)
+	}
+	if filter.Language != "" {
+		query += " AND language = ?"
+		args = append(args, normalizeLanguage(filter.Language))
+	}
+	if filter.Tag != "" {
+		query += " AND id IN (SELECT snippet_id FROM snippet_tags WHERE tag = ?)"
+		args = append(args, strings.ToLower(filter.Tag))
+	}
+	if filter.UserID != 0 {
+		query += " AND user_id = ?"
+		args = append(args, filter.UserID)
+	}
+	if filter.OrgID != 0 {
+		query += " AND org_id = ?"
+		args = append(args, filter.OrgID)
+	}
+	if filter.PublicOnly {
+		query += " AND org_id IS NULL"
+	}
+	query += filter.Sort.orderBy()
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Error searching snippets:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+	for rows.Next() {
+		snippet, err := scanSnippet(rows)
+		if err != nil {
+			log.Println("Error scanning snippet:", err)
+			return nil, err
+		}
+		snippets = append(snippets, snippet)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadTags(ctx, r.db, snippets); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+func normalizeLanguage(language string) string {
+	return strings.ToLower(strings.TrimSpace(language))
+}
+
+// GetSnippetsByUser lists every visible snippet a user owns, including
+// burn-after-read ones, without consuming them. It is meant for the owner's
+// own data export.
+func (r *SnippetsRepo) GetSnippetsByUser(ctx context.Context, userId int) (_ []Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT " + snippetColumns + " FROM snippets WHERE user_id = ? AND " + notExpired + " ORDER BY id"
+	rows, err := r.db.QueryContext(ctx, query, userId)
 	if err != nil {
-		log.Println("Error preparing statement:", err)
+		log.Println("Error retrieving snippets:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+	for rows.Next() {
+		snippet, err := scanSnippet(rows)
+		if err != nil {
+			log.Println("Error scanning snippet:", err)
+			return nil, err
+		}
+		snippets = append(snippets, snippet)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadTags(ctx, r.db, snippets); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// escapeLike escapes LIKE wildcards in s using '!' as the escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// GetSnippetByID retrieves a visible snippet by ID without consuming it,
+// even if it is marked burn-after-read.
+func (r *SnippetsRepo) GetSnippetByID(ctx context.Context, id int) (_ Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT " + snippetColumns + " FROM snippets WHERE id = ? AND " + notExpired
+	snippet, err := scanSnippet(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Snippet{}, ErrSnippetNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving snippet:", err)
+		return Snippet{}, err
+	}
+
+	snippets := []Snippet{snippet}
+	if err := loadTags(ctx, r.db, snippets); err != nil {
 		return Snippet{}, err
 	}
-	defer stmt.Close()
+	return snippets[0], nil
+}
+
+// ReadSnippet retrieves a snippet for display. If the snippet is marked
+// burn-after-read it is deleted in the same transaction, and only the reader
+// whose DELETE actually removed the row gets the content back.
+func (r *SnippetsRepo) ReadSnippet(ctx context.Context, id int) (_ Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	var snippet Snippet
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		query := "SELECT " + snippetColumns + " FROM snippets WHERE id = ? AND " + notExpired
+		found, err := scanSnippet(tx.QueryRowContext(ctx, query, id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSnippetNotFound
+		}
+		if err != nil {
+			log.Println("Error retrieving snippet:", err)
+			return err
+		}
+
+		snippets := []Snippet{found}
+		if err := loadTags(ctx, tx, snippets); err != nil {
+			return err
+		}
+		snippet = snippets[0]
 
-	_, err = stmt.Exec(name, description, content, userId)
+		if !snippet.BurnAfterRead {
+			return nil
+		}
+		deleted, err := deleteSnippets(ctx, tx, "id = ? AND burn_after_read = 1", id)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			// Somebody else read it first.
+			return ErrSnippetNotFound
+		}
+		return nil
+	})
 	if err != nil {
-		log.Println("Error saving snippet:", err)
 		return Snippet{}, err
 	}
+	return snippet, nil
+}
+
+// SaveSnippet saves a single snippet to the "snippets" table and records
+// its content as the first revision. Setting OrgID adds it to that
+// organization's library; the caller checks that the user is a member.
+// The snippet, its revision and its tags are written in one transaction.
+func (r *SnippetsRepo) SaveSnippet(ctx context.Context, userId int, snippet Snippet) (_ Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	snippet.Language = normalizeLanguage(snippet.Language)
+	snippet.Tags = NormalizeTags(snippet.Tags)
+	snippet.UserId = userId
+	snippet.Revision = 1
 
-	// Retrieve the newly created snippet'r ID from the database
-	var id int
-	err = r.db.QueryRow("SELECT last_insert_rowid()").Scan(&id)
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		query := `
+            INSERT INTO snippets (name, description, content, language, user_id, org_id, expires_at, burn_after_read)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        `
+		res, err := tx.ExecContext(ctx, query, snippet.Name, snippet.Description, snippet.Content, snippet.Language, userId, snippet.OrgID, snippet.ExpiresAt, snippet.BurnAfterRead)
+		if err != nil {
+			log.Println("Error saving snippet:", err)
+			return err
+		}
+
+		// Retrieve the newly created snippet's ID from the insert result
+		id, err := res.LastInsertId()
+		if err != nil {
+			log.Println("Error getting last insert ID:", err)
+			return err
+		}
+		snippet.ID = int(id)
+
+		if err := addRevision(ctx, tx, snippet.ID, snippet.Revision, userId, snippet.Content); err != nil {
+			return err
+		}
+		if err := setTags(ctx, tx, snippet.ID, snippet.Tags); err != nil {
+			return err
+		}
+		if err := setSignature(ctx, tx, snippet.ID, snippet.Content); err != nil {
+			return err
+		}
+		return recordActivity(ctx, tx, userId, ActivitySnippetCreated, snippet.ID, nil)
+	})
 	if err != nil {
-		log.Println("Error getting last insert ID:", err)
 		return Snippet{}, err
 	}
 
 	// Return the newly created snippet with the generated ID
-	return Snippet{ID: id, Name: name, Description: description, Content: content}, nil
+	return snippet, nil
 }
 
-// UpdateSnippet updates an existing snippet in the "snippets" table by ID using prepared statements.
-func (r *SnippetsRepo) UpdateSnippet(userId, id int, name, description, content string) (Snippet, error) {
-	query := `
-        UPDATE snippets
-        SET name = ?, description = ?, content = ?
-        WHERE id = ? AND user_id = ?
-    `
-	stmt, err := r.db.Prepare(query)
+// UpdateSnippet updates an existing snippet in the "snippets" table by ID.
+// A change of content bumps the snippet's revision and records the new
+// content in its history, authored by userId. The user must own the snippet
+// or be an owner or maintainer of its organization.
+func (r *SnippetsRepo) UpdateSnippet(ctx context.Context, userId, id int, snippet Snippet) (_ Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	snippet.Language = normalizeLanguage(snippet.Language)
+	snippet.Tags = NormalizeTags(snippet.Tags)
+
+	var revision, ownerId int
+	var orgId *int
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		var currentContent string
+		query := "SELECT content, revision, user_id, org_id FROM snippets WHERE id = ? AND " + editableBy + " AND " + notExpired
+		err := tx.QueryRowContext(ctx, query, id, userId, userId).Scan(&currentContent, &revision, &ownerId, &orgId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSnippetNotFound
+		}
+		if err != nil {
+			log.Println("Error retrieving snippet:", err)
+			return err
+		}
+
+		contentChanged := currentContent != snippet.Content
+		if contentChanged {
+			revision++
+		}
+
+		query = `
+            UPDATE snippets
+            SET name = ?, description = ?, content = ?, language = ?, revision = ?, expires_at = ?, burn_after_read = ?
+            WHERE id = ?
+        `
+		_, err = tx.ExecContext(ctx, query, snippet.Name, snippet.Description, snippet.Content, snippet.Language, revision, snippet.ExpiresAt, snippet.BurnAfterRead, id)
+		if err != nil {
+			log.Println("Error updating snippet:", err)
+			return err
+		}
+
+		if contentChanged {
+			if err := addRevision(ctx, tx, id, revision, userId, snippet.Content); err != nil {
+				return err
+			}
+			if err := remapAnchors(ctx, tx, id, currentContent, snippet.Content, revision); err != nil {
+				return err
+			}
+			if err := setSignature(ctx, tx, id, snippet.Content); err != nil {
+				return err
+			}
+		}
+		if err := setTags(ctx, tx, id, snippet.Tags); err != nil {
+			return err
+		}
+		return recordActivity(ctx, tx, userId, ActivitySnippetUpdated, id, nil)
+	})
 	if err != nil {
-		log.Println("Error preparing statement:", err)
 		return Snippet{}, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(name, description, content, id, userId)
+	// Return the updated snippet
+	snippet.ID = id
+	snippet.UserId = ownerId
+	snippet.OrgID = orgId
+	snippet.Revision = revision
+	return snippet, nil
+}
+
+// ImportSnippet inserts a snippet restored from a backup, keeping its
+// timestamps and full revision history. The snippet's current revision is
+// the highest one restored. It returns the snippet with its new ID.
+func (r *SnippetsRepo) ImportSnippet(ctx context.Context, userId int, snippet Snippet, revisions []Revision) (_ Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	snippet.Language = normalizeLanguage(snippet.Language)
+	snippet.Tags = NormalizeTags(snippet.Tags)
+	snippet.UserId = userId
+	if snippet.Revision < 1 {
+		snippet.Revision = 1
+	}
+	for _, rev := range revisions {
+		if rev.Revision > snippet.Revision {
+			snippet.Revision = rev.Revision
+		}
+	}
+
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		query := `
+            INSERT INTO snippets (name, description, content, language, revision, user_id, expires_at, burn_after_read, created_at, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, COALESCE(NULLIF(?, ''), CURRENT_TIMESTAMP), COALESCE(NULLIF(?, ''), CURRENT_TIMESTAMP))
+        `
+		res, err := tx.ExecContext(ctx, query, snippet.Name, snippet.Description, snippet.Content, snippet.Language, snippet.Revision, userId, snippet.ExpiresAt, snippet.BurnAfterRead, snippet.CreatedAt, snippet.UpdatedAt)
+		if err != nil {
+			log.Println("Error importing snippet:", err)
+			return err
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			log.Println("Error getting last insert ID:", err)
+			return err
+		}
+		snippet.ID = int(id)
+
+		query = `
+            INSERT INTO snippet_revisions (snippet_id, revision, author_id, content, created_at)
+            VALUES (?, ?, ?, ?, COALESCE(NULLIF(?, ''), CURRENT_TIMESTAMP))
+        `
+		for _, rev := range revisions {
+			if _, err := tx.ExecContext(ctx, query, snippet.ID, rev.Revision, userId, rev.Content, rev.CreatedAt); err != nil {
+				log.Println("Error importing revision:", err)
+				return err
+			}
+		}
+		if err := setTags(ctx, tx, snippet.ID, snippet.Tags); err != nil {
+			return err
+		}
+		return setSignature(ctx, tx, snippet.ID, snippet.Content)
+	})
 	if err != nil {
-		log.Println("Error updating snippet:", err)
 		return Snippet{}, err
 	}
+	return snippet, nil
+}
 
-	// Return the updated snippet
-	return Snippet{ID: id, Name: name, Description: description, Content: content}, nil
+// DeleteSnippet deletes a single snippet, with its tags and history, by ID
+// on behalf of actorID.
+func (r *SnippetsRepo) DeleteSnippet(ctx context.Context, actorID, snippetID int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		err := announce(ctx, tx, EventSnippetDeleted, actorID, snippetID, nil)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSnippetNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = deleteSnippets(ctx, tx, "id = ?", snippetID)
+		return err
+	})
 }
 
-// DeleteSnippet deletes a single snippet from the "snippets" table by ID using prepared statements.
-func (r *SnippetsRepo) DeleteSnippet(snippetID int) error {
-	query := "DELETE FROM snippets WHERE id = ?"
-	stmt, err := r.db.Prepare(query)
-	if err != nil {
-		log.Println("Error preparing statement:", err)
+// PurgeExpired physically deletes every snippet whose expiry has passed and
+// returns how many snippets were removed.
+func (r *SnippetsRepo) PurgeExpired(ctx context.Context) (_ int64, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	var purged int64
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		var err error
+		purged, err = deleteSnippets(ctx, tx, "expires_at IS NOT NULL AND expires_at <= CURRENT_TIMESTAMP")
 		return err
+	})
+	return purged, err
+}
+
+// snippetChildTables hold rows that belong to a snippet and are removed with it.
+var snippetChildTables = []string{"snippet_tags", "snippet_revisions", "stars", "comments", "collection_items", "activity", "snippet_signatures", "snippet_bands"}
+
+// deleteSnippets removes the snippets matching the where clause together with
+// their child rows and returns how many snippets were removed. It should run
+// inside a transaction so that no orphans are left behind on failure.
+func deleteSnippets(ctx context.Context, tx DBTX, where string, args ...any) (int64, error) {
+	for _, table := range snippetChildTables {
+		query := "DELETE FROM " + table + " WHERE snippet_id IN (SELECT id FROM snippets WHERE " + where + ")"
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			log.Println("Error deleting from "+table+":", err)
+			return 0, err
+		}
+	}
+	query := "DELETE FROM snippet_redirects WHERE new_id IN (SELECT id FROM snippets WHERE " + where + ")"
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		log.Println("Error deleting redirects:", err)
+		return 0, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(snippetID)
+	res, err := tx.ExecContext(ctx, "DELETE FROM snippets WHERE "+where, args...)
 	if err != nil {
-		log.Println("Error deleting snippet:", err)
+		log.Println("Error deleting snippets:", err)
+		return 0, err
 	}
-	return err
+	return res.RowsAffected()
 }
@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"context"
+	"log"
+)
+
+// StarSnippet bookmarks a snippet the user may see for them. Starring a
+// snippet twice is a no-op.
+func (r *SnippetsRepo) StarSnippet(ctx context.Context, userId, snippetID int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		query := `
+            INSERT INTO stars (user_id, snippet_id)
+            SELECT ?, id FROM snippets
+            WHERE id = ? AND ` + notExpired + ` AND ` + visibleTo + `
+              AND NOT EXISTS (SELECT 1 FROM stars WHERE user_id = ? AND snippet_id = ?)
+        `
+		res, err := tx.ExecContext(ctx, query, userId, snippetID, userId, userId, userId, snippetID)
+		if err != nil {
+			log.Println("Error starring snippet:", err)
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return recordActivity(ctx, tx, userId, ActivitySnippetStarred, snippetID, nil)
+		}
+
+		// Nothing was inserted: either the star already exists or the
+		// snippet is not visible.
+		var exists bool
+		query = "SELECT EXISTS (SELECT 1 FROM snippets WHERE id = ? AND " + notExpired + " AND " + visibleTo + ")"
+		if err := tx.QueryRowContext(ctx, query, snippetID, userId, userId).Scan(&exists); err != nil {
+			log.Println("Error retrieving snippet:", err)
+			return err
+		}
+		if !exists {
+			return ErrSnippetNotFound
+		}
+		return nil
+	})
+}
+
+// UnstarSnippet removes the user's star from a snippet, if there is one,
+// and takes the star out of their followers' feeds.
+func (r *SnippetsRepo) UnstarSnippet(ctx context.Context, userId, snippetID int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM stars WHERE user_id = ? AND snippet_id = ?", userId, snippetID); err != nil {
+			log.Println("Error unstarring snippet:", err)
+			return err
+		}
+		query := "DELETE FROM activity WHERE actor_id = ? AND verb = ? AND snippet_id = ?"
+		if _, err := tx.ExecContext(ctx, query, userId, ActivitySnippetStarred, snippetID); err != nil {
+			log.Println("Error deleting activity:", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// GetStarredSnippets lists the visible snippets the user starred, most
+// recently starred first. Burn-after-read snippets are left out like in
+// every other list.
+func (r *SnippetsRepo) GetStarredSnippets(ctx context.Context, userId int) (_ []Snippet, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := `
+        SELECT ` + snippetColumns + `
+        FROM snippets
+        WHERE id IN (SELECT snippet_id FROM stars WHERE user_id = ?)
+          AND burn_after_read = 0 AND ` + notExpired + ` AND ` + visibleTo + `
+        ORDER BY (SELECT created_at FROM stars WHERE stars.snippet_id = snippets.id AND stars.user_id = ?) DESC, id DESC
+    `
+	rows, err := r.db.QueryContext(ctx, query, userId, userId, userId, userId)
+	if err != nil {
+		log.Println("Error retrieving starred snippets:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+	for rows.Next() {
+		snippet, err := scanSnippet(rows)
+		if err != nil {
+			log.Println("Error scanning snippet:", err)
+			return nil, err
+		}
+		snippets = append(snippets, snippet)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadTags(ctx, r.db, snippets); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// GetStarredIDs lists the IDs of every snippet the user starred.
+func (r *SnippetsRepo) GetStarredIDs(ctx context.Context, userId int) (_ []int, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT snippet_id FROM stars WHERE user_id = ? ORDER BY snippet_id", userId)
+	if err != nil {
+		log.Println("Error retrieving stars:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Println("Error scanning star:", err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
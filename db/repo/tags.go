@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+)
+
+// NormalizeTags lower-cases and trims tags, dropping empties and duplicates.
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// setTags replaces the tags of a snippet.
+func setTags(ctx context.Context, db DBTX, snippetID int, tags []string) error {
+	if _, err := db.ExecContext(ctx, "DELETE FROM snippet_tags WHERE snippet_id = ?", snippetID); err != nil {
+		log.Println("Error clearing tags:", err)
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := db.ExecContext(ctx, "INSERT INTO snippet_tags (snippet_id, tag) VALUES (?, ?)", snippetID, tag); err != nil {
+			log.Println("Error saving tag:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTags fills in the tags of the given snippets with a single query.
+func loadTags(ctx context.Context, db DBTX, snippets []Snippet) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*Snippet, len(snippets))
+	args := make([]any, 0, len(snippets))
+	for i := range snippets {
+		snippets[i].Tags = []string{}
+		byID[snippets[i].ID] = &snippets[i]
+		args = append(args, snippets[i].ID)
+	}
+
+	query := "SELECT snippet_id, tag FROM snippet_tags WHERE snippet_id IN (" + placeholders(len(args)) + ") ORDER BY tag"
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Error retrieving tags:", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var snippetID int
+		var tag string
+		if err := rows.Scan(&snippetID, &tag); err != nil {
+			log.Println("Error scanning tag:", err)
+			return err
+		}
+		if s, ok := byID[snippetID]; ok {
+			s.Tags = append(s.Tags, tag)
+		}
+	}
+	return rows.Err()
+}
+
+// placeholders returns n comma-separated bind parameters for an IN clause.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
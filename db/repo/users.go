@@ -1,8 +1,12 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"log"
+	"snippetier/apperr"
+	"time"
 )
 
 type User struct {
@@ -14,67 +18,76 @@ type User struct {
 	UpdatedAt string `json:"updatedAt"`
 }
 
+// ErrUserNotFound is returned when no user matches the lookup.
+var ErrUserNotFound = apperr.New(apperr.ErrNotFound, "user not found")
+
 type UsersRepo struct {
-	db *sql.DB
+	db      DBTX
+	timeout time.Duration
 }
 
-func NewUsersRepo(db *sql.DB) *UsersRepo {
-	return &UsersRepo{db}
+// NewUsersRepo returns a repo whose queries are bounded by timeout unless
+// the caller's context sets an earlier deadline. Zero means no default.
+func NewUsersRepo(db DBTX, timeout time.Duration) *UsersRepo {
+	return &UsersRepo{db, timeout}
 }
 
 // CreateUser creates a new user and returns the created user.
-func (r *UsersRepo) CreateUser(username, email, fullName string) (User, error) {
-	query := `
-        INSERT INTO users (username, email, full_name)
-        VALUES (?, ?, ?)
-    `
-	stmt, err := r.db.Prepare(query)
-	if err != nil {
-		log.Println("Error preparing statement:", err)
-		return User{}, err
-	}
-	defer stmt.Close()
+func (r *UsersRepo) CreateUser(ctx context.Context, username, email, fullName string) (_ User, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
 
-	_, err = stmt.Exec(username, email, fullName)
-	if err != nil {
-		log.Println("Error creating user:", err)
-		return User{}, err
-	}
+	user := User{Username: username, Email: email, FullName: fullName}
 
-	// Retrieve the newly created user'r ID from the database
-	var id int
-	err = r.db.QueryRow("SELECT last_insert_rowid()").Scan(&id)
-	if err != nil {
-		log.Println("Error getting last insert ID:", err)
-		return User{}, err
-	}
+	// The insert and the timestamp read share a transaction so that the
+	// read sees the row even on a different pool connection.
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		query := `
+            INSERT INTO users (username, email, full_name)
+            VALUES (?, ?, ?)
+        `
+		res, err := tx.ExecContext(ctx, query, username, email, fullName)
+		if err != nil {
+			log.Println("Error creating user:", err)
+			return err
+		}
+
+		// Retrieve the newly created user's ID from the insert result
+		id, err := res.LastInsertId()
+		if err != nil {
+			log.Println("Error getting last insert ID:", err)
+			return err
+		}
+		user.ID = int(id)
 
-	// Retrieve the user'r created_at and updated_at timestamps
-	var createdAt, updatedAt string
-	query = "SELECT created_at, updated_at FROM users WHERE id = ?"
-	err = r.db.QueryRow(query, id).Scan(&createdAt, &updatedAt)
+		// Retrieve the user's created_at and updated_at timestamps
+		query = "SELECT created_at, updated_at FROM users WHERE id = ?"
+		err = tx.QueryRowContext(ctx, query, user.ID).Scan(&user.CreatedAt, &user.UpdatedAt)
+		if err != nil {
+			log.Println("Error retrieving timestamps:", err)
+		}
+		return err
+	})
 	if err != nil {
-		log.Println("Error retrieving timestamps:", err)
 		return User{}, err
 	}
 
 	// Return the newly created user with the generated ID and timestamps
-	return User{
-		ID:        id,
-		Username:  username,
-		Email:     email,
-		FullName:  fullName,
-		CreatedAt: createdAt,
-		UpdatedAt: updatedAt,
-	}, nil
+	return user, nil
 }
 
 // GetUserByID retrieves a user by ID and returns it.
-func (r *UsersRepo) GetUserByID(id int) (User, error) {
+func (r *UsersRepo) GetUserByID(ctx context.Context, id int) (_ User, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
 	query := "SELECT id, username, email, full_name, created_at, updated_at FROM users WHERE id = ?"
-	row := r.db.QueryRow(query, id)
+	row := r.db.QueryRowContext(ctx, query, id)
 	var user User
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.FullName, &user.CreatedAt, &user.UpdatedAt)
+	err = row.Scan(&user.ID, &user.Username, &user.Email, &user.FullName, &user.CreatedAt, &user.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
 	if err != nil {
 		log.Println("Error retrieving user:", err)
 		return User{}, err
@@ -82,51 +95,119 @@ func (r *UsersRepo) GetUserByID(id int) (User, error) {
 	return user, nil
 }
 
-// UpdateUser updates an existing user and returns the updated user.
-func (r *UsersRepo) UpdateUser(id int, username, email, fullName string) (User, error) {
-	query := `
-        UPDATE users
-        SET username = ?, email = ?, full_name = ?
-        WHERE id = ?
-    `
-	stmt, err := r.db.Prepare(query)
+// GetUserByEmail retrieves a user by email address and returns it.
+func (r *UsersRepo) GetUserByEmail(ctx context.Context, email string) (_ User, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT id, username, email, full_name, created_at, updated_at FROM users WHERE email = ?"
+	row := r.db.QueryRowContext(ctx, query, email)
+	var user User
+	err = row.Scan(&user.ID, &user.Username, &user.Email, &user.FullName, &user.CreatedAt, &user.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
 	if err != nil {
-		log.Println("Error preparing statement:", err)
+		log.Println("Error retrieving user:", err)
 		return User{}, err
 	}
-	defer stmt.Close()
+	return user, nil
+}
 
-	_, err = stmt.Exec(username, email, fullName, id)
+// GetUserByUsername retrieves a user by username and returns it.
+func (r *UsersRepo) GetUserByUsername(ctx context.Context, username string) (_ User, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT id, username, email, full_name, created_at, updated_at FROM users WHERE username = ?"
+	row := r.db.QueryRowContext(ctx, query, username)
+	var user User
+	err = row.Scan(&user.ID, &user.Username, &user.Email, &user.FullName, &user.CreatedAt, &user.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
 	if err != nil {
-		log.Println("Error updating user:", err)
+		log.Println("Error retrieving user:", err)
 		return User{}, err
 	}
+	return user, nil
+}
 
-	// Retrieve the updated user'r timestamps
-	var updatedAt string
-	query = "SELECT updated_at FROM users WHERE id = ?"
-	err = r.db.QueryRow(query, id).Scan(&updatedAt)
+// UpdateUser updates an existing user and returns the updated user.
+func (r *UsersRepo) UpdateUser(ctx context.Context, id int, username, email, fullName string) (_ User, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	user := User{ID: id, Username: username, Email: email, FullName: fullName}
+
+	err = inTx(ctx, r.db, func(tx DBTX) error {
+		query := `
+            UPDATE users
+            SET username = ?, email = ?, full_name = ?
+            WHERE id = ?
+        `
+		_, err := tx.ExecContext(ctx, query, username, email, fullName, id)
+		if err != nil {
+			log.Println("Error updating user:", err)
+			return err
+		}
+
+		// Retrieve the updated user's timestamps
+		query = "SELECT created_at, updated_at FROM users WHERE id = ?"
+		err = tx.QueryRowContext(ctx, query, id).Scan(&user.CreatedAt, &user.UpdatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			log.Println("Error retrieving timestamps:", err)
+		}
+		return err
+	})
 	if err != nil {
-		log.Println("Error retrieving timestamps:", err)
 		return User{}, err
 	}
 
 	// Return the updated user
-	return User{
-		ID:        id,
-		Username:  username,
-		Email:     email,
-		FullName:  fullName,
-		UpdatedAt: updatedAt,
-	}, nil
+	return user, nil
 }
 
 // DeleteUser deletes a user by ID.
-func (r *UsersRepo) DeleteUser(id int) error {
+func (r *UsersRepo) DeleteUser(ctx context.Context, id int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
 	query := "DELETE FROM users WHERE id = ?"
-	_, err := r.db.Exec(query, id)
+	_, err = r.db.ExecContext(ctx, query, id)
 	if err != nil {
 		log.Println("Error deleting user:", err)
 	}
 	return err
 }
+
+// UsernameTaken reports whether a user other than exceptID already has the
+// username, compared case-insensitively.
+func (r *UsersRepo) UsernameTaken(ctx context.Context, username string, exceptID int) (_ bool, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return r.taken(ctx, "LOWER(username) = LOWER(?)", username, exceptID)
+}
+
+// EmailTaken reports whether a user other than exceptID already has the
+// email address.
+func (r *UsersRepo) EmailTaken(ctx context.Context, email string, exceptID int) (_ bool, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return r.taken(ctx, "LOWER(email) = LOWER(?)", email, exceptID)
+}
+
+func (r *UsersRepo) taken(ctx context.Context, match string, value string, exceptID int) (bool, error) {
+	var taken bool
+	query := "SELECT EXISTS (SELECT 1 FROM users WHERE " + match + " AND id <> ?)"
+	if err := r.db.QueryRowContext(ctx, query, value, exceptID).Scan(&taken); err != nil {
+		log.Println("Error checking user uniqueness:", err)
+		return false, err
+	}
+	return taken, nil
+}
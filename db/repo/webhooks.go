@@ -0,0 +1,483 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"snippetier/apperr"
+	"strings"
+	"time"
+)
+
+// Webhook events.
+const (
+	EventSnippetCreated = "snippet.created"
+	EventSnippetUpdated = "snippet.updated"
+	EventSnippetDeleted = "snippet.deleted"
+	EventSnippetStarred = "snippet.starred"
+	EventCommentCreated = "comment.created"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to.
+var WebhookEvents = []string{EventSnippetCreated, EventSnippetUpdated, EventSnippetDeleted, EventSnippetStarred, EventCommentCreated}
+
+// Webhook is a subscription to the events on a user's snippets or, when
+// OrgID is set, on an organization's library. Secret signs the payloads and
+// is only shown when the webhook is created.
+type Webhook struct {
+	ID        int      `json:"id"`
+	UserID    *int     `json:"userId,omitempty"`
+	OrgID     *int     `json:"orgId,omitempty"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	Secret    string   `json:"-"`
+	CreatedBy int      `json:"createdBy"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+// Delivery statuses. Pending deliveries are retried until they succeed or
+// run out of attempts and fail.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is one event sent, or to be sent, to a webhook. Redeliveries
+// are new deliveries of the same payload and point at the original.
+type Delivery struct {
+	ID             int       `json:"id"`
+	WebhookID      int       `json:"webhookId"`
+	Event          string    `json:"event"`
+	Payload        string    `json:"payload"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  *string   `json:"nextAttemptAt,omitempty"`
+	LastStatusCode *int      `json:"lastStatusCode,omitempty"`
+	RedeliveryOf   *int      `json:"redeliveryOf,omitempty"`
+	CreatedAt      string    `json:"createdAt"`
+	DeliveredAt    *string   `json:"deliveredAt,omitempty"`
+	Log            []Attempt `json:"log,omitempty"`
+}
+
+// Attempt records one try at a delivery: the receiver's status code, or
+// the error if no response was received.
+type Attempt struct {
+	ID         int     `json:"id"`
+	StatusCode *int    `json:"statusCode,omitempty"`
+	Error      *string `json:"error,omitempty"`
+	DurationMs int     `json:"durationMs"`
+	CreatedAt  string  `json:"createdAt"`
+}
+
+// DueDelivery is a pending delivery together with what is needed to send it.
+type DueDelivery struct {
+	ID       int
+	Event    string
+	Payload  []byte
+	Attempts int
+	URL      string
+	Secret   string
+}
+
+// WebhookPayload is the JSON body posted to webhooks. Snippet is described
+// as it was when the event happened; content is left out so that receivers
+// fetch it with the usual access checks.
+type WebhookPayload struct {
+	Event      string         `json:"event"`
+	OccurredAt string         `json:"occurredAt"`
+	ActorID    int            `json:"actorId"`
+	Snippet    webhookSnippet `json:"snippet"`
+	CommentID  *int           `json:"commentId,omitempty"`
+}
+
+type webhookSnippet struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Language  string `json:"language"`
+	Revision  int    `json:"revision"`
+	UserID    int    `json:"userId"`
+	OrgID     *int   `json:"orgId,omitempty"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+var (
+	ErrWebhookNotFound  = apperr.New(apperr.ErrNotFound, "webhook not found")
+	ErrDeliveryNotFound = apperr.New(apperr.ErrNotFound, "delivery not found")
+)
+
+const webhookColumns = "id, user_id, org_id, url, events, active, secret, created_by, created_at"
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var hook Webhook
+	var events string
+	err := row.Scan(&hook.ID, &hook.UserID, &hook.OrgID, &hook.URL, &events, &hook.Active, &hook.Secret, &hook.CreatedBy, &hook.CreatedAt)
+	hook.Events = splitEvents(events)
+	return hook, err
+}
+
+const deliveryColumns = "id, webhook_id, event, payload, status, attempts, next_attempt_at, last_status_code, redelivery_of, created_at, delivered_at"
+
+func scanDelivery(row rowScanner) (Delivery, error) {
+	var d Delivery
+	err := row.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastStatusCode, &d.RedeliveryOf, &d.CreatedAt, &d.DeliveredAt)
+	return d, err
+}
+
+func splitEvents(events string) []string {
+	if events == "" {
+		return []string{}
+	}
+	return strings.Split(events, ",")
+}
+
+type WebhooksRepo struct {
+	db      DBTX
+	timeout time.Duration
+}
+
+// NewWebhooksRepo returns a repo whose queries are bounded by timeout unless
+// the caller's context sets an earlier deadline. Zero means no default.
+func NewWebhooksRepo(db DBTX, timeout time.Duration) *WebhooksRepo {
+	return &WebhooksRepo{db, timeout}
+}
+
+// CreateWebhook saves a webhook and returns it, secret included.
+func (r *WebhooksRepo) CreateWebhook(ctx context.Context, hook Webhook) (_ Webhook, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "INSERT INTO webhooks (user_id, org_id, url, events, active, secret, created_by) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	res, err := r.db.ExecContext(ctx, query, hook.UserID, hook.OrgID, hook.URL, strings.Join(hook.Events, ","), hook.Active, hook.Secret, hook.CreatedBy)
+	if err != nil {
+		log.Println("Error creating webhook:", err)
+		return Webhook{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Webhook{}, err
+	}
+	return r.getWebhook(ctx, int(id))
+}
+
+// GetWebhook retrieves a webhook by ID.
+func (r *WebhooksRepo) GetWebhook(ctx context.Context, id int) (_ Webhook, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return r.getWebhook(ctx, id)
+}
+
+func (r *WebhooksRepo) getWebhook(ctx context.Context, id int) (Webhook, error) {
+	hook, err := scanWebhook(r.db.QueryRowContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving webhook:", err)
+		return Webhook{}, err
+	}
+	return hook, nil
+}
+
+// GetWebhooksByUser lists a user's own webhooks.
+func (r *WebhooksRepo) GetWebhooksByUser(ctx context.Context, userID int) (_ []Webhook, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return r.queryWebhooks(ctx, "user_id = ?", userID)
+}
+
+// GetWebhooksByOrg lists an organization's webhooks.
+func (r *WebhooksRepo) GetWebhooksByOrg(ctx context.Context, orgID int) (_ []Webhook, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return r.queryWebhooks(ctx, "org_id = ?", orgID)
+}
+
+func (r *WebhooksRepo) queryWebhooks(ctx context.Context, where string, args ...any) ([]Webhook, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		log.Println("Error retrieving webhooks:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			log.Println("Error scanning webhook:", err)
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+// UpdateWebhook changes the URL, events and active flag of a webhook.
+func (r *WebhooksRepo) UpdateWebhook(ctx context.Context, id int, hook Webhook) (_ Webhook, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "UPDATE webhooks SET url = ?, events = ?, active = ? WHERE id = ?"
+	res, err := r.db.ExecContext(ctx, query, hook.URL, strings.Join(hook.Events, ","), hook.Active, id)
+	if err != nil {
+		log.Println("Error updating webhook:", err)
+		return Webhook{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Webhook{}, err
+	}
+	if n == 0 {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	return r.getWebhook(ctx, id)
+}
+
+// DeleteWebhook deletes a webhook with its deliveries and their log.
+func (r *WebhooksRepo) DeleteWebhook(ctx context.Context, id int) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		queries := []string{
+			"DELETE FROM webhook_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = ?)",
+			"DELETE FROM webhook_deliveries WHERE webhook_id = ?",
+			"DELETE FROM webhooks WHERE id = ?",
+		}
+		for _, query := range queries {
+			if _, err := tx.ExecContext(ctx, query, id); err != nil {
+				log.Println("Error deleting webhook:", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetDeliveries lists the latest deliveries of a webhook, newest first,
+// without their log.
+func (r *WebhooksRepo) GetDeliveries(ctx context.Context, webhookID, limit int) (_ []Delivery, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?"
+	rows, err := r.db.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		log.Println("Error retrieving deliveries:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			log.Println("Error scanning delivery:", err)
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// GetDelivery retrieves a delivery of a webhook with the log of its
+// attempts, oldest first.
+func (r *WebhooksRepo) GetDelivery(ctx context.Context, webhookID, id int) (_ Delivery, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	d, err := r.getDelivery(ctx, webhookID, id)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	query := "SELECT id, status_code, error, duration_ms, created_at FROM webhook_attempts WHERE delivery_id = ? ORDER BY id"
+	rows, err := r.db.QueryContext(ctx, query, id)
+	if err != nil {
+		log.Println("Error retrieving delivery log:", err)
+		return Delivery{}, err
+	}
+	defer rows.Close()
+
+	d.Log = []Attempt{}
+	for rows.Next() {
+		var a Attempt
+		if err := rows.Scan(&a.ID, &a.StatusCode, &a.Error, &a.DurationMs, &a.CreatedAt); err != nil {
+			log.Println("Error scanning delivery attempt:", err)
+			return Delivery{}, err
+		}
+		d.Log = append(d.Log, a)
+	}
+	return d, rows.Err()
+}
+
+func (r *WebhooksRepo) getDelivery(ctx context.Context, webhookID, id int) (Delivery, error) {
+	query := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE id = ? AND webhook_id = ?"
+	d, err := scanDelivery(r.db.QueryRowContext(ctx, query, id, webhookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+	if err != nil {
+		log.Println("Error retrieving delivery:", err)
+		return Delivery{}, err
+	}
+	return d, nil
+}
+
+// Redeliver queues the payload of a past delivery again as a new delivery,
+// whatever became of the original, and returns it.
+func (r *WebhooksRepo) Redeliver(ctx context.Context, webhookID, id int) (_ Delivery, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	original, err := r.getDelivery(ctx, webhookID, id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	query := "INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at, redelivery_of) VALUES (?, ?, ?, ?, ?, ?)"
+	res, err := r.db.ExecContext(ctx, query, webhookID, original.Event, original.Payload, DeliveryPending, dbTime(time.Now()), id)
+	if err != nil {
+		log.Println("Error queueing redelivery:", err)
+		return Delivery{}, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return Delivery{}, err
+	}
+	return r.getDelivery(ctx, webhookID, int(newID))
+}
+
+// DueDeliveries lists up to limit pending deliveries of active webhooks
+// whose next attempt is due at now, oldest first.
+func (r *WebhooksRepo) DueDeliveries(ctx context.Context, now time.Time, limit int) (_ []DueDelivery, err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	query := `
+        SELECT webhook_deliveries.id, webhook_deliveries.event, webhook_deliveries.payload, webhook_deliveries.attempts, webhooks.url, webhooks.secret
+        FROM webhook_deliveries JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+        WHERE webhook_deliveries.status = ? AND webhook_deliveries.next_attempt_at <= ? AND webhooks.active = 1
+        ORDER BY webhook_deliveries.next_attempt_at, webhook_deliveries.id
+        LIMIT ?
+    `
+	rows, err := r.db.QueryContext(ctx, query, DeliveryPending, dbTime(now), limit)
+	if err != nil {
+		log.Println("Error retrieving due deliveries:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []DueDelivery
+	for rows.Next() {
+		var d DueDelivery
+		var payload string
+		if err := rows.Scan(&d.ID, &d.Event, &payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			log.Println("Error scanning delivery:", err)
+			return nil, err
+		}
+		d.Payload = []byte(payload)
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+// RecordAttempt logs an attempt at a delivery and moves the delivery on:
+// to delivered if the attempt succeeded, to failed if it did not and
+// retryAt is nil, or otherwise to another attempt at retryAt.
+func (r *WebhooksRepo) RecordAttempt(ctx context.Context, deliveryID int, attempt Attempt, succeeded bool, retryAt *time.Time) (err error) {
+	ctx, done := scope(ctx, r.timeout, &err)
+	defer done()
+
+	return inTx(ctx, r.db, func(tx DBTX) error {
+		query := "INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms) VALUES (?, ?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, query, deliveryID, attempt.StatusCode, attempt.Error, attempt.DurationMs); err != nil {
+			log.Println("Error recording delivery attempt:", err)
+			return err
+		}
+
+		status, next, deliveredAt := DeliveryFailed, (*string)(nil), (*string)(nil)
+		switch {
+		case succeeded:
+			now := dbTime(time.Now())
+			status, deliveredAt = DeliveryDelivered, &now
+		case retryAt != nil:
+			at := dbTime(*retryAt)
+			status, next = DeliveryPending, &at
+		}
+		query = `
+            UPDATE webhook_deliveries
+            SET status = ?, attempts = attempts + 1, next_attempt_at = ?, last_status_code = ?, delivered_at = ?
+            WHERE id = ?
+        `
+		if _, err := tx.ExecContext(ctx, query, status, next, attempt.StatusCode, deliveredAt, deliveryID); err != nil {
+			log.Println("Error updating delivery:", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// queueWebhooks queues a delivery of event to every active webhook
+// subscribed to it on the snippet's owner or organization.
+func queueWebhooks(ctx context.Context, tx DBTX, event string, actorID int, s webhookSnippet, commentID *int) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, events FROM webhooks WHERE active = 1 AND (user_id = ? OR org_id = ?)", s.UserID, s.OrgID)
+	if err != nil {
+		log.Println("Error retrieving webhooks:", err)
+		return err
+	}
+	var hookIDs []int
+	for rows.Next() {
+		var id int
+		var events string
+		if err := rows.Scan(&id, &events); err != nil {
+			rows.Close()
+			log.Println("Error scanning webhook:", err)
+			return err
+		}
+		for _, e := range splitEvents(events) {
+			if e == event {
+				hookIDs = append(hookIDs, id)
+				break
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(hookIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(WebhookPayload{
+		Event:      event,
+		OccurredAt: now.UTC().Format(time.RFC3339),
+		ActorID:    actorID,
+		Snippet:    s,
+		CommentID:  commentID,
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range hookIDs {
+		query := "INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at) VALUES (?, ?, ?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, query, id, event, string(payload), DeliveryPending, dbTime(now)); err != nil {
+			log.Println("Error queueing delivery:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// dbTime formats t the way timestamp columns store it, in UTC.
+func dbTime(t time.Time) string {
+	return t.UTC().Format(time.DateTime)
+}
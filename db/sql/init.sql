@@ -23,7 +23,14 @@ CREATE TABLE IF NOT EXISTS snippets (
                           name TEXT NOT NULL,
                           description TEXT,
                           content TEXT,
+                          language TEXT NOT NULL DEFAULT '',
+                          revision INTEGER NOT NULL DEFAULT 1,
                           user_id INTEGER NOT NULL,
+                          org_id INTEGER,
+                          expires_at DATETIME,
+                          burn_after_read BOOLEAN NOT NULL DEFAULT 0,
+                          forked_from INTEGER,
+                          forked_revision INTEGER,
                           created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
                           updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
@@ -37,3 +44,299 @@ BEGIN
     WHERE id = NEW.id;
 END;
 
+-- Lets the janitor find expired snippets without a full table scan
+CREATE INDEX IF NOT EXISTS idx_snippets_expires_at ON snippets (expires_at);
+
+-- Lets fork counts and fork lists be computed without a full table scan
+CREATE INDEX IF NOT EXISTS idx_snippets_forked_from ON snippets (forked_from);
+
+-- Lets an organization's library be listed without a full table scan
+CREATE INDEX IF NOT EXISTS idx_snippets_org_id ON snippets (org_id);
+
+-- Create the "snippet_revisions" table holding the content history of each
+-- snippet. merged_from is set on the history of a duplicate merged into the
+-- snippet, to the duplicate's old ID.
+CREATE TABLE IF NOT EXISTS snippet_revisions (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          snippet_id INTEGER NOT NULL,
+                          merged_from INTEGER NOT NULL DEFAULT 0,
+                          revision INTEGER NOT NULL,
+                          author_id INTEGER NOT NULL,
+                          content TEXT,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          UNIQUE (snippet_id, merged_from, revision),
+                          FOREIGN KEY (snippet_id) REFERENCES snippets (id) ON DELETE CASCADE
+);
+
+-- Create the "snippet_tags" table holding the free-form tags of each snippet
+CREATE TABLE IF NOT EXISTS snippet_tags (
+                          snippet_id INTEGER NOT NULL,
+                          tag TEXT NOT NULL,
+                          PRIMARY KEY (snippet_id, tag),
+                          FOREIGN KEY (snippet_id) REFERENCES snippets (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_snippet_tags_tag ON snippet_tags (tag);
+
+-- Create the "stars" table recording which users starred which snippets
+CREATE TABLE IF NOT EXISTS stars (
+                          user_id INTEGER NOT NULL,
+                          snippet_id INTEGER NOT NULL,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          PRIMARY KEY (user_id, snippet_id),
+                          FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+                          FOREIGN KEY (snippet_id) REFERENCES snippets (id) ON DELETE CASCADE
+);
+
+-- Lets star counts be computed per snippet without a full table scan
+CREATE INDEX IF NOT EXISTS idx_stars_snippet_id ON stars (snippet_id);
+
+-- Create the "comments" table holding review threads on snippets. Replies
+-- point at the root comment of their thread; anchors point at a line range
+-- of a revision and are marked outdated when the lines no longer exist.
+CREATE TABLE IF NOT EXISTS comments (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          snippet_id INTEGER NOT NULL,
+                          parent_id INTEGER,
+                          author_id INTEGER NOT NULL,
+                          body TEXT NOT NULL,
+                          anchor_revision INTEGER,
+                          line_start INTEGER,
+                          line_end INTEGER,
+                          outdated BOOLEAN NOT NULL DEFAULT 0,
+                          deleted BOOLEAN NOT NULL DEFAULT 0,
+                          removed_by INTEGER,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          FOREIGN KEY (snippet_id) REFERENCES snippets (id) ON DELETE CASCADE,
+                          FOREIGN KEY (parent_id) REFERENCES comments (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_comments_snippet_id ON comments (snippet_id);
+
+-- Create the "orgs" table holding organizations, whose members share a
+-- snippet library
+CREATE TABLE IF NOT EXISTS orgs (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          slug TEXT NOT NULL UNIQUE,
+                          name TEXT NOT NULL,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create the "org_members" table recording each member's role: owner,
+-- maintainer or member
+CREATE TABLE IF NOT EXISTS org_members (
+                          org_id INTEGER NOT NULL,
+                          user_id INTEGER NOT NULL,
+                          role TEXT NOT NULL DEFAULT 'member',
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          PRIMARY KEY (org_id, user_id),
+                          FOREIGN KEY (org_id) REFERENCES orgs (id) ON DELETE CASCADE,
+                          FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Lets permission checks find a user's memberships without a full table scan
+CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members (user_id);
+
+-- Create the "org_invites" table holding invitations to join an
+-- organization, addressed to an email address or a GitHub username. Only a
+-- hash of the nonce in the signed invitation link is stored.
+CREATE TABLE IF NOT EXISTS org_invites (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          org_id INTEGER NOT NULL,
+                          email TEXT,
+                          github_username TEXT,
+                          role TEXT NOT NULL DEFAULT 'member',
+                          invited_by INTEGER NOT NULL,
+                          token_hash TEXT NOT NULL,
+                          status TEXT NOT NULL DEFAULT 'pending',
+                          responded_by INTEGER,
+                          expires_at DATETIME NOT NULL,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          responded_at DATETIME,
+                          FOREIGN KEY (org_id) REFERENCES orgs (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_invites_org_id ON org_invites (org_id);
+
+-- Create the "org_audit" table recording who invited whom and how each
+-- invitation was answered
+CREATE TABLE IF NOT EXISTS org_audit (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          org_id INTEGER NOT NULL,
+                          actor_id INTEGER NOT NULL,
+                          action TEXT NOT NULL,
+                          subject TEXT NOT NULL,
+                          invite_id INTEGER,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          FOREIGN KEY (org_id) REFERENCES orgs (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_audit_org_id ON org_audit (org_id);
+
+-- Create the "collections" table holding ordered, nestable groups of
+-- snippets. Position orders a collection among its siblings.
+CREATE TABLE IF NOT EXISTS collections (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          user_id INTEGER NOT NULL,
+                          parent_id INTEGER,
+                          name TEXT NOT NULL,
+                          description TEXT NOT NULL DEFAULT '',
+                          visibility TEXT NOT NULL DEFAULT 'private',
+                          position INTEGER NOT NULL DEFAULT 0,
+                          share_token TEXT UNIQUE,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+                          FOREIGN KEY (parent_id) REFERENCES collections (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_collections_user_id ON collections (user_id);
+CREATE INDEX IF NOT EXISTS idx_collections_parent_id ON collections (parent_id);
+
+-- Create the "collection_items" table placing snippets in collections
+CREATE TABLE IF NOT EXISTS collection_items (
+                          collection_id INTEGER NOT NULL,
+                          snippet_id INTEGER NOT NULL,
+                          position INTEGER NOT NULL DEFAULT 0,
+                          added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          PRIMARY KEY (collection_id, snippet_id),
+                          FOREIGN KEY (collection_id) REFERENCES collections (id) ON DELETE CASCADE,
+                          FOREIGN KEY (snippet_id) REFERENCES snippets (id) ON DELETE CASCADE
+);
+
+-- Lets a deleted snippet be removed from every collection without a full
+-- table scan
+CREATE INDEX IF NOT EXISTS idx_collection_items_snippet_id ON collection_items (snippet_id);
+
+-- Create the "follows" table recording which users follow which
+CREATE TABLE IF NOT EXISTS follows (
+                          follower_id INTEGER NOT NULL,
+                          followee_id INTEGER NOT NULL,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          PRIMARY KEY (follower_id, followee_id),
+                          FOREIGN KEY (follower_id) REFERENCES users (id) ON DELETE CASCADE,
+                          FOREIGN KEY (followee_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Lets follower lists be read without a full table scan
+CREATE INDEX IF NOT EXISTS idx_follows_followee_id ON follows (followee_id);
+
+-- Create the "activity" table logging what users do to snippets. Feeds are
+-- assembled from it on read, so that visibility is checked at read time.
+CREATE TABLE IF NOT EXISTS activity (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          actor_id INTEGER NOT NULL,
+                          verb TEXT NOT NULL,
+                          snippet_id INTEGER NOT NULL,
+                          comment_id INTEGER,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE CASCADE,
+                          FOREIGN KEY (snippet_id) REFERENCES snippets (id) ON DELETE CASCADE
+);
+
+-- Lets the feed walk the followed users' activity newest first, and find
+-- the activity of a snippet, without full table scans
+CREATE INDEX IF NOT EXISTS idx_activity_actor_id ON activity (actor_id, id);
+CREATE INDEX IF NOT EXISTS idx_activity_snippet_id ON activity (snippet_id, id);
+
+-- Create the "saved_searches" table holding snippet searches users keep,
+-- for example to follow them as feeds
+CREATE TABLE IF NOT EXISTS saved_searches (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          user_id INTEGER NOT NULL,
+                          name TEXT NOT NULL,
+                          query TEXT NOT NULL DEFAULT '',
+                          language TEXT NOT NULL DEFAULT '',
+                          tag TEXT NOT NULL DEFAULT '',
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_searches_user_id ON saved_searches (user_id);
+
+-- Create the "webhooks" table holding subscriptions to the events on a
+-- user's snippets, or on an organization's library when org_id is set.
+-- events is a comma-separated list.
+CREATE TABLE IF NOT EXISTS webhooks (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          user_id INTEGER,
+                          org_id INTEGER,
+                          url TEXT NOT NULL,
+                          events TEXT NOT NULL,
+                          active BOOLEAN NOT NULL DEFAULT 1,
+                          secret TEXT NOT NULL,
+                          created_by INTEGER NOT NULL,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+                          FOREIGN KEY (org_id) REFERENCES orgs (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks (user_id);
+CREATE INDEX IF NOT EXISTS idx_webhooks_org_id ON webhooks (org_id);
+
+-- Create the "webhook_deliveries" table, the durable queue of events to
+-- send. Rows stay after they are sent as the delivery log.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          webhook_id INTEGER NOT NULL,
+                          event TEXT NOT NULL,
+                          payload TEXT NOT NULL,
+                          status TEXT NOT NULL,
+                          attempts INTEGER NOT NULL DEFAULT 0,
+                          next_attempt_at DATETIME,
+                          last_status_code INTEGER,
+                          redelivery_of INTEGER,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          delivered_at DATETIME,
+                          FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+);
+
+-- Lets the dispatcher find due deliveries, and the log list a webhook's
+-- deliveries, without full table scans
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id);
+
+-- Create the "webhook_attempts" table logging each try at a delivery
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                          delivery_id INTEGER NOT NULL,
+                          status_code INTEGER,
+                          error TEXT,
+                          duration_ms INTEGER NOT NULL,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                          FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery_id ON webhook_attempts (delivery_id);
+
+-- Create the "snippet_signatures" table holding the MinHash signature of
+-- each snippet's content, for near-duplicate detection
+CREATE TABLE IF NOT EXISTS snippet_signatures (
+                          snippet_id INTEGER PRIMARY KEY,
+                          signature TEXT NOT NULL,
+                          FOREIGN KEY (snippet_id) REFERENCES snippets (id) ON DELETE CASCADE
+);
+
+-- Create the "snippet_bands" table holding the bucket each band of a
+-- signature hashes to. Snippets sharing a bucket are candidate duplicates.
+CREATE TABLE IF NOT EXISTS snippet_bands (
+                          snippet_id INTEGER NOT NULL,
+                          band INTEGER NOT NULL,
+                          bucket INTEGER NOT NULL,
+                          PRIMARY KEY (snippet_id, band),
+                          FOREIGN KEY (snippet_id) REFERENCES snippets (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_snippet_bands_bucket ON snippet_bands (band, bucket);
+
+-- Create the "snippet_redirects" table pointing the IDs of duplicates that
+-- were merged away at the snippet they were merged into
+CREATE TABLE IF NOT EXISTS snippet_redirects (
+                          old_id INTEGER PRIMARY KEY,
+                          new_id INTEGER NOT NULL,
+                          merged_by INTEGER NOT NULL,
+                          created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_snippet_redirects_new_id ON snippet_redirects (new_id);
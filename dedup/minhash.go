@@ -0,0 +1,181 @@
+// Package dedup detects near-duplicate snippets. Content is cut into
+// overlapping shingles of tokens and summarized by a MinHash signature,
+// whose agreement with another estimates the Jaccard similarity of their
+// shingle sets. Signatures are split into bands for locality-sensitive
+// hashing: snippets sharing a band bucket are candidate duplicates, which
+// are then confirmed by comparing whole signatures.
+package dedup
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// NumHashes is the length of a signature.
+	NumHashes = 64
+	// Bands and Rows split a signature for locality-sensitive hashing:
+	// pairs 70% similar become candidates 99% of the time, pairs 30%
+	// similar 12% of the time.
+	Bands = 16
+	Rows  = NumHashes / Bands
+	// Threshold is the estimated similarity from which two snippets are
+	// likely duplicates. The same short command with one value changed
+	// scores about that.
+	Threshold = 0.7
+
+	// shingleSize is the number of tokens in a shingle.
+	shingleSize = 3
+)
+
+// seeds pick the NumHashes hash functions, as fixed random values so that
+// signatures stay comparable across restarts.
+var seeds = func() [NumHashes]uint64 {
+	var s [NumHashes]uint64
+	x := uint64(0x5eed)
+	for i := range s {
+		x = splitmix(x)
+		s[i] = x
+	}
+	return s
+}()
+
+// Signature is the MinHash signature of a snippet's content.
+type Signature [NumHashes]uint64
+
+// Sign returns the signature of content, and false when content has
+// nothing to compare, e.g. when it is blank.
+func Sign(content string) (Signature, bool) {
+	shingles := Shingles(content)
+	var sig Signature
+	if len(shingles) == 0 {
+		return sig, false
+	}
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	for _, sh := range shingles {
+		for i, seed := range seeds {
+			if h := splitmix(sh ^ seed); h < sig[i] {
+				sig[i] = h
+			}
+		}
+	}
+	return sig, true
+}
+
+// Shingles returns the hashes of the overlapping runs of shingleSize
+// tokens of content. Tokens are words and single punctuation characters,
+// lowercased, so that whitespace, indentation and case do not matter.
+// Content shorter than a shingle is one shingle.
+func Shingles(content string) []uint64 {
+	tokens := tokenize(content)
+	if len(tokens) == 0 {
+		return nil
+	}
+	n := max(len(tokens)-shingleSize+1, 1)
+	seen := make(map[uint64]bool, n)
+	shingles := make([]uint64, 0, n)
+	for i := 0; i < n; i++ {
+		h := fnv.New64a()
+		for _, t := range tokens[i:min(i+shingleSize, len(tokens))] {
+			h.Write([]byte(t))
+			h.Write([]byte{0})
+		}
+		if sum := h.Sum64(); !seen[sum] {
+			seen[sum] = true
+			shingles = append(shingles, sum)
+		}
+	}
+	return shingles
+}
+
+func tokenize(content string) []string {
+	var tokens []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(content) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			word = append(word, r)
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			tokens = append(tokens, string(r))
+		}
+	}
+	flush()
+	return tokens
+}
+
+// Similarity estimates the Jaccard similarity of the shingle sets two
+// signatures were computed from.
+func Similarity(a, b Signature) float64 {
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / NumHashes
+}
+
+// Buckets returns, for each band of the signature, the bucket it hashes
+// to. Snippets whose signatures share a bucket in some band are candidate
+// duplicates.
+func (s Signature) Buckets() [Bands]int64 {
+	var buckets [Bands]int64
+	var buf [8]byte
+	for b := range buckets {
+		h := fnv.New64a()
+		for _, v := range s[b*Rows : (b+1)*Rows] {
+			binary.BigEndian.PutUint64(buf[:], v)
+			h.Write(buf[:])
+		}
+		buckets[b] = int64(h.Sum64())
+	}
+	return buckets
+}
+
+// String encodes the signature as hex, for storage.
+func (s Signature) String() string {
+	buf := make([]byte, 0, NumHashes*8)
+	for _, v := range s {
+		buf = binary.BigEndian.AppendUint64(buf, v)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// ParseSignature decodes a signature encoded by String.
+func ParseSignature(text string) (Signature, error) {
+	var sig Signature
+	buf, err := hex.DecodeString(text)
+	if err != nil {
+		return sig, err
+	}
+	if len(buf) != NumHashes*8 {
+		return sig, errors.New("signature has the wrong length")
+	}
+	for i := range sig {
+		sig[i] = binary.BigEndian.Uint64(buf[i*8:])
+	}
+	return sig, nil
+}
+
+// splitmix is the SplitMix64 finalizer, a fast well-mixing hash of x.
+func splitmix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,219 @@
+// Package diff computes line-based differences between two texts.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Kind int
+
+const (
+	Equal Kind = iota
+	Insert
+	Delete
+)
+
+// Edit is a single line of an edit script. ALine and BLine are zero-based
+// indexes into the old and new line slices, or -1 when the line does not
+// exist on that side.
+type Edit struct {
+	Kind  Kind
+	Text  string
+	ALine int
+	BLine int
+}
+
+// SplitLines splits s into lines without their trailing newlines.
+// A trailing newline does not produce an empty last line.
+func SplitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines returns the shortest edit script turning a into b, computed with
+// Myers' O(ND) algorithm.
+func Lines(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	offset := max
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, offset)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrack(trace [][]int, a, b []string, offset int) []Edit {
+	x, y := len(a), len(b)
+	var edits []Edit
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, Edit{Kind: Equal, Text: a[x], ALine: x, BLine: y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				edits = append(edits, Edit{Kind: Insert, Text: b[y], ALine: -1, BLine: y})
+			} else {
+				x--
+				edits = append(edits, Edit{Kind: Delete, Text: a[x], ALine: x, BLine: -1})
+			}
+		}
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// Unified renders the difference between a and b in unified diff format
+// with the given number of context lines. It returns an empty string when
+// the texts are equal.
+func Unified(a, b, fromName, toName string, context int) string {
+	edits := Lines(SplitLines(a), SplitLines(b))
+
+	changed := false
+	for _, e := range edits {
+		if e.Kind != Equal {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for start := 0; start < len(edits); {
+		// Find the next change and grow a hunk around it until the gap to
+		// the following change is wider than twice the context.
+		first := start
+		for first < len(edits) && edits[first].Kind == Equal {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		last := first
+		for i := first; i < len(edits); i++ {
+			if edits[i].Kind != Equal {
+				last = i
+			} else if i-last > 2*context {
+				break
+			}
+		}
+
+		from := first - context
+		if from < start {
+			from = start
+		}
+		to := last + context + 1
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		writeHunk(&out, edits, from, to)
+		start = to
+	}
+
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, edits []Edit, from, to int) {
+	// Line numbers of the hunk are the count of lines on each side that
+	// precede it.
+	aStart, bStart := 0, 0
+	for _, e := range edits[:from] {
+		if e.Kind != Insert {
+			aStart++
+		}
+		if e.Kind != Delete {
+			bStart++
+		}
+	}
+
+	hunk := edits[from:to]
+	aCount, bCount := 0, 0
+	for _, e := range hunk {
+		if e.Kind != Insert {
+			aCount++
+		}
+		if e.Kind != Delete {
+			bCount++
+		}
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+	for _, e := range hunk {
+		switch e.Kind {
+		case Equal:
+			out.WriteString(" " + e.Text + "\n")
+		case Insert:
+			out.WriteString("+" + e.Text + "\n")
+		case Delete:
+			out.WriteString("-" + e.Text + "\n")
+		}
+	}
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		// An empty range points at the line before the hunk.
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,94 @@
+package diff
+
+import "strings"
+
+// Merge3 merges the changes that ours and theirs each made to base, line by
+// line. Regions changed on only one side, or identically on both, merge
+// cleanly; regions changed differently on both sides are written out
+// between conflict markers labelled with oursName and theirsName. It returns
+// the merged text and the number of conflicting regions.
+func Merge3(base, ours, theirs, oursName, theirsName string) (string, int) {
+	o, a, b := SplitLines(base), SplitLines(ours), SplitLines(theirs)
+	matchA, matchB := MapLines(o, a), MapLines(o, b)
+
+	var out []string
+	conflicts := 0
+	emit := func(chunkO, chunkA, chunkB []string) {
+		switch {
+		case equalLines(chunkA, chunkO):
+			out = append(out, chunkB...)
+		case equalLines(chunkB, chunkO), equalLines(chunkA, chunkB):
+			out = append(out, chunkA...)
+		default:
+			conflicts++
+			out = append(out, "<<<<<<< "+oursName)
+			out = append(out, chunkA...)
+			out = append(out, "=======")
+			out = append(out, chunkB...)
+			out = append(out, ">>>>>>> "+theirsName)
+		}
+	}
+
+	io, ia, ib := 0, 0, 0
+	for io < len(o) || ia < len(a) || ib < len(b) {
+		// Copy the lines that are unchanged on both sides.
+		n := 0
+		for io+n < len(o) && matchA[io+n] == ia+n && matchB[io+n] == ib+n {
+			n++
+		}
+		if n > 0 {
+			out = append(out, o[io:io+n]...)
+			io, ia, ib = io+n, ia+n, ib+n
+			continue
+		}
+
+		// Find the next base line both sides kept; everything before it is
+		// a changed region.
+		next := io
+		for next < len(o) && (matchA[next] < 0 || matchB[next] < 0) {
+			next++
+		}
+		if next == len(o) {
+			emit(o[io:], a[ia:], b[ib:])
+			break
+		}
+		emit(o[io:next], a[ia:matchA[next]], b[ib:matchB[next]])
+		io, ia, ib = next, matchA[next], matchB[next]
+	}
+
+	if len(out) == 0 {
+		return "", conflicts
+	}
+	merged := strings.Join(out, "\n")
+	if strings.HasSuffix(ours, "\n") || (ours == "" && strings.HasSuffix(theirs, "\n")) {
+		merged += "\n"
+	}
+	return merged, conflicts
+}
+
+// MapLines maps each line of a to the index of the line of b it is kept as
+// in the shortest edit script, or to -1 when it was deleted.
+func MapLines(a, b []string) []int {
+	match := make([]int, len(a))
+	for i := range match {
+		match[i] = -1
+	}
+	for _, e := range Lines(a, b) {
+		if e.Kind == Equal {
+			match[e.ALine] = e.BLine
+		}
+	}
+	return match
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
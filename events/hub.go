@@ -0,0 +1,172 @@
+// Package events fans committed snippet changes out to real-time
+// subscribers.
+package events
+
+import (
+	"slices"
+	"snippetier/db/repo"
+	"sync"
+	"time"
+)
+
+// bufferSize is how many events a subscriber may fall behind by before it
+// is cut off.
+const bufferSize = 64
+
+// Event is a change as sent to subscribers. IDs increase in publishing
+// order and restart with the process.
+type Event struct {
+	ID int64 `json:"id"`
+	repo.Change
+	At string `json:"at"`
+}
+
+// Filter narrows down the events of a subscription. Zero values match
+// everything.
+type Filter struct {
+	SnippetID int
+	UserID    int
+	OrgID     int
+}
+
+func (f Filter) match(c repo.Change) bool {
+	return (f.SnippetID == 0 || c.SnippetID == f.SnippetID) &&
+		(f.UserID == 0 || c.UserID == f.UserID) &&
+		(f.OrgID == 0 || c.OrgID != nil && *c.OrgID == f.OrgID)
+}
+
+// Viewer is who a subscription is for: a user and the organizations they
+// belong to. Membership is read when subscribing and kept current with
+// SetOrgs.
+type Viewer struct {
+	UserID int
+	OrgIDs []int
+}
+
+// canSee reports whether the viewer may see a change: to their own
+// snippets, to their organizations' libraries, or to public snippets.
+// Burn-after-read snippets are only seen by their owner.
+func (v Viewer) canSee(c repo.Change) bool {
+	if c.UserID == v.UserID {
+		return true
+	}
+	if c.OrgID != nil {
+		return slices.Contains(v.OrgIDs, *c.OrgID)
+	}
+	return !c.BurnAfterRead
+}
+
+// Hub delivers published changes to the subscriptions that match and may
+// see them. Publishing never blocks: a subscriber that falls bufferSize
+// events behind is cut off and has to resynchronize.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	lastID int64
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription is a stream of events for one subscriber.
+type Subscription struct {
+	hub    *Hub
+	viewer Viewer
+	filter Filter
+	ch     chan Event
+	done   bool
+	lagged bool
+}
+
+// Subscribe opens a subscription. It must be closed when the subscriber
+// goes away.
+func (h *Hub) Subscribe(viewer Viewer, filter Filter) *Subscription {
+	s := &Subscription{hub: h, viewer: viewer, filter: filter, ch: make(chan Event, bufferSize)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		s.done = true
+		close(s.ch)
+		return s
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// Events returns the subscription's events. The channel is closed when the
+// subscription ends: see Lagged for why.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Lagged reports whether the subscription was cut off for falling behind,
+// rather than closed or shut down. It is meant to be read once Events is
+// closed.
+func (s *Subscription) Lagged() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.lagged
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.endLocked(s)
+}
+
+// SetOrgs replaces the organizations the viewer belongs to, once their
+// membership changed. A subscription filtered on an organization the viewer
+// no longer belongs to ends.
+func (s *Subscription) SetOrgs(orgIDs []int) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.viewer.OrgIDs = orgIDs
+	if s.filter.OrgID != 0 && !slices.Contains(orgIDs, s.filter.OrgID) {
+		s.hub.endLocked(s)
+	}
+}
+
+// Publish delivers a change to every subscription that matches and may see
+// it. It is the storage's commit publisher.
+func (h *Hub) Publish(change repo.Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	event := Event{ID: h.lastID, Change: change, At: time.Now().UTC().Format(time.RFC3339)}
+	for s := range h.subs {
+		if !s.filter.match(change) || !s.viewer.canSee(change) {
+			continue
+		}
+		select {
+		case s.ch <- event:
+		default:
+			s.lagged = true
+			h.endLocked(s)
+		}
+	}
+}
+
+// Close ends every subscription and refuses new ones, so that streaming
+// handlers return before the server shuts down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		h.endLocked(s)
+	}
+}
+
+func (h *Hub) endLocked(s *Subscription) {
+	if s.done {
+		return
+	}
+	s.done = true
+	delete(h.subs, s)
+	close(s.ch)
+}
@@ -0,0 +1,47 @@
+package events
+
+import (
+	"snippetier/db/repo"
+	"testing"
+)
+
+// pending returns the events a subscription was sent so far, and whether it
+// is still open.
+func pending(s *Subscription) (events []Event, open bool) {
+	for {
+		select {
+		case e, ok := <-s.Events():
+			if !ok {
+				return events, false
+			}
+			events = append(events, e)
+		default:
+			return events, true
+		}
+	}
+}
+
+func TestSetOrgs(t *testing.T) {
+	org := 3
+	change := repo.Change{Event: repo.EventSnippetUpdated, SnippetID: 1, UserID: 2, OrgID: &org}
+
+	hub := NewHub()
+	all := hub.Subscribe(Viewer{UserID: 7, OrgIDs: []int{org}}, Filter{})
+	narrowed := hub.Subscribe(Viewer{UserID: 7, OrgIDs: []int{org}}, Filter{OrgID: org})
+	hub.Publish(change)
+	for name, s := range map[string]*Subscription{"all": all, "narrowed": narrowed} {
+		if events, _ := pending(s); len(events) != 1 {
+			t.Errorf("%s: member got %d events, want 1", name, len(events))
+		}
+	}
+
+	all.SetOrgs(nil)
+	narrowed.SetOrgs(nil)
+	hub.Publish(change)
+	if events, open := pending(all); len(events) != 0 || !open {
+		t.Errorf("after leaving: got %d events and open %v, want none and open", len(events), open)
+	}
+	if _, open := pending(narrowed); open || narrowed.Lagged() {
+		t.Errorf("after leaving: subscription narrowed to the organization is open %v, lagged %v; want it ended", open, narrowed.Lagged())
+	}
+}
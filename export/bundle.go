@@ -0,0 +1,76 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"snippetier/db/repo"
+	"snippetier/lang"
+)
+
+type bundleCollection struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Path        string             `json:"path"`
+	Snippets    []bundleSnippet    `json:"snippets"`
+	Children    []bundleCollection `json:"children"`
+}
+
+type bundleSnippet struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Language    string   `json:"language"`
+	Tags        []string `json:"tags"`
+	File        string   `json:"file"`
+}
+
+// Bundle renders a collection and everything nested in it as one zip
+// archive: a folder per collection holding its snippets as plain files,
+// prefixed with their position, and a manifest.json describing the tree.
+func Bundle(tree repo.CollectionTree) (File, error) {
+	var entries []zipEntry
+	root := bundleTree(tree, trigger(tree.Name), &entries)
+
+	manifest, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return File{}, err
+	}
+	entries = append(entries, zipEntry{path: "manifest.json", data: manifest})
+
+	data, err := zipFiles(entries)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: root.Path + ".zip", ContentType: "application/zip", Data: data}, nil
+}
+
+func bundleTree(tree repo.CollectionTree, dir string, entries *[]zipEntry) bundleCollection {
+	out := bundleCollection{
+		Name:        tree.Name,
+		Description: tree.Description,
+		Path:        dir,
+		Snippets:    []bundleSnippet{},
+		Children:    []bundleCollection{},
+	}
+
+	names := uniqueNames{}
+	for i, s := range tree.Snippets {
+		file := path.Join(dir, fmt.Sprintf("%02d-%s%s", i+1, names.next(trigger(s.Name)), lang.Extension(s.Language)))
+		*entries = append(*entries, zipEntry{path: file, data: []byte(s.Content)})
+		out.Snippets = append(out.Snippets, bundleSnippet{
+			ID:          s.ID,
+			Name:        s.Name,
+			Description: s.Description,
+			Language:    s.Language,
+			Tags:        s.Tags,
+			File:        file,
+		})
+	}
+
+	dirs := uniqueNames{}
+	for _, child := range tree.Children {
+		out.Children = append(out.Children, bundleTree(child, path.Join(dir, dirs.next(trigger(child.Name))), entries))
+	}
+	return out
+}
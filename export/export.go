@@ -0,0 +1,78 @@
+// Package export converts snippets into editor snippet files.
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"snippetier/db/repo"
+	"strconv"
+)
+
+// File is a rendered export ready to be sent as a download.
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+type exporter func(snippets []repo.Snippet) (File, error)
+
+var exporters = map[string]exporter{
+	"vscode":    VSCode,
+	"jetbrains": JetBrains,
+	"sublime":   Sublime,
+	"yasnippet": Yasnippet,
+}
+
+// ErrUnknownFormat is returned for export formats that are not supported.
+type ErrUnknownFormat string
+
+func (e ErrUnknownFormat) Error() string {
+	return fmt.Sprintf("unknown export format %q", string(e))
+}
+
+// Export renders snippets in the named format: vscode, jetbrains, sublime
+// or yasnippet.
+func Export(format string, snippets []repo.Snippet) (File, error) {
+	exp, ok := exporters[format]
+	if !ok {
+		return File{}, ErrUnknownFormat(format)
+	}
+	return exp(snippets)
+}
+
+// uniqueNames hands out names that have not been used yet by appending a
+// counter to repeats.
+type uniqueNames map[string]int
+
+func (u uniqueNames) next(name string) string {
+	u[name]++
+	if u[name] == 1 {
+		return name
+	}
+	return name + "-" + strconv.Itoa(u[name])
+}
+
+type zipEntry struct {
+	path string
+	data []byte
+}
+
+func zipFiles(entries []zipEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		f, err := w.Create(e.path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(e.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"regexp"
+	"snippetier/placeholder"
+	"strconv"
+	"strings"
+)
+
+// fieldNumbers assigns every field of a template a tabstop number. Numbered
+// tabstops keep their own number; named variables, which most editors do
+// not support as user input, are numbered after the highest tabstop in
+// order of appearance.
+func fieldNumbers(t *placeholder.Template) map[string]int {
+	numbers := map[string]int{}
+	next := 1
+	for _, v := range t.Variables() {
+		if v.Tabstop {
+			n, _ := strconv.Atoi(v.Name)
+			numbers[v.Name] = n
+			if n >= next {
+				next = n + 1
+			}
+		}
+	}
+	for _, v := range t.Variables() {
+		if !v.Tabstop {
+			numbers[v.Name] = next
+			next++
+		}
+	}
+	return numbers
+}
+
+// parseContent parses snippet content as a template. Content that is not a
+// valid template is exported as literal text.
+func parseContent(content string) *placeholder.Template {
+	t, err := placeholder.Parse(content)
+	if err != nil {
+		return &placeholder.Template{Nodes: []placeholder.Node{placeholder.Text{Value: content}}}
+	}
+	return t
+}
+
+// textMate renders a template in TextMate syntax, shared by VS Code and
+// Sublime Text. Choices are kept only when the editor supports them;
+// otherwise the first choice becomes the placeholder default.
+func textMate(nodes []placeholder.Node, numbers map[string]int, choices bool) string {
+	var out strings.Builder
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case placeholder.Text:
+			out.WriteString(escapeTextMate(n.Value))
+		case *placeholder.Field:
+			num := strconv.Itoa(numbers[n.Name])
+			switch {
+			case n.Transform != nil:
+				out.WriteString("${" + num + "/" + n.Transform.Source + "}")
+			case n.HasDefault:
+				out.WriteString("${" + num + ":" + textMate(n.Default, numbers, choices) + "}")
+			case len(n.Choices) > 0 && choices:
+				escaped := make([]string, len(n.Choices))
+				for i, c := range n.Choices {
+					escaped[i] = strings.NewReplacer(`\`, `\\`, ",", `\,`, "|", `\|`).Replace(c)
+				}
+				out.WriteString("${" + num + "|" + strings.Join(escaped, ",") + "|}")
+			case len(n.Choices) > 0:
+				out.WriteString("${" + num + ":" + escapeTextMate(n.Choices[0]) + "}")
+			case !n.Tabstop:
+				// Show the variable name so the user knows what to type.
+				out.WriteString("${" + num + ":" + n.Name + "}")
+			default:
+				out.WriteString("$" + num)
+			}
+		}
+	}
+	return out.String()
+}
+
+func escapeTextMate(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "$", `\$`, "}", `\}`).Replace(s)
+}
+
+// flatten renders a default value as plain text.
+func flatten(nodes []placeholder.Node) string {
+	var out strings.Builder
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case placeholder.Text:
+			out.WriteString(n.Value)
+		case *placeholder.Field:
+			if n.HasDefault {
+				out.WriteString(flatten(n.Default))
+			} else if len(n.Choices) > 0 {
+				out.WriteString(n.Choices[0])
+			}
+		}
+	}
+	return out.String()
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// trigger derives a tab trigger from a snippet name.
+func trigger(name string) string {
+	slug := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if slug == "" {
+		return "snippet"
+	}
+	return slug
+}
@@ -0,0 +1,122 @@
+package export
+
+import (
+	"encoding/xml"
+	"snippetier/db/repo"
+	"snippetier/lang"
+	"snippetier/placeholder"
+	"strconv"
+	"strings"
+)
+
+type jetbrainsTemplateSet struct {
+	XMLName   xml.Name            `xml:"templateSet"`
+	Group     string              `xml:"group,attr"`
+	Templates []jetbrainsTemplate `xml:"template"`
+}
+
+type jetbrainsTemplate struct {
+	Name        string              `xml:"name,attr"`
+	Value       string              `xml:"value,attr"`
+	Description string              `xml:"description,attr"`
+	ToReformat  bool                `xml:"toReformat,attr"`
+	Shorten     bool                `xml:"toShortenFQNames,attr"`
+	Variables   []jetbrainsVariable `xml:"variable"`
+	Context     jetbrainsContext    `xml:"context"`
+}
+
+type jetbrainsVariable struct {
+	Name         string `xml:"name,attr"`
+	Expression   string `xml:"expression,attr"`
+	DefaultValue string `xml:"defaultValue,attr"`
+	AlwaysStopAt bool   `xml:"alwaysStopAt,attr"`
+}
+
+type jetbrainsContext struct {
+	Options []jetbrainsOption `xml:"option"`
+}
+
+type jetbrainsOption struct {
+	Name  string `xml:"name,attr"`
+	Value bool   `xml:"value,attr"`
+}
+
+// JetBrains renders snippets as a live template set XML file.
+func JetBrains(snippets []repo.Snippet) (File, error) {
+	set := jetbrainsTemplateSet{Group: "snippetier"}
+	names := uniqueNames{}
+
+	for _, s := range snippets {
+		t := parseContent(s.Content)
+		set.Templates = append(set.Templates, jetbrainsTemplate{
+			Name:        names.next(trigger(s.Name)),
+			Value:       jetbrainsValue(t.Nodes),
+			Description: description(s),
+			Shorten:     true,
+			Variables:   jetbrainsVariables(t),
+			Context: jetbrainsContext{Options: []jetbrainsOption{
+				{Name: lang.ScopeFor(s.Language).JetBrains, Value: true},
+			}},
+		})
+	}
+
+	data, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: "snippetier-jetbrains.xml", ContentType: "application/xml", Data: append([]byte(xml.Header), data...)}, nil
+}
+
+// jetbrainsName maps a field onto a live template variable name. $0 becomes
+// the built-in $END$ marker.
+func jetbrainsName(f *placeholder.Field) string {
+	if !f.Tabstop {
+		return f.Name
+	}
+	if f.Name == "0" {
+		return "END"
+	}
+	return "FIELD_" + f.Name
+}
+
+// jetbrainsValue renders a template in live template syntax. Nested
+// defaults and transforms have no equivalent, so fields are emitted as
+// plain variable references and defaults move to the variable list.
+func jetbrainsValue(nodes []placeholder.Node) string {
+	var out strings.Builder
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case placeholder.Text:
+			out.WriteString(strings.ReplaceAll(n.Value, "$", "$$"))
+		case *placeholder.Field:
+			out.WriteString("$" + jetbrainsName(n) + "$")
+		}
+	}
+	return out.String()
+}
+
+func jetbrainsVariables(t *placeholder.Template) []jetbrainsVariable {
+	var variables []jetbrainsVariable
+	for _, v := range t.Variables() {
+		if v.Tabstop && v.Name == "0" {
+			continue
+		}
+		name := v.Name
+		if v.Tabstop {
+			name = "FIELD_" + v.Name
+		}
+
+		variable := jetbrainsVariable{Name: name, AlwaysStopAt: true}
+		if len(v.Choices) > 0 {
+			quoted := make([]string, len(v.Choices))
+			for i, c := range v.Choices {
+				quoted[i] = strconv.Quote(c)
+			}
+			variable.Expression = "enum(" + strings.Join(quoted, ",") + ")"
+		} else if v.Default != nil {
+			variable.DefaultValue = strconv.Quote(*v.Default)
+		}
+		variables = append(variables, variable)
+	}
+	return variables
+}
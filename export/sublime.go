@@ -0,0 +1,58 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"snippetier/db/repo"
+	"snippetier/lang"
+	"strings"
+)
+
+// Sublime renders each snippet as a .sublime-snippet file and zips them.
+func Sublime(snippets []repo.Snippet) (File, error) {
+	var entries []zipEntry
+	names := uniqueNames{}
+
+	for _, s := range snippets {
+		t := parseContent(s.Content)
+		// Sublime Text has no choice syntax, so choices fall back to their first option.
+		body := textMate(t.Nodes, fieldNumbers(t), false)
+
+		var buf bytes.Buffer
+		buf.WriteString("<snippet>\n")
+		buf.WriteString("    <content><![CDATA[\n" + cdata(strings.TrimSuffix(body, "\n")) + "\n]]></content>\n")
+		writeElement(&buf, "tabTrigger", trigger(s.Name))
+		if scope := lang.ScopeFor(s.Language).Sublime; scope != "" {
+			writeElement(&buf, "scope", scope)
+		}
+		writeElement(&buf, "description", description(s))
+		buf.WriteString("</snippet>\n")
+
+		entries = append(entries, zipEntry{path: names.next(trigger(s.Name)) + ".sublime-snippet", data: buf.Bytes()})
+	}
+
+	data, err := zipFiles(entries)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: "snippetier-sublime.zip", ContentType: "application/zip", Data: data}, nil
+}
+
+func writeElement(buf *bytes.Buffer, name, value string) {
+	buf.WriteString("    <" + name + ">")
+	_ = xml.EscapeText(buf, []byte(value))
+	buf.WriteString("</" + name + ">\n")
+}
+
+// cdata makes text safe to embed in a CDATA section by splitting any "]]>".
+func cdata(text string) string {
+	return strings.ReplaceAll(text, "]]>", "]]]]><![CDATA[>")
+}
+
+// description is the snippet description, or its name when it has none.
+func description(s repo.Snippet) string {
+	if s.Description != "" {
+		return s.Description
+	}
+	return s.Name
+}
@@ -0,0 +1,38 @@
+package export
+
+import (
+	"encoding/json"
+	"snippetier/db/repo"
+	"snippetier/lang"
+	"strings"
+)
+
+type vscodeSnippet struct {
+	Prefix      string   `json:"prefix"`
+	Body        []string `json:"body"`
+	Description string   `json:"description,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+}
+
+// VSCode renders snippets as a single .code-snippets JSON file.
+func VSCode(snippets []repo.Snippet) (File, error) {
+	out := make(map[string]vscodeSnippet, len(snippets))
+	names := uniqueNames{}
+
+	for _, s := range snippets {
+		t := parseContent(s.Content)
+		body := textMate(t.Nodes, fieldNumbers(t), true)
+		out[names.next(s.Name)] = vscodeSnippet{
+			Prefix:      trigger(s.Name),
+			Body:        strings.Split(strings.TrimSuffix(body, "\n"), "\n"),
+			Description: s.Description,
+			Scope:       lang.ScopeFor(s.Language).VSCode,
+		}
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: "snippetier.code-snippets", ContentType: "application/json", Data: data}, nil
+}
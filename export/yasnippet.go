@@ -0,0 +1,78 @@
+package export
+
+import (
+	"snippetier/db/repo"
+	"snippetier/lang"
+	"snippetier/placeholder"
+	"strconv"
+	"strings"
+)
+
+// Yasnippet renders each snippet as an Emacs yasnippet file, grouped into
+// one directory per major mode, and zips them.
+func Yasnippet(snippets []repo.Snippet) (File, error) {
+	var entries []zipEntry
+	names := uniqueNames{}
+
+	for _, s := range snippets {
+		t := parseContent(s.Content)
+		mode := lang.ScopeFor(s.Language).EmacsMode
+
+		var b strings.Builder
+		b.WriteString("# -*- mode: snippet -*-\n")
+		b.WriteString("# name: " + oneLine(s.Name) + "\n")
+		b.WriteString("# key: " + trigger(s.Name) + "\n")
+		if len(s.Tags) > 0 {
+			b.WriteString("# group: " + strings.Join(s.Tags, ".") + "\n")
+		}
+		if s.Description != "" {
+			// Not a yasnippet directive, so yasnippet treats it as a comment.
+			b.WriteString("# description: " + oneLine(s.Description) + "\n")
+		}
+		b.WriteString("# --\n")
+		b.WriteString(yasnippetBody(t.Nodes, fieldNumbers(t)))
+
+		path := names.next(mode + "/" + trigger(s.Name))
+		entries = append(entries, zipEntry{path: path, data: []byte(b.String())})
+	}
+
+	data, err := zipFiles(entries)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: "snippetier-yasnippet.zip", ContentType: "application/zip", Data: data}, nil
+}
+
+// yasnippetBody renders a template in yasnippet syntax. Choices use
+// yas-choose-value; transforms have no portable equivalent and are emitted
+// as plain mirrors of their field.
+func yasnippetBody(nodes []placeholder.Node, numbers map[string]int) string {
+	var out strings.Builder
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case placeholder.Text:
+			out.WriteString(strings.NewReplacer(`\`, `\\`, "$", `\$`, "`", "\\`").Replace(n.Value))
+		case *placeholder.Field:
+			num := strconv.Itoa(numbers[n.Name])
+			switch {
+			case n.HasDefault && n.Transform == nil:
+				out.WriteString("${" + num + ":" + yasnippetBody(n.Default, numbers) + "}")
+			case len(n.Choices) > 0:
+				quoted := make([]string, len(n.Choices))
+				for i, c := range n.Choices {
+					quoted[i] = strconv.Quote(c)
+				}
+				out.WriteString("${" + num + ":$$(yas-choose-value '(" + strings.Join(quoted, " ") + "))}")
+			case !n.Tabstop && n.Transform == nil:
+				out.WriteString("${" + num + ":" + n.Name + "}")
+			default:
+				out.WriteString("$" + num)
+			}
+		}
+	}
+	return out.String()
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,97 @@
+package feed
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Link       atomLink       `xml:"link"`
+	Author     atomAuthor     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    atomText       `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// Atom renders f as an Atom 1.0 document (RFC 4287). The feed's self link
+// doubles as its ID, and a feed without entries is stamped with the Unix
+// epoch since Atom requires an updated time.
+func Atom(f Feed) ([]byte, error) {
+	out := atomFeed{
+		ID:       f.Self,
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Updated:  atomTime(f.Updated),
+		Links: []atomLink{
+			{Rel: "alternate", Href: f.Link},
+			{Rel: "self", Type: "application/atom+xml", Href: f.Self},
+		},
+	}
+	for _, e := range f.Entries {
+		entry := atomEntry{
+			ID:        e.ID,
+			Title:     e.Title,
+			Updated:   atomTime(e.Updated),
+			Published: atomTime(e.Published),
+			Link:      atomLink{Rel: "alternate", Href: e.ID},
+			Author:    atomAuthor{Name: e.Author},
+			Content:   atomText{Type: "text", Body: e.Content},
+		}
+		if e.Summary != "" {
+			entry.Summary = &atomText{Type: "text", Body: e.Summary}
+		}
+		for _, tag := range e.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	return marshalXML(out)
+}
+
+func atomTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func marshalXML(v any) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
@@ -0,0 +1,78 @@
+// Package feed renders lists of snippets as Atom 1.0, RSS 2.0 and JSON Feed
+// 1.1 documents for feed readers.
+package feed
+
+import (
+	"fmt"
+	"time"
+)
+
+// Feed is a format-neutral feed. Updated is the time of its most recently
+// updated entry, or zero if it has none.
+type Feed struct {
+	Title       string
+	Description string
+	// Link is the page the feed is about and Self the URL of the feed
+	// itself, in the format being rendered.
+	Link    string
+	Self    string
+	Updated time.Time
+	Entries []Entry
+}
+
+// Entry is a single feed item.
+type Entry struct {
+	// ID is a permanent, unique identifier of the entry; it is also used as
+	// its link.
+	ID        string
+	Title     string
+	Summary   string
+	Content   string
+	Author    string
+	Tags      []string
+	Published time.Time
+	Updated   time.Time
+}
+
+// Document is a rendered feed.
+type Document struct {
+	ContentType string
+	Data        []byte
+}
+
+type renderer func(f Feed) ([]byte, error)
+
+var renderers = map[string]struct {
+	contentType string
+	render      renderer
+}{
+	"atom": {"application/atom+xml; charset=utf-8", Atom},
+	"rss":  {"application/rss+xml; charset=utf-8", RSS},
+	"json": {"application/feed+json; charset=utf-8", JSON},
+}
+
+// ErrUnknownFormat is returned for feed formats that are not supported.
+type ErrUnknownFormat string
+
+func (e ErrUnknownFormat) Error() string {
+	return fmt.Sprintf("unknown feed format %q", string(e))
+}
+
+// Known reports whether format is a supported feed format.
+func Known(format string) bool {
+	_, ok := renderers[format]
+	return ok
+}
+
+// Render renders f in the named format: atom, rss or json.
+func Render(format string, f Feed) (Document, error) {
+	r, ok := renderers[format]
+	if !ok {
+		return Document{}, ErrUnknownFormat(format)
+	}
+	data, err := r.render(f)
+	if err != nil {
+		return Document{}, err
+	}
+	return Document{ContentType: r.contentType, Data: data}, nil
+}
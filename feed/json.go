@@ -0,0 +1,60 @@
+package feed
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type jsonFeed struct {
+	Version     string     `json:"version"`
+	Title       string     `json:"title"`
+	HomePageURL string     `json:"home_page_url"`
+	FeedURL     string     `json:"feed_url"`
+	Description string     `json:"description,omitempty"`
+	Items       []jsonItem `json:"items"`
+}
+
+type jsonItem struct {
+	ID            string       `json:"id"`
+	URL           string       `json:"url"`
+	Title         string       `json:"title"`
+	Summary       string       `json:"summary,omitempty"`
+	ContentText   string       `json:"content_text"`
+	DatePublished string       `json:"date_published"`
+	DateModified  string       `json:"date_modified"`
+	Authors       []jsonAuthor `json:"authors,omitempty"`
+	Tags          []string     `json:"tags,omitempty"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+// JSON renders f as a JSON Feed 1.1 document.
+func JSON(f Feed) ([]byte, error) {
+	out := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Link,
+		FeedURL:     f.Self,
+		Description: f.Description,
+		Items:       []jsonItem{},
+	}
+	for _, e := range f.Entries {
+		item := jsonItem{
+			ID:            e.ID,
+			URL:           e.ID,
+			Title:         e.Title,
+			Summary:       e.Summary,
+			ContentText:   e.Content,
+			DatePublished: e.Published.UTC().Format(time.RFC3339),
+			DateModified:  e.Updated.UTC().Format(time.RFC3339),
+			Tags:          e.Tags,
+		}
+		if e.Author != "" {
+			item.Authors = []jsonAuthor{{Name: e.Author}}
+		}
+		out.Items = append(out.Items, item)
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
@@ -0,0 +1,88 @@
+package feed
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          rssLink   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	Description string   `xml:"description"`
+	Author      string   `xml:"dc:creator,omitempty"`
+	Categories  []string `xml:"category"`
+	PubDate     string   `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// RSS renders f as an RSS 2.0 document. RSS has no per-item update time, so
+// pubDate carries the last update: readers then pick up edits as changes to
+// the item.
+func RSS(f Feed) ([]byte, error) {
+	description := f.Description
+	if description == "" {
+		description = f.Title
+	}
+	out := rssDocument{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.Link,
+			Description: description,
+			Self:        rssLink{Href: f.Self, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if !f.Updated.IsZero() {
+		out.Channel.LastBuildDate = rssTime(f.Updated)
+	}
+	for _, e := range f.Entries {
+		body := e.Content
+		if e.Summary != "" {
+			body = e.Summary + "\n\n" + body
+		}
+		out.Channel.Items = append(out.Channel.Items, rssItem{
+			Title:       e.Title,
+			Link:        e.ID,
+			GUID:        rssGUID{IsPermaLink: true, Value: e.ID},
+			Description: body,
+			Author:      e.Author,
+			Categories:  e.Tags,
+			PubDate:     rssTime(e.Updated),
+		})
+	}
+	return marshalXML(out)
+}
+
+func rssTime(t time.Time) string {
+	return t.UTC().Format(time.RFC1123Z)
+}
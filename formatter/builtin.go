@@ -0,0 +1,65 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/format"
+	"strings"
+	"unicode"
+)
+
+const indentWidth = 4
+
+// FormatGo runs content through gofmt.
+func FormatGo(content string) (string, error) {
+	formatted, err := format.Source([]byte(content))
+	if err != nil {
+		return "", err
+	}
+	return string(formatted), nil
+}
+
+// FormatJSON pretty-prints JSON with two-space indentation, keeping the
+// original key order.
+func FormatJSON(content string) (string, error) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(strings.TrimSpace(content)), "", "  "); err != nil {
+		return "", err
+	}
+	out.WriteByte('\n')
+	return out.String(), nil
+}
+
+// NormalizeWhitespace converts line endings to LF, strips trailing
+// whitespace and leaves exactly one trailing newline. Indentation is kept as
+// is, since tabs are significant in Makefiles, TSV and gofmt'd code.
+func NormalizeWhitespace(content string) (string, error) {
+	return normalize(content, false), nil
+}
+
+// ExpandIndentation normalizes whitespace like NormalizeWhitespace and also
+// expands leading tabs to spaces. It is meant for languages indented with
+// spaces only, such as Python and YAML.
+func ExpandIndentation(content string) (string, error) {
+	return normalize(content, true), nil
+}
+
+func normalize(content string, expandTabs bool) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	lines := strings.Split(content, "\n")
+
+	for i, line := range lines {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if expandTabs {
+			indent := len(line) - len(strings.TrimLeft(line, " \t"))
+			line = strings.ReplaceAll(line[:indent], "\t", strings.Repeat(" ", indentWidth)) + line[indent:]
+		}
+		lines[i] = line
+	}
+
+	normalized := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if normalized == "" {
+		return ""
+	}
+	return normalized + "\n"
+}
@@ -0,0 +1,24 @@
+package formatter
+
+import "testing"
+
+func TestFormatKeepsSignificantTabs(t *testing.T) {
+	r := NewRegistry()
+	for _, tt := range []struct {
+		language, content, want string
+	}{
+		{"makefile", "build:\r\n\tgo build ./...  \n\n", "build:\n\tgo build ./...\n"},
+		{"", "name\tcount\n\tempty\t0\n", "name\tcount\n\tempty\t0\n"},
+		{"python", "def f():\n\treturn 1\t\n", "def f():\n    return 1\n"},
+		{"yml", "a:\n\tb: 1\n", "a:\n    b: 1\n"},
+	} {
+		got, err := r.Format(tt.language, tt.content)
+		if err != nil {
+			t.Errorf("Format(%q, %q): %v", tt.language, tt.content, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Format(%q, %q) = %q, want %q", tt.language, tt.content, got, tt.want)
+		}
+	}
+}
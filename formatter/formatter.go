@@ -0,0 +1,71 @@
+// Package formatter normalizes snippet content according to its language.
+package formatter
+
+import (
+	"strings"
+	"sync"
+)
+
+// Formatter rewrites content into its canonical form. It returns an error
+// when the content cannot be parsed as the formatter's language.
+type Formatter interface {
+	Format(content string) (string, error)
+}
+
+// FormatterFunc adapts an ordinary function to the Formatter interface.
+type FormatterFunc func(content string) (string, error)
+
+func (f FormatterFunc) Format(content string) (string, error) {
+	return f(content)
+}
+
+// Registry maps languages to formatters. Languages without a registered
+// formatter fall back to whitespace normalization.
+type Registry struct {
+	mu         sync.RWMutex
+	formatters map[string]Formatter
+	fallback   Formatter
+}
+
+// NewRegistry returns a registry with the built-in formatters registered.
+func NewRegistry() *Registry {
+	r := &Registry{
+		formatters: make(map[string]Formatter),
+		fallback:   FormatterFunc(NormalizeWhitespace),
+	}
+	r.Register("go", FormatterFunc(FormatGo))
+	r.Register("golang", FormatterFunc(FormatGo))
+	r.Register("json", FormatterFunc(FormatJSON))
+	r.Register("sql", FormatterFunc(FormatSQL))
+	r.Register("python", FormatterFunc(ExpandIndentation))
+	r.Register("py", FormatterFunc(ExpandIndentation))
+	r.Register("yaml", FormatterFunc(ExpandIndentation))
+	r.Register("yml", FormatterFunc(ExpandIndentation))
+	return r
+}
+
+// Register sets the formatter for a language, replacing any existing one.
+func (r *Registry) Register(language string, f Formatter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.formatters[normalizeLanguage(language)] = f
+}
+
+// Lookup returns the formatter used for language.
+func (r *Registry) Lookup(language string) Formatter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if f, ok := r.formatters[normalizeLanguage(language)]; ok {
+		return f
+	}
+	return r.fallback
+}
+
+// Format formats content with the formatter registered for language.
+func (r *Registry) Format(language, content string) (string, error) {
+	return r.Lookup(language).Format(content)
+}
+
+func normalizeLanguage(language string) string {
+	return strings.ToLower(strings.TrimSpace(language))
+}
@@ -0,0 +1,97 @@
+package formatter
+
+import (
+	"strings"
+	"unicode"
+)
+
+var sqlKeywords = map[string]bool{}
+
+func init() {
+	for _, kw := range strings.Fields(`
+		ADD ALL ALTER AND ANY AS ASC BEGIN BETWEEN BY CASE CAST CHECK COLUMN
+		COMMIT CONSTRAINT CREATE CROSS DATABASE DEFAULT DELETE DESC DISTINCT
+		DROP ELSE END EXCEPT EXISTS FALSE FOREIGN FROM FULL GROUP HAVING IF IN
+		INDEX INNER INSERT INTERSECT INTO IS JOIN KEY LEFT LIKE LIMIT NOT NULL
+		OFFSET ON OR ORDER OUTER PRIMARY REFERENCES RETURNING RIGHT ROLLBACK
+		SELECT SET TABLE THEN TRANSACTION TRIGGER TRUE UNION UNIQUE UPDATE
+		USING VALUES VIEW WHEN WHERE WITH`) {
+		sqlKeywords[kw] = true
+	}
+}
+
+// FormatSQL upper-cases SQL keywords outside of string literals, quoted
+// identifiers and comments, then normalizes whitespace.
+func FormatSQL(content string) (string, error) {
+	var out strings.Builder
+	runes := []rune(content)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			end := skipQuoted(runes, i, r)
+			out.WriteString(string(runes[i:end]))
+			i = end
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			end := i
+			for end < len(runes) && runes[end] != '\n' {
+				end++
+			}
+			out.WriteString(string(runes[i:end]))
+			i = end
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			end := i + 2
+			for end+1 < len(runes) && !(runes[end] == '*' && runes[end+1] == '/') {
+				end++
+			}
+			end += 2
+			if end > len(runes) {
+				end = len(runes)
+			}
+			out.WriteString(string(runes[i:end]))
+			i = end
+		case isIdentStart(r):
+			end := i
+			for end < len(runes) && isIdentPart(runes[end]) {
+				end++
+			}
+			word := string(runes[i:end])
+			if upper := strings.ToUpper(word); sqlKeywords[upper] {
+				word = upper
+			}
+			out.WriteString(word)
+			i = end
+		default:
+			out.WriteRune(r)
+			i++
+		}
+	}
+
+	return ExpandIndentation(out.String())
+}
+
+// skipQuoted returns the index just past the quoted section starting at
+// start. Doubled quotes inside the section are treated as escapes.
+func skipQuoted(runes []rune, start int, quote rune) int {
+	i := start + 1
+	for i < len(runes) {
+		if runes[i] == quote {
+			if i+1 < len(runes) && runes[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return i
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
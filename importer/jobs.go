@@ -0,0 +1,257 @@
+package importer
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"snippetier/db/repo"
+	"snippetier/secrets"
+	"sync"
+	"time"
+)
+
+// jobRetention is how long finished jobs stay available to the status endpoint.
+const jobRetention = time.Hour
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusCancelled Status = "cancelled"
+)
+
+type ItemStatus string
+
+const (
+	ItemCreated   ItemStatus = "created"
+	ItemDuplicate ItemStatus = "duplicate"
+	ItemFailed    ItemStatus = "failed"
+)
+
+// ItemResult reports what happened to a single item of an import.
+type ItemResult struct {
+	Source    string     `json:"source"`
+	Name      string     `json:"name"`
+	Status    ItemStatus `json:"status"`
+	SnippetID int        `json:"snippetId,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Job is the state of an import as reported by the status endpoint.
+type Job struct {
+	ID         string       `json:"id"`
+	UserID     int          `json:"userId"`
+	Status     Status       `json:"status"`
+	Total      int          `json:"total"`
+	Created    int          `json:"created"`
+	Duplicates int          `json:"duplicates"`
+	Failed     int          `json:"failed"`
+	Items      []ItemResult `json:"items"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	FinishedAt *time.Time   `json:"finishedAt,omitempty"`
+}
+
+// Manager runs imports in the background and keeps their status around
+// for a while after they finish.
+type Manager struct {
+	snippets *repo.SnippetsRepo
+	scanner  *secrets.Scanner
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewManager(snippets *repo.SnippetsRepo, scanner *secrets.Scanner) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		snippets: snippets,
+		scanner:  scanner,
+		jobs:     make(map[string]*Job),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start queues an import of items on behalf of userID and returns the new job.
+func (m *Manager) Start(userID int, items []Item) Job {
+	job := &Job{
+		ID:        newJobID(),
+		UserID:    userID,
+		Status:    StatusQueued,
+		Total:     len(items),
+		Items:     []ItemResult{},
+		CreatedAt: time.Now().UTC(),
+	}
+
+	m.mu.Lock()
+	m.pruneLocked()
+	m.jobs[job.ID] = job
+	snapshot := job.snapshot()
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.run(job, items)
+	}()
+
+	return snapshot
+}
+
+// Get returns a copy of a job's current state.
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.snapshot(), true
+}
+
+// Stop cancels running imports and waits for them to wind down. Items that
+// were not reached are reported as failed.
+func (m *Manager) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *Manager) run(job *Job, items []Item) {
+	m.update(job, func(j *Job) { j.Status = StatusRunning })
+
+	// Content hashes of the caller's existing snippets, so re-importing the
+	// same file does not create copies.
+	existing := map[string]int{}
+	current, err := m.snippets.SearchSnippets(m.ctx, job.UserID, repo.SnippetFilter{UserID: job.UserID})
+	if err != nil {
+		m.finish(job, items, "failed to read existing snippets")
+		return
+	}
+	for _, s := range current {
+		existing[contentHash(s.Content)] = s.ID
+	}
+
+	for i, item := range items {
+		if m.ctx.Err() != nil {
+			m.finish(job, items[i:], "import cancelled")
+			return
+		}
+		result := m.importItem(job.UserID, item, existing)
+		m.update(job, func(j *Job) { j.record(result) })
+	}
+
+	m.finish(job, nil, "")
+}
+
+func (m *Manager) importItem(userID int, item Item, existing map[string]int) ItemResult {
+	result := ItemResult{Source: item.Source, Name: item.Name}
+
+	hash := contentHash(item.Content)
+	if id, ok := existing[hash]; ok {
+		result.Status = ItemDuplicate
+		result.SnippetID = id
+		return result
+	}
+
+	content, findings, ok := m.scanner.Check(item.Content)
+	if !ok {
+		result.Status = ItemFailed
+		result.Error = "content appears to contain secrets (" + findings[0].Rule + ")"
+		return result
+	}
+	if len(content) > repo.MaxContentBytes {
+		result.Status = ItemFailed
+		result.Error = fmt.Sprintf("content is larger than %d bytes", repo.MaxContentBytes)
+		return result
+	}
+
+	name := item.Name
+	if name == "" {
+		name = "Untitled"
+	}
+
+	saved, err := m.snippets.SaveSnippet(m.ctx, userID, repo.Snippet{
+		Name:        name,
+		Description: item.Description,
+		Content:     content,
+		Language:    item.Language,
+	})
+	if err != nil {
+		result.Status = ItemFailed
+		result.Error = "failed to save snippet"
+		return result
+	}
+
+	existing[hash] = saved.ID
+	result.Status = ItemCreated
+	result.SnippetID = saved.ID
+	return result
+}
+
+// finish marks the job as done, reporting skipped items as failed with reason.
+func (m *Manager) finish(job *Job, skipped []Item, reason string) {
+	m.update(job, func(j *Job) {
+		for _, item := range skipped {
+			j.record(ItemResult{Source: item.Source, Name: item.Name, Status: ItemFailed, Error: reason})
+		}
+		now := time.Now().UTC()
+		j.FinishedAt = &now
+		j.Status = StatusCompleted
+		if m.ctx.Err() != nil && len(skipped) > 0 {
+			j.Status = StatusCancelled
+		}
+	})
+}
+
+func (m *Manager) update(job *Job, fn func(j *Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(job)
+}
+
+// pruneLocked forgets jobs that finished longer ago than jobRetention.
+func (m *Manager) pruneLocked() {
+	cutoff := time.Now().Add(-jobRetention)
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (j *Job) record(result ItemResult) {
+	j.Items = append(j.Items, result)
+	switch result.Status {
+	case ItemCreated:
+		j.Created++
+	case ItemDuplicate:
+		j.Duplicates++
+	case ItemFailed:
+		j.Failed++
+	}
+}
+
+func (j *Job) snapshot() Job {
+	c := *j
+	c.Items = append([]ItemResult(nil), j.Items...)
+	return c
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,369 @@
+// Package importer turns editor snippet files and file archives into
+// snippets, running each import as a background job.
+package importer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"snippetier/db/repo"
+	"snippetier/lang"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxFileSize caps the size of a single file taken from an archive.
+	maxFileSize = repo.MaxContentBytes
+	// maxArchiveSize caps the uncompressed size of all the files read from
+	// an archive.
+	maxArchiveSize = 32 << 20
+	// maxItems caps the number of snippets a single upload can produce.
+	maxItems = 1000
+)
+
+var errTooManyItems = fmt.Errorf("upload contains more than %d snippets", maxItems)
+
+// Item is a snippet read from an upload, not yet saved.
+type Item struct {
+	Source      string
+	Name        string
+	Description string
+	Content     string
+	Language    string
+}
+
+// Format names an upload format.
+type Format string
+
+const (
+	FormatVSCode    Format = "vscode"
+	FormatJetBrains Format = "jetbrains"
+	FormatZip       Format = "zip"
+	FormatTar       Format = "tar"
+)
+
+// DetectFormat guesses the format of an upload from its file name.
+func DetectFormat(filename string) (Format, error) {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".code-snippets"), strings.HasSuffix(name, ".json"):
+		return FormatVSCode, nil
+	case strings.HasSuffix(name, ".xml"):
+		return FormatJetBrains, nil
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip, nil
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTar, nil
+	}
+	return "", fmt.Errorf("cannot tell the format of %q", filename)
+}
+
+// Parse reads the items of an upload in the given format.
+func Parse(format Format, data []byte) ([]Item, error) {
+	switch format {
+	case FormatVSCode:
+		return parseVSCode(data)
+	case FormatJetBrains:
+		return parseJetBrains(data)
+	case FormatZip:
+		return parseZip(data)
+	case FormatTar:
+		return parseTar(data)
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+type vscodeSnippet struct {
+	Prefix      json.RawMessage `json:"prefix"`
+	Body        json.RawMessage `json:"body"`
+	Description string          `json:"description"`
+	Scope       string          `json:"scope"`
+}
+
+// parseVSCode reads a .code-snippets file. VS Code bodies already use the
+// placeholder syntax snippets are stored in, so they are kept verbatim.
+func parseVSCode(data []byte) ([]Item, error) {
+	var file map[string]vscodeSnippet
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid VS Code snippets file: %v", err)
+	}
+
+	names := make([]string, 0, len(file))
+	for name := range file {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := make([]Item, 0, len(names))
+	for _, name := range names {
+		s := file[name]
+		body, err := stringOrLines(s.Body)
+		if err != nil {
+			return nil, fmt.Errorf("snippet %q: invalid body", name)
+		}
+		language := ""
+		if s.Scope != "" {
+			language = lang.FromVSCode(strings.TrimSpace(strings.Split(s.Scope, ",")[0]))
+		}
+		items = append(items, Item{
+			Source:      name,
+			Name:        name,
+			Description: s.Description,
+			Content:     body,
+			Language:    language,
+		})
+	}
+	return limit(items)
+}
+
+// stringOrLines decodes a VS Code body, which is either a string or an array
+// of lines.
+func stringOrLines(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var lines []string
+	if err := json.Unmarshal(raw, &lines); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+type jetbrainsTemplateSet struct {
+	Templates []jetbrainsTemplate `xml:"template"`
+}
+
+type jetbrainsTemplate struct {
+	Name        string              `xml:"name,attr"`
+	Value       string              `xml:"value,attr"`
+	Description string              `xml:"description,attr"`
+	Variables   []jetbrainsVariable `xml:"variable"`
+	Options     []jetbrainsOption   `xml:"context>option"`
+}
+
+type jetbrainsVariable struct {
+	Name         string `xml:"name,attr"`
+	Expression   string `xml:"expression,attr"`
+	DefaultValue string `xml:"defaultValue,attr"`
+}
+
+type jetbrainsOption struct {
+	Name  string `xml:"name,attr"`
+	Value bool   `xml:"value,attr"`
+}
+
+// parseJetBrains reads a live template set XML file.
+func parseJetBrains(data []byte) ([]Item, error) {
+	var set jetbrainsTemplateSet
+	if err := xml.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JetBrains template file: %v", err)
+	}
+
+	items := make([]Item, 0, len(set.Templates))
+	for _, t := range set.Templates {
+		language := ""
+		for _, o := range t.Options {
+			if o.Value {
+				language = lang.FromJetBrains(o.Name)
+				break
+			}
+		}
+		items = append(items, Item{
+			Source:      t.Name,
+			Name:        t.Name,
+			Description: t.Description,
+			Content:     fromJetBrains(t.Value, t.Variables),
+			Language:    language,
+		})
+	}
+	return limit(items)
+}
+
+var jetbrainsVar = regexp.MustCompile(`\$\$|\$([A-Za-z_][A-Za-z0-9_]*)\$`)
+
+// enumChoices matches the enum("a","b") variable expression.
+var enumChoices = regexp.MustCompile(`^enum\((.*)\)$`)
+
+// fromJetBrains converts live template syntax to placeholder syntax: $$ is
+// a literal dollar, $END$ the final cursor position and $NAME$ a variable
+// whose default and choices come from the variable list.
+func fromJetBrains(value string, variables []jetbrainsVariable) string {
+	byName := make(map[string]jetbrainsVariable, len(variables))
+	for _, v := range variables {
+		byName[v.Name] = v
+	}
+
+	seen := map[string]bool{}
+	var out strings.Builder
+	last := 0
+	for _, m := range jetbrainsVar.FindAllStringSubmatchIndex(value, -1) {
+		out.WriteString(escapeDollar(value[last:m[0]]))
+		last = m[1]
+
+		if m[2] < 0 {
+			out.WriteString(`\$`)
+			continue
+		}
+		name := value[m[2]:m[3]]
+		if name == "END" {
+			out.WriteString("$0")
+			continue
+		}
+		if seen[name] {
+			// Later occurrences mirror the first one.
+			out.WriteString("${" + name + "}")
+			continue
+		}
+		seen[name] = true
+		out.WriteString(jetbrainsField(name, byName[name]))
+	}
+	out.WriteString(escapeDollar(value[last:]))
+	return out.String()
+}
+
+func jetbrainsField(name string, v jetbrainsVariable) string {
+	if m := enumChoices.FindStringSubmatch(strings.TrimSpace(v.Expression)); m != nil {
+		var choices []string
+		for _, c := range strings.Split(m[1], ",") {
+			c = strings.Trim(strings.TrimSpace(c), `"`)
+			choices = append(choices, strings.NewReplacer(`\`, `\\`, ",", `\,`, "|", `\|`).Replace(c))
+		}
+		return "${" + name + "|" + strings.Join(choices, ",") + "|}"
+	}
+	if def := strings.Trim(v.DefaultValue, `"`); def != "" {
+		return "${" + name + ":" + strings.NewReplacer(`\`, `\\`, "$", `\$`, "}", `\}`).Replace(def) + "}"
+	}
+	return "${" + name + "}"
+}
+
+func escapeDollar(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "$", `\$`).Replace(s)
+}
+
+// parseZip reads every text file of a zip archive as a snippet.
+func parseZip(data []byte) ([]Item, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %v", err)
+	}
+
+	var a archiveItems
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || skipPath(f.Name) || f.UncompressedSize64 > maxFileSize {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = a.read(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a.items, nil
+}
+
+// parseTar reads every text file of a tar archive, gzipped or not, as a
+// snippet.
+func parseTar(data []byte) ([]Item, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip stream: %v", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	tr := tar.NewReader(reader)
+	var a archiveItems
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid tar archive: %v", err)
+		}
+		if h.Typeflag != tar.TypeReg || skipPath(h.Name) || h.Size > maxFileSize {
+			continue
+		}
+		if err := a.read(h.Name, tr); err != nil {
+			return nil, err
+		}
+	}
+	return a.items, nil
+}
+
+// archiveItems collects the text files of an archive. It fails as soon as
+// the archive holds too many snippets or too much content, rather than once
+// everything was decompressed: a small upload of repetitive text can expand
+// to gigabytes.
+type archiveItems struct {
+	items []Item
+	size  int
+}
+
+// read reads the archive file name from r and keeps it if it is text.
+func (a *archiveItems) read(name string, r io.Reader) error {
+	content, err := io.ReadAll(io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		return err
+	}
+	a.size += len(content)
+	if a.size > maxArchiveSize {
+		return fmt.Errorf("archive holds more than %d MiB of files", maxArchiveSize>>20)
+	}
+	if item, ok := fileItem(name, content); ok {
+		if len(a.items) == maxItems {
+			return errTooManyItems
+		}
+		a.items = append(a.items, item)
+	}
+	return nil
+}
+
+// fileItem turns an archive file into an item, skipping binary files.
+// Archive files are plain source, not templates, so content is kept verbatim.
+func fileItem(name string, content []byte) (Item, bool) {
+	if len(content) > maxFileSize || !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0 {
+		return Item{}, false
+	}
+	return Item{
+		Source:   name,
+		Name:     path.Base(name),
+		Content:  string(content),
+		Language: lang.FromFilename(name),
+	}, true
+}
+
+// skipPath leaves out hidden files and directories such as .git.
+func skipPath(name string) bool {
+	for _, part := range strings.Split(path.Clean(name), "/") {
+		if strings.HasPrefix(part, ".") && part != "." {
+			return true
+		}
+	}
+	return false
+}
+
+func limit(items []Item) ([]Item, error) {
+	if len(items) > maxItems {
+		return nil, fmt.Errorf("upload contains %d snippets, the limit is %d", len(items), maxItems)
+	}
+	return items, nil
+}
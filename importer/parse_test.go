@@ -0,0 +1,92 @@
+package importer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// file is a file to put in a test archive.
+type file struct {
+	name, content string
+}
+
+func zipOf(t *testing.T, files []file) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tgzOf(t *testing.T, files []file) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	for _, f := range files {
+		if err := w.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseArchiveLimits(t *testing.T) {
+	var many, large []file
+	for i := 0; i <= maxItems; i++ {
+		many = append(many, file{fmt.Sprintf("s%d.sh", i), "echo hello"})
+	}
+	big := strings.Repeat("a", maxFileSize)
+	for i := 0; i <= maxArchiveSize/maxFileSize; i++ {
+		large = append(large, file{fmt.Sprintf("s%d.txt", i), big})
+	}
+	ok := []file{{"hello.sh", "echo hello"}, {".git/config", "[core]"}, {"bin", "\x00\x01"}}
+
+	for _, tt := range []struct {
+		name    string
+		files   []file
+		wantErr bool
+	}{
+		{"small archive", ok, false},
+		{"too many files", many, true},
+		{"too much content", large, true},
+	} {
+		for format, data := range map[Format][]byte{FormatZip: zipOf(t, tt.files), FormatTar: tgzOf(t, tt.files)} {
+			items, err := Parse(format, data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("%s %s: parsed %d items, want an error", tt.name, format, len(items))
+				}
+				continue
+			}
+			if err != nil || len(items) != 1 || items[0].Content != "echo hello" {
+				t.Errorf("%s %s = %+v, %v; want the one text file", tt.name, format, items, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to JSON values.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidPatch is wrapped by every error caused by a malformed patch or
+// a patch that does not apply to the document.
+var ErrInvalidPatch = errors.New("invalid patch")
+
+func invalidf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPatch, fmt.Sprintf(format, args...))
+}
+
+// Merge applies a JSON Merge Patch to doc and returns the patched document.
+// Objects in the patch are merged recursively, null removes a member and any
+// other value replaces the target.
+func Merge(doc, patch []byte) ([]byte, error) {
+	var target, p any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, invalidf("%v", err)
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergeValue(targetObj[key], value)
+	}
+	return targetObj
+}
@@ -0,0 +1,268 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Operation is a single JSON Patch operation. Value is nil when the
+// operation has no value member, and the JSON literal null when the value
+// is null.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply applies a JSON Patch to doc and returns the patched document. The
+// operations are applied in order and the patch fails as a whole if any of
+// them fails.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var ops []Operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, invalidf("%v", err)
+	}
+	var root any
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		var err error
+		root, err = apply(root, op)
+		if err != nil {
+			return nil, invalidf("operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func apply(root any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("missing value")
+		}
+		var value any
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return add(root, path, value)
+		case "replace":
+			if root, err = remove(root, path); err != nil {
+				return nil, err
+			}
+			return add(root, path, value)
+		default:
+			current, err := get(root, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, fmt.Errorf("test failed")
+			}
+			return root, nil
+		}
+	case "remove":
+		return remove(root, path)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(root, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("cannot move a value into itself")
+			}
+			if root, err = remove(root, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+		return add(root, path, value)
+	default:
+		return nil, fmt.Errorf("unknown op %q", op.Op)
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func get(root any, path []string) (any, error) {
+	current := root
+	for _, token := range path {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			current = value
+		case []any:
+			i, err := index(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("cannot traverse into %q", token)
+		}
+	}
+	return current, nil
+}
+
+// add sets the value at path, inserting into arrays, and returns the new root.
+func add(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+		return root, nil
+	case []any:
+		i := len(node)
+		if last != "-" {
+			if i, err = index(last, len(node)); err != nil {
+				return nil, err
+			}
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return replaceParent(root, path[:len(path)-1], node)
+	default:
+		return nil, fmt.Errorf("cannot add to %q", last)
+	}
+}
+
+// remove deletes the value at path and returns the new root.
+func remove(root any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		if _, ok := node[last]; !ok {
+			return nil, fmt.Errorf("member %q does not exist", last)
+		}
+		delete(node, last)
+		return root, nil
+	case []any:
+		i, err := index(last, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node[:i:i], node[i+1:]...)
+		return replaceParent(root, path[:len(path)-1], node)
+	default:
+		return nil, fmt.Errorf("cannot remove from %q", last)
+	}
+}
+
+// replaceParent stores a resized array back at path, since growing or
+// shrinking a slice does not update the container that holds it.
+func replaceParent(root any, path []string, array []any) (any, error) {
+	if len(path) == 0 {
+		return array, nil
+	}
+	container, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := container.(type) {
+	case map[string]any:
+		node[last] = array
+	case []any:
+		i, err := index(last, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = array
+	}
+	return root, nil
+}
+
+// index parses an array index token no greater than max.
+func index(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max {
+		return 0, fmt.Errorf("array index %q out of range", token)
+	}
+	return i, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(v))
+		for key, item := range v {
+			copied[key] = deepCopy(item)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(v))
+		for i, item := range v {
+			copied[i] = deepCopy(item)
+		}
+		return copied
+	default:
+		return v
+	}
+}
@@ -0,0 +1,36 @@
+package jsonpatch
+
+import "testing"
+
+func TestApplyNullValue(t *testing.T) {
+	doc := `{"name":"deploy","expiresAt":"2030-01-01T00:00:00Z","tags":["ops"]}`
+	for _, tt := range []struct {
+		patch, want string
+	}{
+		{`[{"op":"replace","path":"/expiresAt","value":null}]`, `{"expiresAt":null,"name":"deploy","tags":["ops"]}`},
+		{`[{"op":"add","path":"/description","value":null}]`, `{"description":null,"expiresAt":"2030-01-01T00:00:00Z","name":"deploy","tags":["ops"]}`},
+		{`[{"op":"add","path":"/tags/-","value":null}]`, `{"expiresAt":"2030-01-01T00:00:00Z","name":"deploy","tags":["ops",null]}`},
+		{`[{"op":"replace","path":"/expiresAt","value":null},{"op":"test","path":"/expiresAt","value":null}]`, `{"expiresAt":null,"name":"deploy","tags":["ops"]}`},
+	} {
+		got, err := Apply([]byte(doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("Apply(%s): %v", tt.patch, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Apply(%s) = %s, want %s", tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestApplyMissingValue(t *testing.T) {
+	for _, patch := range []string{
+		`[{"op":"add","path":"/description"}]`,
+		`[{"op":"replace","path":"/name"}]`,
+		`[{"op":"test","path":"/name"}]`,
+	} {
+		if _, err := Apply([]byte(`{"name":"deploy"}`), []byte(patch)); err == nil {
+			t.Errorf("Apply(%s) succeeded, want a missing value error", patch)
+		}
+	}
+}
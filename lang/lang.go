@@ -0,0 +1,143 @@
+// Package lang maps snippet languages onto the identifiers editors and file
+// names use for them.
+package lang
+
+import (
+	"path"
+	"strings"
+)
+
+// Scope holds the identifiers each editor uses for a language.
+type Scope struct {
+	VSCode    string
+	Sublime   string
+	JetBrains string
+	EmacsMode string
+}
+
+var fallbackScope = Scope{VSCode: "", Sublime: "", JetBrains: "OTHER", EmacsMode: "text-mode"}
+
+var scopes = map[string]Scope{
+	"go":         {"go", "source.go", "GO", "go-mode"},
+	"javascript": {"javascript", "source.js", "JAVA_SCRIPT", "js-mode"},
+	"typescript": {"typescript", "source.ts", "TypeScript", "typescript-mode"},
+	"python":     {"python", "source.python", "Python", "python-mode"},
+	"java":       {"java", "source.java", "JAVA_CODE", "java-mode"},
+	"kotlin":     {"kotlin", "source.kotlin", "KOTLIN", "kotlin-mode"},
+	"ruby":       {"ruby", "source.ruby", "RUBY", "ruby-mode"},
+	"rust":       {"rust", "source.rust", "RUST_FILE", "rust-mode"},
+	"c":          {"c", "source.c", "C", "c-mode"},
+	"cpp":        {"cpp", "source.c++", "OC_SOURCE", "c++-mode"},
+	"csharp":     {"csharp", "source.cs", "CSHARP", "csharp-mode"},
+	"php":        {"php", "source.php", "PHP", "php-mode"},
+	"shell":      {"shellscript", "source.shell", "SHELL_SCRIPT", "sh-mode"},
+	"sql":        {"sql", "source.sql", "SQL", "sql-mode"},
+	"json":       {"json", "source.json", "JSON", "json-mode"},
+	"yaml":       {"yaml", "source.yaml", "YAML", "yaml-mode"},
+	"html":       {"html", "text.html", "HTML", "html-mode"},
+	"css":        {"css", "source.css", "CSS", "css-mode"},
+	"markdown":   {"markdown", "text.html.markdown", "MARKDOWN", "markdown-mode"},
+}
+
+var aliases = map[string]string{
+	"golang": "go",
+	"js":     "javascript",
+	"ts":     "typescript",
+	"py":     "python",
+	"rb":     "ruby",
+	"rs":     "rust",
+	"c++":    "cpp",
+	"cs":     "csharp",
+	"sh":     "shell",
+	"bash":   "shell",
+	"zsh":    "shell",
+	"yml":    "yaml",
+	"md":     "markdown",
+}
+
+var extensions = map[string]string{
+	".go":   "go",
+	".js":   "javascript",
+	".mjs":  "javascript",
+	".ts":   "typescript",
+	".py":   "python",
+	".java": "java",
+	".kt":   "kotlin",
+	".rb":   "ruby",
+	".rs":   "rust",
+	".c":    "c",
+	".h":    "c",
+	".cc":   "cpp",
+	".cpp":  "cpp",
+	".hpp":  "cpp",
+	".cs":   "csharp",
+	".php":  "php",
+	".sh":   "shell",
+	".bash": "shell",
+	".zsh":  "shell",
+	".sql":  "sql",
+	".json": "json",
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".html": "html",
+	".css":  "css",
+	".md":   "markdown",
+}
+
+// Canonical resolves aliases such as "golang" to the language name used in
+// the scope table.
+func Canonical(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if alias, ok := aliases[language]; ok {
+		return alias
+	}
+	return language
+}
+
+// ScopeFor returns the editor identifiers for a language.
+func ScopeFor(language string) Scope {
+	if s, ok := scopes[Canonical(language)]; ok {
+		return s
+	}
+	return fallbackScope
+}
+
+// FromVSCode returns the language for a VS Code language identifier.
+func FromVSCode(id string) string {
+	return fromScope(id, func(s Scope) string { return s.VSCode })
+}
+
+// FromJetBrains returns the language for a JetBrains live template context.
+func FromJetBrains(context string) string {
+	return fromScope(context, func(s Scope) string { return s.JetBrains })
+}
+
+// FromFilename guesses the language of a file from its extension.
+func FromFilename(name string) string {
+	return extensions[strings.ToLower(path.Ext(name))]
+}
+
+// Extension returns a file extension for a language, including the dot, or
+// ".txt" if it has none. Of several extensions the shortest is used.
+func Extension(language string) string {
+	language = Canonical(language)
+	best := ""
+	for ext, l := range extensions {
+		if l == language && (best == "" || len(ext) < len(best) || len(ext) == len(best) && ext < best) {
+			best = ext
+		}
+	}
+	if best == "" {
+		return ".txt"
+	}
+	return best
+}
+
+func fromScope(id string, field func(Scope) string) string {
+	for language, s := range scopes {
+		if field(s) != "" && strings.EqualFold(field(s), id) {
+			return language
+		}
+	}
+	return ""
+}
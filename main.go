@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"snippetier/configs"
 	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/events"
+	"snippetier/formatter"
+	"snippetier/importer"
+	"snippetier/related"
 	"snippetier/routes"
+	"snippetier/secrets"
 	renderer "snippetier/templates"
+	"snippetier/webhook"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -20,26 +33,84 @@ func main() {
 		log.Fatal("Error while reading config: ", err)
 	}
 
-	storage, err := db.GetConnection()
-	defer storage.CloseConnection()
+	storage, err := db.GetConnection(config.QueryTimeout)
 	if err != nil {
 		log.Fatal("Failed to connect to db", err)
 	}
+	defer storage.CloseConnection()
+
+	secretScanMode, err := secrets.ParseMode(config.SecretScanMode)
+	if err != nil {
+		log.Fatal("Error while reading config: ", err)
+	}
+	scanner, err := secrets.NewScanner(secretScanMode, config.SecretScanRules)
+	if err != nil {
+		log.Fatal("Error while reading config: ", err)
+	}
+
+	janitor := db.NewJanitor(storage.SnippetsRepo, config.JanitorInterval)
+	janitor.Start()
+	defer janitor.Stop()
+
+	dispatcher := webhook.NewDispatcher(storage.WebhooksRepo, config.WebhookInterval)
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	indexer := related.NewIndexer(storage.SnippetsRepo)
+	indexer.Start()
+	defer indexer.Stop()
+
+	hub := events.NewHub()
+	storage.OnCommit(func(change repo.Change) {
+		hub.Publish(change)
+		indexer.Publish(change)
+	})
+
+	imports := importer.NewManager(storage.SnippetsRepo, scanner)
+	defer imports.Stop()
+
+	live := routes.NewLiveManager(storage, scanner, config.CheckpointInterval)
 
 	t := &renderer.Template{
 		Templates: template.Must(template.ParseGlob("templates/*.html")),
 	}
 	e := echo.New()
 	e.Renderer = t
+	e.HTTPErrorHandler = routes.HTTPErrorHandler
 	e.Use(middleware.Logger())
 	e.Use(middleware.RequestID())
 	e.Use(middleware.Recover())
-	routes.SetupRoutes(e, storage, config)
+	routes.SetupRoutes(e, storage, config, &routes.Services{
+		Scanner:    scanner,
+		Formatters: formatter.NewRegistry(),
+		Imports:    imports,
+		Events:     hub,
+		Live:       live,
+		Related:    indexer,
+	})
 	e.GET("/", rootHandler)
 
-	err = e.Start(":1323")
-	if err != nil {
-		log.Fatal(err)
+	go func() {
+		err := e.Start(":1323")
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for an interrupt, then let in-flight requests finish before the
+	// deferred background workers and db shutdown run.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+
+	// End the event streams first: they never finish on their own.
+	hub.Close()
+	// Save live editing sessions and disconnect their editors.
+	live.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		log.Println("Error shutting down server:", err)
 	}
 }
 
@@ -0,0 +1,146 @@
+// Package markdown renders the subset of Markdown used in comments to HTML.
+//
+// The renderer is safe by construction: all input text is HTML-escaped
+// before any markup is added, raw HTML is never passed through, and links
+// are only emitted for http, https and mailto URLs.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	headingPattern     = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	bulletPattern      = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	orderedPattern     = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	blockquotePattern  = regexp.MustCompile(`^>\s?(.*)$`)
+	fencePattern       = regexp.MustCompile("^(```|~~~)\\s*([\\w+-]*)\\s*$")
+	inlineCodePattern  = regexp.MustCompile("`([^`]+)`")
+	linkPattern        = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	strongPattern      = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	emphasisPattern    = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	placeholderPattern = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+// Render converts Markdown source to sanitized HTML.
+func Render(src string) string {
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	var out strings.Builder
+	var paragraph []string
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + inline(strings.Join(paragraph, "\n")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+		case fencePattern.MatchString(line):
+			flush()
+			m := fencePattern.FindStringSubmatch(line)
+			var code []string
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != m[1]; i++ {
+				code = append(code, lines[i])
+			}
+			class := ""
+			if m[2] != "" {
+				class = ` class="language-` + html.EscapeString(m[2]) + `"`
+			}
+			out.WriteString("<pre><code" + class + ">" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+		case headingPattern.MatchString(line):
+			flush()
+			m := headingPattern.FindStringSubmatch(line)
+			level := string(rune('0' + len(m[1])))
+			out.WriteString("<h" + level + ">" + inline(m[2]) + "</h" + level + ">\n")
+		case blockquotePattern.MatchString(line):
+			flush()
+			var quoted []string
+			for ; i < len(lines) && blockquotePattern.MatchString(lines[i]); i++ {
+				quoted = append(quoted, blockquotePattern.FindStringSubmatch(lines[i])[1])
+			}
+			i--
+			out.WriteString("<blockquote>\n" + Render(strings.Join(quoted, "\n")) + "</blockquote>\n")
+		case bulletPattern.MatchString(line), orderedPattern.MatchString(line):
+			flush()
+			pattern, tag := bulletPattern, "ul"
+			if !bulletPattern.MatchString(line) {
+				pattern, tag = orderedPattern, "ol"
+			}
+			out.WriteString("<" + tag + ">\n")
+			for ; i < len(lines) && pattern.MatchString(lines[i]); i++ {
+				out.WriteString("<li>" + inline(pattern.FindStringSubmatch(lines[i])[1]) + "</li>\n")
+			}
+			i--
+			out.WriteString("</" + tag + ">\n")
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	flush()
+	return out.String()
+}
+
+// inline renders code spans, links and emphasis within a block of text.
+// Code spans and links are swapped out for placeholders first so that their
+// contents are not formatted.
+func inline(text string) string {
+	var saved []string
+	save := func(s string) string {
+		saved = append(saved, s)
+		return "\x00" + strconv.Itoa(len(saved)-1) + "\x00"
+	}
+	// Strip NUL bytes so that input cannot forge placeholders.
+	text = strings.ReplaceAll(text, "\x00", "")
+
+	text = inlineCodePattern.ReplaceAllStringFunc(text, func(m string) string {
+		return save("<code>" + html.EscapeString(inlineCodePattern.FindStringSubmatch(m)[1]) + "</code>")
+	})
+	text = linkPattern.ReplaceAllStringFunc(text, func(m string) string {
+		parts := linkPattern.FindStringSubmatch(m)
+		label := formatEmphasis(html.EscapeString(parts[1]))
+		if !safeURL(parts[2]) {
+			return save(label)
+		}
+		return save(`<a href="` + html.EscapeString(parts[2]) + `" rel="nofollow noopener">` + label + `</a>`)
+	})
+
+	text = formatEmphasis(html.EscapeString(text))
+	text = strings.ReplaceAll(text, "\n", "<br>\n")
+
+	// A link label can hold code spans saved before it, so placeholders are
+	// expanded until none remain. Saved strings only refer to earlier ones.
+	var restore func(string) string
+	restore = func(s string) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+			i, _ := strconv.Atoi(strings.Trim(m, "\x00"))
+			return restore(saved[i])
+		})
+	}
+	return restore(text)
+}
+
+func formatEmphasis(text string) string {
+	text = strongPattern.ReplaceAllString(text, "<strong>$1$2</strong>")
+	return emphasisPattern.ReplaceAllString(text, "<em>$1$2</em>")
+}
+
+func safeURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
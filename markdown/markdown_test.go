@@ -0,0 +1,28 @@
+package markdown
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, want string
+	}{
+		{"paragraph", "hello\nworld", "<p>hello<br>\nworld</p>\n"},
+		{"heading", "## Title *here*", "<h2>Title <em>here</em></h2>\n"},
+		{"emphasis", "**bold** and _it_", "<p><strong>bold</strong> and <em>it</em></p>\n"},
+		{"code span", "run `a *b* <c>`", "<p>run <code>a *b* &lt;c&gt;</code></p>\n"},
+		{"fence", "```go\nx := <-ch\n```", "<pre><code class=\"language-go\">x := &lt;-ch</code></pre>\n"},
+		{"bullets", "- one\n- two", "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n"},
+		{"ordered", "1. one\n2) two", "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n"},
+		{"blockquote", "> quoted\n> text", "<blockquote>\n<p>quoted<br>\ntext</p>\n</blockquote>\n"},
+		{"raw html", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"link", "[docs](https://example.com/a?b=1&c=2)", "<p><a href=\"https://example.com/a?b=1&amp;c=2\" rel=\"nofollow noopener\">docs</a></p>\n"},
+		{"unsafe link", "[click](javascript:alert(1))", "<p>click)</p>\n"},
+		{"code in link label", "[`x`](https://a)", "<p><a href=\"https://a\" rel=\"nofollow noopener\"><code>x</code></a></p>\n"},
+		{"code in link url", "[x](https://a`b`)", "<p>x</p>\n"},
+		{"forged placeholder", "a\x000\x00b `c`", "<p>a0b <code>c</code></p>\n"},
+	} {
+		if got := Render(tt.src); got != tt.want {
+			t.Errorf("%s: Render(%q) = %q, want %q", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
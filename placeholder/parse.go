@@ -0,0 +1,410 @@
+// Package placeholder parses and expands snippet templates written in the
+// TextMate/VS Code snippet syntax: tabstops ($1, ${1}), placeholders with
+// defaults (${1:default}, ${name:default}), choices (${1|one,two|}),
+// variables ($name, ${name}) and variable transforms (${name/regex/format/flags}).
+package placeholder
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Node is a piece of a parsed template: either literal text or a field.
+type Node interface {
+	isNode()
+}
+
+// Text is literal template content.
+type Text struct {
+	Value string
+}
+
+// Field is a tabstop, placeholder, choice or variable. Numbered tabstops
+// are named by their index, so "$1" has the name "1".
+type Field struct {
+	Name       string
+	Tabstop    bool
+	Default    []Node
+	HasDefault bool
+	Choices    []string
+	Transform  *Transform
+}
+
+// Transform is a regular expression replacement applied to a field's value.
+type Transform struct {
+	Pattern *regexp.Regexp
+	Format  []FormatItem
+	Global  bool
+	// Source is the transform as written, "regex/format/flags", so it can
+	// be emitted again for editors that support transforms.
+	Source string
+}
+
+// FormatItem is a piece of a transform's format string: literal text, or a
+// reference to a capture group with an optional case modifier or
+// conditional insertion.
+type FormatItem struct {
+	Text     string
+	Group    int
+	IsGroup  bool
+	Modifier string
+	IfText   string
+	ElseText string
+}
+
+func (Text) isNode()   {}
+func (*Field) isNode() {}
+
+// Template is a parsed snippet.
+type Template struct {
+	Nodes []Node
+}
+
+// SyntaxError reports malformed placeholder syntax.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("placeholder syntax error at offset %d: %s", e.Offset, e.Msg)
+}
+
+// Parse parses a snippet template.
+func Parse(content string) (*Template, error) {
+	p := &parser{src: content}
+	nodes, err := p.parseNodes(false)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{Nodes: nodes}, nil
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+// parseNodes reads text and fields until the end of input or, when nested
+// inside a placeholder default, until the closing brace.
+func (p *parser) parseNodes(nested bool) ([]Node, error) {
+	var nodes []Node
+	var text strings.Builder
+
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, Text{Value: text.String()})
+			text.Reset()
+		}
+	}
+
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.src) && strings.IndexByte(`$}\`, p.src[p.pos+1]) >= 0:
+			text.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		case c == '}' && nested:
+			flush()
+			return nodes, nil
+		case c == '$':
+			field, ok, err := p.parseField()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				// A lone dollar sign is just text.
+				text.WriteByte(c)
+				p.pos++
+				continue
+			}
+			flush()
+			nodes = append(nodes, field)
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	if nested {
+		return nil, &SyntaxError{Offset: p.pos, Msg: "unterminated placeholder"}
+	}
+	flush()
+	return nodes, nil
+}
+
+// parseField parses a field starting at a '$'. It reports false when the
+// dollar sign does not start a field.
+func (p *parser) parseField() (*Field, bool, error) {
+	start := p.pos
+	p.pos++ // '$'
+
+	if p.pos < len(p.src) && p.src[p.pos] != '{' {
+		name, tabstop := p.readName()
+		if name == "" {
+			p.pos = start
+			return nil, false, nil
+		}
+		return &Field{Name: name, Tabstop: tabstop}, true, nil
+	}
+
+	if p.pos >= len(p.src) {
+		p.pos = start
+		return nil, false, nil
+	}
+
+	p.pos++ // '{'
+	name, tabstop := p.readName()
+	if name == "" {
+		p.pos = start
+		return nil, false, nil
+	}
+	field := &Field{Name: name, Tabstop: tabstop}
+
+	if p.pos >= len(p.src) {
+		return nil, false, &SyntaxError{Offset: start, Msg: "unterminated placeholder"}
+	}
+
+	switch p.src[p.pos] {
+	case '}':
+		p.pos++
+	case ':':
+		p.pos++
+		def, err := p.parseNodes(true)
+		if err != nil {
+			return nil, false, err
+		}
+		p.pos++ // '}'
+		field.Default = def
+		field.HasDefault = true
+	case '|':
+		p.pos++
+		choices, err := p.parseChoices()
+		if err != nil {
+			return nil, false, err
+		}
+		field.Choices = choices
+	case '/':
+		p.pos++
+		transform, err := p.parseTransform()
+		if err != nil {
+			return nil, false, err
+		}
+		field.Transform = transform
+	default:
+		return nil, false, &SyntaxError{Offset: p.pos, Msg: fmt.Sprintf("unexpected %q in placeholder", p.src[p.pos])}
+	}
+
+	return field, true, nil
+}
+
+// readName reads a tabstop index or a variable name.
+func (p *parser) readName() (string, bool) {
+	start := p.pos
+	if p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+		}
+		return p.src[start:p.pos], true
+	}
+	for p.pos < len(p.src) && isNameChar(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.src[start:p.pos], false
+}
+
+// parseChoices reads "one,two|}" after the opening pipe.
+func (p *parser) parseChoices() ([]string, error) {
+	var choices []string
+	var current strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.src) && strings.IndexByte(`,|\`, p.src[p.pos+1]) >= 0:
+			current.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		case c == ',':
+			choices = append(choices, current.String())
+			current.Reset()
+			p.pos++
+		case c == '|' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '}':
+			choices = append(choices, current.String())
+			p.pos += 2
+			return choices, nil
+		default:
+			current.WriteByte(c)
+			p.pos++
+		}
+	}
+	return nil, &SyntaxError{Offset: p.pos, Msg: "unterminated choice"}
+}
+
+// parseTransform reads "regex/format/flags}" after the first slash.
+func (p *parser) parseTransform() (*Transform, error) {
+	start := p.pos
+	pattern, err := p.readUntil('/')
+	if err != nil {
+		return nil, err
+	}
+	format, err := p.readFormat()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := p.readUntil('}')
+	if err != nil {
+		return nil, err
+	}
+
+	goFlags := ""
+	global := false
+	for _, f := range flags {
+		switch f {
+		case 'g':
+			global = true
+		case 'i', 'm', 's':
+			goFlags += string(f)
+		default:
+			return nil, &SyntaxError{Offset: start, Msg: fmt.Sprintf("unsupported transform flag %q", f)}
+		}
+	}
+	if goFlags != "" {
+		pattern = "(?" + goFlags + ")" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &SyntaxError{Offset: start, Msg: err.Error()}
+	}
+	source := p.src[start : p.pos-1]
+	return &Transform{Pattern: re, Format: format, Global: global, Source: source}, nil
+}
+
+func (p *parser) readUntil(end byte) (string, error) {
+	var out strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '\\' && p.pos+1 < len(p.src) && p.src[p.pos+1] == end {
+			out.WriteByte(end)
+			p.pos += 2
+			continue
+		}
+		if c == end {
+			p.pos++
+			return out.String(), nil
+		}
+		out.WriteByte(c)
+		p.pos++
+	}
+	return "", &SyntaxError{Offset: p.pos, Msg: "unterminated transform"}
+}
+
+// readFormat parses a transform format string up to its closing slash.
+// It understands $1, ${1}, ${1:/upcase}, ${1:+if}, ${1:-else}, ${1:else}
+// and ${1:?if:else}.
+func (p *parser) readFormat() ([]FormatItem, error) {
+	var items []FormatItem
+	var text strings.Builder
+
+	flush := func() {
+		if text.Len() > 0 {
+			items = append(items, FormatItem{Text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.src):
+			text.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		case c == '/':
+			p.pos++
+			flush()
+			return items, nil
+		case c == '$' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1]):
+			p.pos++
+			name, _ := p.readName()
+			group, _ := strconv.Atoi(name)
+			flush()
+			items = append(items, FormatItem{Group: group, IsGroup: true})
+		case c == '$' && p.pos+2 < len(p.src) && p.src[p.pos+1] == '{' && isDigit(p.src[p.pos+2]):
+			p.pos += 2
+			item, err := p.readFormatGroup()
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			items = append(items, item)
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+	return nil, &SyntaxError{Offset: p.pos, Msg: "unterminated transform"}
+}
+
+// readFormatGroup parses the inside of a "${1...}" format reference.
+func (p *parser) readFormatGroup() (FormatItem, error) {
+	name, _ := p.readName()
+	group, _ := strconv.Atoi(name)
+	item := FormatItem{Group: group, IsGroup: true}
+
+	if p.pos >= len(p.src) {
+		return item, &SyntaxError{Offset: p.pos, Msg: "unterminated format reference"}
+	}
+	if p.src[p.pos] == '}' {
+		p.pos++
+		return item, nil
+	}
+	if p.src[p.pos] != ':' {
+		return item, &SyntaxError{Offset: p.pos, Msg: "expected ':' in format reference"}
+	}
+	p.pos++
+
+	body, err := p.readUntil('}')
+	if err != nil {
+		return item, err
+	}
+	switch {
+	case strings.HasPrefix(body, "/"):
+		item.Modifier = body[1:]
+		switch item.Modifier {
+		case "upcase", "downcase", "capitalize", "camelcase", "pascalcase":
+		default:
+			return item, &SyntaxError{Offset: p.pos, Msg: fmt.Sprintf("unknown format modifier %q", item.Modifier)}
+		}
+	case strings.HasPrefix(body, "+"):
+		item.IfText = body[1:]
+	case strings.HasPrefix(body, "-"):
+		item.ElseText = body[1:]
+	case strings.HasPrefix(body, "?"):
+		ifText, elseText, _ := strings.Cut(body[1:], ":")
+		item.IfText, item.ElseText = ifText, elseText
+	default:
+		item.ElseText = body
+	}
+	return item, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && isDigit(c)
+}
+
+// index returns the tabstop number of a field, or -1 for named variables.
+func (f *Field) index() int {
+	if !f.Tabstop {
+		return -1
+	}
+	n, _ := strconv.Atoi(f.Name)
+	return n
+}
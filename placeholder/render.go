@@ -0,0 +1,239 @@
+package placeholder
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Variable describes a field a template declares.
+type Variable struct {
+	Name     string   `json:"name"`
+	Tabstop  bool     `json:"tabstop"`
+	Default  *string  `json:"default,omitempty"`
+	Choices  []string `json:"choices,omitempty"`
+	Required bool     `json:"required"`
+}
+
+// MissingError lists the required variables that were not given a value and
+// choice variables that were given a value outside their choices.
+type MissingError struct {
+	Fields map[string]string
+}
+
+func (e *MissingError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "invalid template values: " + strings.Join(names, ", ")
+}
+
+// Variables lists the fields the template declares, tabstops first in index
+// order and then named variables in order of appearance. A field that
+// occurs several times is reported once, using its first definition that
+// carries a default or choices.
+func (t *Template) Variables() []Variable {
+	byName := map[string]*Variable{}
+	var order []string
+
+	var walk func(nodes []Node)
+	walk = func(nodes []Node) {
+		for _, n := range nodes {
+			field, ok := n.(*Field)
+			if !ok {
+				continue
+			}
+			v, seen := byName[field.Name]
+			if !seen {
+				v = &Variable{Name: field.Name, Tabstop: field.Tabstop}
+				byName[field.Name] = v
+				order = append(order, field.Name)
+			}
+			if v.Default == nil && v.Choices == nil {
+				if field.HasDefault {
+					def := renderDefault(field.Default)
+					v.Default = &def
+				} else if len(field.Choices) > 0 {
+					v.Choices = field.Choices
+					v.Default = &field.Choices[0]
+				}
+			}
+			walk(field.Default)
+		}
+	}
+	walk(t.Nodes)
+
+	variables := make([]Variable, 0, len(order))
+	for _, name := range order {
+		v := byName[name]
+		// $0 only marks the final cursor position and never needs a value.
+		v.Required = v.Default == nil && v.Name != "0"
+		variables = append(variables, *v)
+	}
+
+	sort.SliceStable(variables, func(i, j int) bool {
+		a, b := variables[i], variables[j]
+		if a.Tabstop != b.Tabstop {
+			return a.Tabstop
+		}
+		if a.Tabstop {
+			return tabstopIndex(a.Name) < tabstopIndex(b.Name)
+		}
+		return false
+	})
+	return variables
+}
+
+// Render expands the template with the given values. Fields without a value
+// use their default; a missing required value or a value that is not one of
+// a field's choices produces a *MissingError.
+func (t *Template) Render(values map[string]string) (string, error) {
+	variables := t.Variables()
+	problems := map[string]string{}
+	resolved := map[string]string{}
+
+	for _, v := range variables {
+		value, ok := values[v.Name]
+		switch {
+		case ok && len(v.Choices) > 0 && !contains(v.Choices, value):
+			problems[v.Name] = "must be one of " + strings.Join(v.Choices, ", ")
+		case ok:
+			resolved[v.Name] = value
+		case v.Default != nil:
+			resolved[v.Name] = *v.Default
+		case v.Required:
+			problems[v.Name] = "required"
+		}
+	}
+	if len(problems) > 0 {
+		return "", &MissingError{Fields: problems}
+	}
+
+	var out strings.Builder
+	renderNodes(&out, t.Nodes, resolved)
+	return out.String(), nil
+}
+
+func renderNodes(out *strings.Builder, nodes []Node, values map[string]string) {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case Text:
+			out.WriteString(n.Value)
+		case *Field:
+			value := values[n.Name]
+			if n.Transform != nil {
+				value = n.Transform.apply(value)
+			}
+			out.WriteString(value)
+		}
+	}
+}
+
+// renderDefault flattens a default value, resolving nested fields to their
+// own defaults.
+func renderDefault(nodes []Node) string {
+	var out strings.Builder
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case Text:
+			out.WriteString(n.Value)
+		case *Field:
+			if n.HasDefault {
+				out.WriteString(renderDefault(n.Default))
+			} else if len(n.Choices) > 0 {
+				out.WriteString(n.Choices[0])
+			}
+		}
+	}
+	return out.String()
+}
+
+func (t *Transform) apply(value string) string {
+	matches := t.Pattern.FindAllStringSubmatchIndex(value, -1)
+	if !t.Global && len(matches) > 1 {
+		matches = matches[:1]
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, m := range matches {
+		out.WriteString(value[last:m[0]])
+		for _, item := range t.Format {
+			out.WriteString(item.expand(value, m))
+		}
+		last = m[1]
+	}
+	out.WriteString(value[last:])
+	return out.String()
+}
+
+func (item FormatItem) expand(value string, match []int) string {
+	if !item.IsGroup {
+		return item.Text
+	}
+
+	group := ""
+	if 2*item.Group+1 < len(match) && match[2*item.Group] >= 0 {
+		group = value[match[2*item.Group]:match[2*item.Group+1]]
+	}
+
+	switch item.Modifier {
+	case "upcase":
+		return strings.ToUpper(group)
+	case "downcase":
+		return strings.ToLower(group)
+	case "capitalize":
+		return mapFirst(group, unicode.ToUpper)
+	case "camelcase", "pascalcase":
+		words := strings.FieldsFunc(group, func(r rune) bool {
+			return r == '_' || r == '-' || r == ' '
+		})
+		for i, w := range words {
+			words[i] = mapFirst(strings.ToLower(w), unicode.ToUpper)
+		}
+		joined := strings.Join(words, "")
+		if item.Modifier == "camelcase" {
+			joined = mapFirst(joined, unicode.ToLower)
+		}
+		return joined
+	}
+
+	if group != "" {
+		if item.IfText != "" {
+			return item.IfText
+		}
+		return group
+	}
+	return item.ElseText
+}
+
+// mapFirst applies f to the first character of s, which is kept as is if
+// it is not valid UTF-8.
+func mapFirst(s string, f func(rune) rune) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(f(r)) + s[size:]
+}
+
+func tabstopIndex(name string) int {
+	// $0 is the final cursor position and sorts after every other tabstop.
+	f := Field{Name: name, Tabstop: true}
+	if i := f.index(); i != 0 {
+		return i
+	}
+	return int(^uint(0) >> 1)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
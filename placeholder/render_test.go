@@ -0,0 +1,31 @@
+package placeholder
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTransformCase(t *testing.T) {
+	for _, tt := range []struct {
+		content, value, want string
+	}{
+		{"${1/(.*)/${1:/capitalize}/}", "élan vital", "Élan vital"},
+		{"${1/(.*)/${1:/capitalize}/}", "hello", "Hello"},
+		{"${1/(.*)/${1:/pascalcase}/}", "élan_über-alles", "ÉlanÜberAlles"},
+		{"${1/(.*)/${1:/camelcase}/}", "Élan_über alles", "élanÜberAlles"},
+		{"${1/(.*)/${1:/camelcase}/}", "", ""},
+	} {
+		tmpl, err := Parse(tt.content)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.content, err)
+		}
+		got, err := tmpl.Render(map[string]string{"1": tt.value})
+		if err != nil {
+			t.Errorf("Render(%q) with %q: %v", tt.content, tt.value, err)
+			continue
+		}
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("Render(%q) with %q = %q, want %q", tt.content, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package related
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Owner says who may see an indexed snippet: its owner, and the members of
+// its organization if it is in one. Snippets outside organizations are
+// public.
+type Owner struct {
+	UserID int
+	OrgID  *int
+}
+
+// Match is a snippet similar to the one queried, with its cosine
+// similarity, between 0 and 1.
+type Match struct {
+	ID    int     `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// document is an indexed snippet: the term frequencies of its tokens.
+type document struct {
+	owner Owner
+	terms map[string]int
+}
+
+// Index holds the term frequencies of every indexed snippet and, per term,
+// the snippets containing it. Weights are computed when queried, so that
+// adding or removing a snippet is cheap and the inverse document
+// frequencies are always current. It is safe for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[int]*document
+	postings map[string]map[int]int
+}
+
+func NewIndex() *Index {
+	return &Index{docs: make(map[int]*document), postings: make(map[string]map[int]int)}
+}
+
+// Put indexes a snippet's tokens, replacing what was indexed for it.
+func (x *Index) Put(id int, owner Owner, tokens []string) {
+	terms := make(map[string]int)
+	for _, t := range tokens {
+		terms[t]++
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+	if len(terms) == 0 {
+		return
+	}
+	x.docs[id] = &document{owner: owner, terms: terms}
+	for t, n := range terms {
+		if x.postings[t] == nil {
+			x.postings[t] = make(map[int]int)
+		}
+		x.postings[t][id] = n
+	}
+}
+
+// Remove drops a snippet from the index.
+func (x *Index) Remove(id int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+}
+
+func (x *Index) removeLocked(id int) {
+	doc, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	for t := range doc.terms {
+		delete(x.postings[t], id)
+		if len(x.postings[t]) == 0 {
+			delete(x.postings, t)
+		}
+	}
+	delete(x.docs, id)
+}
+
+// Related returns up to k snippets most similar to the indexed snippet id
+// among those visible accepts, best first. ok is false when id is not
+// indexed.
+func (x *Index) Related(id, k int, visible func(Owner) bool) (_ []Match, ok bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	doc, ok := x.docs[id]
+	if !ok {
+		return nil, false
+	}
+	return x.queryLocked(doc.terms, id, k, visible), true
+}
+
+// Similar is Related for tokens that are not indexed, such as those of a
+// snippet being saved. exclude, if not zero, is left out of the results.
+func (x *Index) Similar(tokens []string, exclude, k int, visible func(Owner) bool) []Match {
+	terms := make(map[string]int)
+	for _, t := range tokens {
+		terms[t]++
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.queryLocked(terms, exclude, k, visible)
+}
+
+func (x *Index) queryLocked(terms map[string]int, exclude, k int, visible func(Owner) bool) []Match {
+	// A query that is not indexed counts as a document for the inverse
+	// document frequencies, as it would once indexed.
+	_, indexed := x.docs[exclude]
+	n := len(x.docs)
+	if !indexed {
+		n++
+	}
+	idf := func(t string) float64 {
+		df := len(x.postings[t])
+		if !indexed && terms[t] > 0 {
+			df++
+		}
+		return math.Log(1 + float64(n)/float64(df))
+	}
+
+	// Accumulate the dot products of the query with every visible snippet
+	// sharing a term with it.
+	var queryNorm float64
+	dots := make(map[int]float64)
+	hidden := make(map[int]bool)
+	for t, tf := range terms {
+		termIDF := idf(t)
+		w := weight(tf, termIDF)
+		queryNorm += w * w
+		for id, dtf := range x.postings[t] {
+			if id == exclude || hidden[id] {
+				continue
+			}
+			if _, seen := dots[id]; !seen && !visible(x.docs[id].owner) {
+				hidden[id] = true
+				continue
+			}
+			dots[id] += w * weight(dtf, termIDF)
+		}
+	}
+	if queryNorm == 0 {
+		return nil
+	}
+
+	matches := make([]Match, 0, len(dots))
+	for id, dot := range dots {
+		var norm float64
+		for t, tf := range x.docs[id].terms {
+			w := weight(tf, idf(t))
+			norm += w * w
+		}
+		matches = append(matches, Match{ID: id, Score: dot / math.Sqrt(queryNorm*norm)})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches
+}
+
+// weight is the TF-IDF weight of a term occurring tf times, with damped
+// term frequency so that repeating a word does not dominate.
+func weight(tf int, idf float64) float64 {
+	return (1 + math.Log(float64(tf))) * idf
+}
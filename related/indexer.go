@@ -0,0 +1,145 @@
+package related
+
+import (
+	"context"
+	"errors"
+	"log"
+	"snippetier/db/repo"
+	"sync"
+)
+
+// Indexer keeps an Index of every visible snippet up to date. It builds
+// the index when started and then reindexes the snippets it is told
+// changed. Burn-after-read snippets are never indexed, so that they are
+// not suggested to others.
+type Indexer struct {
+	snippets *repo.SnippetsRepo
+	index    *Index
+
+	mu      sync.Mutex
+	pending map[int]struct{}
+	wake    chan struct{}
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func NewIndexer(snippets *repo.SnippetsRepo) *Indexer {
+	return &Indexer{
+		snippets: snippets,
+		index:    NewIndex(),
+		pending:  make(map[int]struct{}),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Tokens returns the tokens a snippet is indexed under: those of its name,
+// description and content, and its tags.
+func Tokens(snippet repo.Snippet) []string {
+	text := snippet.Name + "\n" + snippet.Description + "\n" + snippet.Content
+	return append(Tokenize(text, snippet.Language), snippet.Tags...)
+}
+
+// Publish queues the snippet of a committed change for reindexing. It
+// never blocks, so it can be used as a repo.Publisher.
+func (ix *Indexer) Publish(change repo.Change) {
+	switch change.Event {
+	case repo.EventSnippetCreated, repo.EventSnippetUpdated, repo.EventSnippetDeleted:
+		ix.Refresh(change.SnippetID)
+	}
+}
+
+// Refresh queues a snippet for reindexing, e.g. one found to have expired.
+func (ix *Indexer) Refresh(id int) {
+	ix.mu.Lock()
+	ix.pending[id] = struct{}{}
+	ix.mu.Unlock()
+	select {
+	case ix.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Related returns up to k snippets similar to snippet among those visible
+// accepts. A snippet that is not indexed, such as a burn-after-read one, is
+// compared by its current tokens.
+func (ix *Indexer) Related(snippet repo.Snippet, k int, visible func(Owner) bool) []Match {
+	if matches, ok := ix.index.Related(snippet.ID, k, visible); ok {
+		return matches
+	}
+	return ix.index.Similar(Tokens(snippet), snippet.ID, k, visible)
+}
+
+// Start builds the index and then keeps it up to date in a background
+// goroutine.
+func (ix *Indexer) Start() {
+	go func() {
+		defer close(ix.done)
+
+		ix.build()
+		for {
+			select {
+			case <-ix.wake:
+				ix.reindexPending()
+			case <-ix.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the reindexing in progress and stops the indexer.
+func (ix *Indexer) Stop() {
+	ix.once.Do(func() { close(ix.stop) })
+	<-ix.done
+}
+
+func (ix *Indexer) build() {
+	snippets, err := ix.snippets.GetIndexedSnippets(context.Background())
+	if err != nil {
+		log.Println("Failed to build the related snippets index:", err)
+		return
+	}
+	for _, snippet := range snippets {
+		ix.put(snippet)
+	}
+}
+
+func (ix *Indexer) reindexPending() {
+	ix.mu.Lock()
+	ids := make([]int, 0, len(ix.pending))
+	for id := range ix.pending {
+		ids = append(ids, id)
+	}
+	clear(ix.pending)
+	ix.mu.Unlock()
+
+	for _, id := range ids {
+		select {
+		case <-ix.stop:
+			return
+		default:
+		}
+		snippet, err := ix.snippets.GetSnippetByID(context.Background(), id)
+		if errors.Is(err, repo.ErrSnippetNotFound) {
+			ix.index.Remove(id)
+			continue
+		}
+		if err != nil {
+			log.Println("Failed to reindex snippet:", err)
+			continue
+		}
+		ix.put(snippet)
+	}
+}
+
+func (ix *Indexer) put(snippet repo.Snippet) {
+	if snippet.BurnAfterRead {
+		ix.index.Remove(snippet.ID)
+		return
+	}
+	ix.index.Put(snippet.ID, Owner{UserID: snippet.UserId, OrgID: snippet.OrgID}, Tokens(snippet))
+}
@@ -0,0 +1,124 @@
+// Package related finds snippets similar to a given one. Snippets are
+// tokenized into identifier parts and words, weighted by TF-IDF and
+// compared by cosine similarity.
+package related
+
+import (
+	"snippetier/lang"
+	"strings"
+	"unicode"
+)
+
+// minTokenLen is the length below which tokens are too common to tell
+// snippets apart.
+const minTokenLen = 2
+
+// kebabLanguages allow hyphens inside identifiers, as in background-color.
+var kebabLanguages = map[string]bool{"css": true, "html": true, "yaml": true, "shell": true}
+
+// commonWords are left out of every language: English stop words, which
+// fill comments and prose.
+var commonWords = words("the a an and or not of to in on at by for with from as is are was were be been it its this that these those if then else we you they he she do does can will should would may")
+
+// keywords are each language's reserved words and ubiquitous builtins.
+// They say which language a snippet is in, which the language already
+// says, and not what it does.
+var keywords = map[string]map[string]bool{
+	"go":         words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false err error string int bool byte len make new append"),
+	"javascript": words("break case catch class const continue debugger default delete do else export extends finally for function if import in instanceof let new return super switch this throw try typeof var void while with yield async await null undefined true false of console log"),
+	"typescript": words("break case catch class const continue debugger default delete do else export extends finally for function if import in instanceof let new return super switch this throw try typeof var void while with yield async await null undefined true false of interface type implements private public protected readonly enum any number string boolean console log"),
+	"python":     words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield none true false self print"),
+	"java":       words("abstract assert boolean break byte case catch char class const continue default do double else enum extends final finally float for if implements import instanceof int interface long native new package private protected public return short static super switch synchronized this throw throws transient try void volatile while null true false string system out println"),
+	"kotlin":     words("as break class continue do else false for fun if in interface is null object package return super this throw true try typealias val var when while override private public internal println"),
+	"ruby":       words("alias and begin break case class def defined do else elsif end ensure false for if in module next nil not or redo rescue retry return self super then true undef unless until when while yield puts require attr"),
+	"rust":       words("as break const continue crate else enum extern false fn for if impl in let loop match mod move mut pub ref return self struct super trait true type unsafe use where while dyn some none ok err unwrap string vec println"),
+	"c":          words("auto break case char const continue default do double else enum extern float for goto if int long register return short signed sizeof static struct switch typedef union unsigned void volatile while include define null printf"),
+	"cpp":        words("auto break case char class const continue default delete do double else enum explicit extern false float for friend goto if inline int long namespace new nullptr operator private protected public return short signed sizeof static struct switch template this throw true try typedef typename union unsigned using virtual void volatile while include define std cout endl"),
+	"csharp":     words("abstract as base bool break byte case catch char class const continue decimal default delegate do double else enum event explicit extern false finally float for foreach if implicit in int interface internal is lock long namespace new null object operator out override params private protected public readonly ref return sealed short static string struct switch this throw true try typeof uint using var virtual void while async await console writeline"),
+	"php":        words("abstract and array as break callable case catch class clone const continue declare default do echo else elseif empty enddeclare endfor endforeach endif endswitch endwhile extends final finally fn for foreach function global if implements include instanceof interface isset list namespace new or print private protected public require return static switch throw trait try unset use var while null true false this"),
+	"shell":      words("if then else elif fi case esac for select while until do done in function time echo export local return exit set unset true false"),
+	"sql":        words("select from where and or not insert into values update set delete create table drop alter add column index primary key foreign references join inner left right outer on as group by order having limit offset distinct null is in like between union all exists case when then else end asc desc int integer varchar text default"),
+}
+
+func words(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(list) {
+		set[w] = true
+	}
+	return set
+}
+
+// Tokenize splits content into the terms it is indexed under: identifiers
+// and words, lowercased and split on camel and snake case, leaving out the
+// language's keywords. A compound identifier is kept whole as well as in
+// parts, so that snippets sharing it match more closely than ones sharing
+// its parts.
+func Tokenize(content, language string) []string {
+	language = lang.Canonical(language)
+	kebab := kebabLanguages[language]
+	stop := keywords[language]
+
+	var tokens []string
+	add := func(token string) {
+		if len([]rune(token)) < minTokenLen || isNumber(token) || commonWords[token] || stop[token] {
+			return
+		}
+		tokens = append(tokens, token)
+	}
+
+	isIdent := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || kebab && r == '-'
+	}
+	for _, ident := range strings.FieldsFunc(content, func(r rune) bool { return !isIdent(r) }) {
+		parts := splitIdentifier(ident)
+		if len(parts) > 1 {
+			add(strings.ToLower(strings.Trim(ident, "_-")))
+		}
+		for _, part := range parts {
+			add(part)
+		}
+	}
+	return tokens
+}
+
+// splitIdentifier splits an identifier into its lowercased words: on
+// underscores and hyphens, between a lower-case letter or digit and an
+// upper-case one, and before the last capital of an acronym followed by a
+// word, so that parseHTTPRequest gives parse, http and request.
+func splitIdentifier(ident string) []string {
+	var parts []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			parts = append(parts, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+
+	runes := []rune(ident)
+	for i, r := range runes {
+		if r == '_' || r == '-' {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && nextLower {
+				flush()
+			}
+		}
+		word = append(word, r)
+	}
+	flush()
+	return parts
+}
+
+func isNumber(token string) bool {
+	for _, r := range token {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
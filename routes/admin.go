@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"snippetier/apperr"
+	"snippetier/backup"
+	"snippetier/configs"
+	"snippetier/db"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxBackupSize caps the size of an uploaded account backup.
+const maxBackupSize = 100 << 20
+
+func SetupAdminRoutes(g *echo.Group, storage *db.Storage, config *configs.Config) {
+	g.Use(requireAdmin(config))
+	g.POST("/users/import", importAccount(storage))
+	g.GET("/duplicates", getDuplicateClusters(storage))
+	g.POST("/duplicates/merge", mergeDuplicates(storage))
+}
+
+// requireAdmin only lets through users listed in ADMIN_USER_IDS.
+func requireAdmin(config *configs.Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+			}
+			if isAdmin(config, userId) {
+				return next(c)
+			}
+			return apperr.New(apperr.ErrForbidden, "admin access required")
+		}
+	}
+}
+
+func isAdmin(config *configs.Config, userId int) bool {
+	for _, id := range config.AdminUserIds {
+		if id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// importAccount restores an account archive produced by GET
+// /api/users/me/export, uploaded in the "file" field, and returns the new
+// user ID and the mapping from old to new snippet IDs.
+func importAccount(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Missing file upload")
+		}
+		if fileHeader.Size > maxBackupSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Upload is too large")
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read upload")
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxBackupSize))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read upload")
+		}
+
+		account, err := backup.Read(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+
+		result, err := backup.Restore(c.Request().Context(), storage, account)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, result)
+	}
+}
@@ -39,7 +39,7 @@ func githubCallbackHandler(config *configs.Config) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		code := c.QueryParam("code")
 		if code == "" {
-			return c.String(http.StatusBadRequest, "Missing code")
+			return echo.NewHTTPError(http.StatusBadRequest, "Missing code")
 		}
 
 		githubAccessToken := auth.GetGithubAccessToken(code, config)
@@ -47,25 +47,30 @@ func githubCallbackHandler(config *configs.Config) echo.HandlerFunc {
 		githubData := auth.GetGithubProfileData(githubAccessToken)
 		if githubData == "" {
 			// Unauthorized users get an unauthorized message
-			return c.String(http.StatusUnauthorized, "UNAUTHORIZED!")
+			return echo.NewHTTPError(http.StatusUnauthorized, "GitHub authorization failed")
 		}
 
 		// Prettifying the json
 		var profileJson bytes.Buffer
 		err := json.Indent(&profileJson, []byte(githubData), "", "\t")
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "JSON parse error"})
+			return err
 		}
 
 		githubEmails := auth.GetGithubUserEmails(githubAccessToken)
 		if githubEmails == "" {
-			return c.String(http.StatusUnauthorized, "UNAUTHORIZED!")
+			return echo.NewHTTPError(http.StatusUnauthorized, "GitHub authorization failed")
 		}
 
 		var emailsJson bytes.Buffer
 		err = json.Indent(&emailsJson, []byte(githubEmails), "", "\t")
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "JSON parse error"})
+			return err
+		}
+
+		// Send users who opened an invitation link before logging in back to it
+		if target, ok := inviteRedirect(c); ok {
+			return c.Redirect(http.StatusSeeOther, target)
 		}
 
 		// Return the prettified JSON as a string
@@ -0,0 +1,352 @@
+package routes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"snippetier/apperr"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/export"
+	"snippetier/validate"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+func SetupCollectionRoutes(g *echo.Group, storage *db.Storage) {
+	g.GET("", getMyCollections(storage))
+	g.POST("", createCollection(storage))
+	g.GET("/shared/:token", getSharedCollection(storage, false))
+	g.GET("/shared/:token/export", getSharedCollection(storage, true))
+	g.GET("/:id", getCollection(storage, false))
+	g.GET("/:id/export", getCollection(storage, true))
+	g.PUT("/:id", updateCollection(storage))
+	g.PUT("/:id/position", moveCollection(storage))
+	g.DELETE("/:id", deleteCollection(storage))
+	g.PUT("/:id/items/:snippetId", setCollectionItem(storage))
+	g.DELETE("/:id/items/:snippetId", removeCollectionItem(storage))
+	g.POST("/:id/share", shareCollection(storage))
+	g.DELETE("/:id/share", unshareCollection(storage))
+}
+
+// collectionRequest is the body accepted when creating or updating a
+// collection. ParentID is only read on creation; moving a collection has
+// its own endpoint.
+type collectionRequest struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=1000"`
+	Visibility  string `json:"visibility"`
+	ParentID    *int   `json:"parentId"`
+}
+
+func (r *collectionRequest) validate() error {
+	invalid := validate.Fields(r)
+	switch r.Visibility {
+	case "":
+		r.Visibility = repo.VisibilityPrivate
+	case repo.VisibilityPrivate, repo.VisibilityPublic:
+	default:
+		invalid.Add("visibility", "must be private or public")
+	}
+	return invalid.Err()
+}
+
+// positionRequest moves a collection under ParentID, nil for the top
+// level, at Position among its siblings.
+type positionRequest struct {
+	ParentID *int `json:"parentId"`
+	Position int  `json:"position"`
+}
+
+// itemRequest places a snippet at Position in a collection, or at the end
+// if it is nil.
+type itemRequest struct {
+	Position *int `json:"position"`
+}
+
+// getMyCollections lists the caller's collections, parents before their
+// children.
+func getMyCollections(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		collections, err := storage.CollectionsRepo.GetCollectionsByUser(c.Request().Context(), userId)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, collections)
+	}
+}
+
+func createCollection(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		var req collectionRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+
+		collection, err := storage.CollectionsRepo.CreateCollection(c.Request().Context(), repo.Collection{
+			UserID:      userId,
+			ParentID:    req.ParentID,
+			Name:        req.Name,
+			Description: req.Description,
+			Visibility:  req.Visibility,
+		})
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, collection)
+	}
+}
+
+// getCollection returns a collection with its snippets and nested
+// collections, or downloads it as a bundle. Owners see every nested
+// collection; others only see public collections, without their private
+// descendants. Either way only the snippets the caller may see are listed.
+func getCollection(storage *db.Storage, download bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid collection ID")
+		}
+		// Reading does not require a user; anonymous callers see what is public.
+		userId, _ := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+
+		ctx := c.Request().Context()
+		collection, err := storage.CollectionsRepo.GetCollection(ctx, id)
+		if err != nil {
+			return err
+		}
+		owner := collection.UserID == userId
+		if !owner && collection.Visibility != repo.VisibilityPublic {
+			return repo.ErrCollectionNotFound
+		}
+
+		tree, err := storage.CollectionsRepo.GetTree(ctx, id, userId, !owner)
+		if err != nil {
+			return err
+		}
+		if !owner {
+			hideShareTokens(&tree)
+		}
+		return sendCollection(c, tree, download)
+	}
+}
+
+// getSharedCollection returns the collection a share link points at, with
+// everything nested in it, or downloads it as a bundle. Snippets in
+// organization libraries are only included for members.
+func getSharedCollection(storage *db.Storage, download bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// Share links work without a user; anonymous callers see no
+		// organization snippets.
+		userId, _ := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+
+		ctx := c.Request().Context()
+		collection, err := storage.CollectionsRepo.GetCollectionByShareToken(ctx, c.Param("token"))
+		if err != nil {
+			return err
+		}
+		tree, err := storage.CollectionsRepo.GetTree(ctx, collection.ID, userId, false)
+		if err != nil {
+			return err
+		}
+		hideShareTokens(&tree)
+		return sendCollection(c, tree, download)
+	}
+}
+
+func sendCollection(c echo.Context, tree repo.CollectionTree, download bool) error {
+	if !download {
+		return c.JSON(http.StatusOK, tree)
+	}
+	file, err := export.Bundle(tree)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to export collection")
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+file.Name+`"`)
+	return c.Blob(http.StatusOK, file.ContentType, file.Data)
+}
+
+// hideShareTokens clears the share links of a tree shown to someone other
+// than its owner.
+func hideShareTokens(tree *repo.CollectionTree) {
+	tree.ShareToken = nil
+	for i := range tree.Children {
+		hideShareTokens(&tree.Children[i])
+	}
+}
+
+func updateCollection(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := collectionParams(c)
+		if err != nil {
+			return err
+		}
+
+		var req collectionRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+
+		collection, err := storage.CollectionsRepo.UpdateCollection(c.Request().Context(), userId, id, repo.Collection{
+			Name:        req.Name,
+			Description: req.Description,
+			Visibility:  req.Visibility,
+		})
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, collection)
+	}
+}
+
+// moveCollection reorders a collection among its siblings or moves it
+// under another parent.
+func moveCollection(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := collectionParams(c)
+		if err != nil {
+			return err
+		}
+
+		var req positionRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if req.Position < 0 {
+			return apperr.Invalid("position", "must not be negative")
+		}
+
+		if err := storage.CollectionsRepo.MoveCollection(c.Request().Context(), userId, id, req.ParentID, req.Position); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// deleteCollection deletes a collection and every collection nested in it.
+// The snippets in them are not deleted.
+func deleteCollection(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := collectionParams(c)
+		if err != nil {
+			return err
+		}
+
+		if err := storage.CollectionsRepo.DeleteCollection(c.Request().Context(), userId, id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// setCollectionItem adds a snippet to a collection or moves it within it.
+func setCollectionItem(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := collectionParams(c)
+		if err != nil {
+			return err
+		}
+		snippetId, err := strconv.Atoi(c.Param("snippetId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		var req itemRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if req.Position != nil && *req.Position < 0 {
+			return apperr.Invalid("position", "must not be negative")
+		}
+
+		if err := storage.CollectionsRepo.SetItem(c.Request().Context(), userId, id, snippetId, req.Position); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func removeCollectionItem(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := collectionParams(c)
+		if err != nil {
+			return err
+		}
+		snippetId, err := strconv.Atoi(c.Param("snippetId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		if err := storage.CollectionsRepo.RemoveItem(c.Request().Context(), userId, id, snippetId); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// shareCollection creates a share link for a collection, replacing any
+// previous one.
+func shareCollection(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := collectionParams(c)
+		if err != nil {
+			return err
+		}
+
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		token := hex.EncodeToString(b)
+		if err := storage.CollectionsRepo.SetShareToken(c.Request().Context(), userId, id, &token); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, map[string]string{
+			"token": token,
+			"url":   c.Scheme() + "://" + c.Request().Host + "/api/collections/shared/" + token,
+		})
+	}
+}
+
+// unshareCollection revokes the share link of a collection.
+func unshareCollection(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := collectionParams(c)
+		if err != nil {
+			return err
+		}
+
+		if err := storage.CollectionsRepo.SetShareToken(c.Request().Context(), userId, id, nil); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// collectionParams reads the caller and collection ID of a request.
+func collectionParams(c echo.Context) (userId, id int, err error) {
+	if userId, err = strconv.Atoi(c.Request().Header.Get(UserIdHeader)); err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	if id, err = strconv.Atoi(c.Param("id")); err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid collection ID")
+	}
+	return userId, id, nil
+}
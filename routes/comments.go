@@ -0,0 +1,222 @@
+package routes
+
+import (
+	"net/http"
+	"snippetier/apperr"
+	"snippetier/configs"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/markdown"
+	"snippetier/validate"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// commentRequest is the body accepted when posting a comment. Anchor is only
+// allowed on comments that start a thread.
+type commentRequest struct {
+	Body     string         `json:"body" validate:"required,max=10000"`
+	ParentID *int           `json:"parentId"`
+	Anchor   *anchorRequest `json:"anchor"`
+}
+
+// anchorRequest is a 1-based, inclusive line range. A zero revision means
+// the snippet's current revision.
+type anchorRequest struct {
+	Revision  int `json:"revision"`
+	LineStart int `json:"lineStart"`
+	LineEnd   int `json:"lineEnd"`
+}
+
+// commentBodyRequest is the body accepted when editing a comment.
+type commentBodyRequest struct {
+	Body string `json:"body" validate:"required,max=10000"`
+}
+
+// commentResponse is a comment with its Markdown body rendered to sanitized
+// HTML. Deleted and removed comments are returned without a body so that
+// their place in the thread is kept.
+type commentResponse struct {
+	repo.Comment
+	BodyHTML string `json:"bodyHtml"`
+}
+
+// commentThread is a root comment followed by its replies, oldest first.
+type commentThread struct {
+	commentResponse
+	Replies []commentResponse `json:"replies"`
+}
+
+func newCommentResponse(comment repo.Comment) commentResponse {
+	if comment.Deleted || comment.RemovedBy != nil {
+		comment.Body = ""
+		return commentResponse{Comment: comment}
+	}
+	return commentResponse{Comment: comment, BodyHTML: markdown.Render(comment.Body)}
+}
+
+// getComments lists the comment threads of a snippet.
+func getComments(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		ctx := c.Request().Context()
+		if _, err := viewSnippet(ctx, storage, userId, id); err != nil {
+			return err
+		}
+		comments, err := storage.CommentsRepo.GetComments(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		threads := []*commentThread{}
+		byID := map[int]*commentThread{}
+		for _, comment := range comments {
+			if comment.ParentID == nil {
+				thread := &commentThread{commentResponse: newCommentResponse(comment), Replies: []commentResponse{}}
+				threads = append(threads, thread)
+				byID[comment.ID] = thread
+			} else if thread, ok := byID[*comment.ParentID]; ok {
+				thread.Replies = append(thread.Replies, newCommentResponse(comment))
+			}
+		}
+		return c.JSON(http.StatusOK, threads)
+	}
+}
+
+// addComment posts a comment, a reply or a line-anchored review comment.
+func addComment(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		var req commentRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		invalid := validate.Fields(&req)
+		if req.ParentID != nil && req.Anchor != nil {
+			invalid.Add("anchor", "is not allowed on replies")
+		}
+		if err := invalid.Err(); err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		if _, err := viewSnippet(ctx, storage, userId, id); err != nil {
+			return err
+		}
+		comment := repo.Comment{SnippetID: id, ParentID: req.ParentID, AuthorID: userId, Body: req.Body}
+		if req.Anchor != nil {
+			comment.Anchor = &repo.Anchor{Revision: req.Anchor.Revision, LineStart: req.Anchor.LineStart, LineEnd: req.Anchor.LineEnd}
+		}
+		comment, err = storage.CommentsRepo.AddComment(ctx, comment)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, newCommentResponse(comment))
+	}
+}
+
+// updateComment lets the author edit the body of their comment.
+func updateComment(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, commentId, err := commentParams(c)
+		if err != nil {
+			return err
+		}
+
+		var req commentBodyRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if err := validate.Struct(&req); err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		comment, err := storage.CommentsRepo.GetComment(ctx, id, commentId)
+		if err != nil {
+			return err
+		}
+		if comment.AuthorID != userId {
+			return apperr.New(apperr.ErrForbidden, "only the author can edit a comment")
+		}
+		if comment.Deleted || comment.RemovedBy != nil {
+			return apperr.New(apperr.ErrConflict, "the comment was deleted")
+		}
+
+		comment, err = storage.CommentsRepo.UpdateComment(ctx, id, commentId, req.Body)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, newCommentResponse(comment))
+	}
+}
+
+// deleteComment deletes a comment on behalf of its author, or removes it on
+// behalf of a moderator: an admin or anyone who may edit the snippet.
+func deleteComment(storage *db.Storage, config *configs.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, commentId, err := commentParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		comment, err := storage.CommentsRepo.GetComment(ctx, id, commentId)
+		if err != nil {
+			return err
+		}
+		if comment.AuthorID == userId {
+			if err := storage.CommentsRepo.DeleteComment(ctx, id, commentId); err != nil {
+				return err
+			}
+			return c.NoContent(http.StatusNoContent)
+		}
+
+		snippet, err := storage.SnippetsRepo.GetSnippetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		canEdit, err := canEditSnippet(ctx, storage.OrgsRepo, userId, snippet)
+		if err != nil {
+			return err
+		}
+		if !canEdit && !isAdmin(config, userId) {
+			return apperr.New(apperr.ErrForbidden, "only the author or a moderator can delete a comment")
+		}
+		if err := storage.CommentsRepo.RemoveComment(ctx, id, commentId, userId); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// commentParams reads the caller, snippet ID and comment ID of a request.
+func commentParams(c echo.Context) (userId, id, commentId int, err error) {
+	if userId, err = strconv.Atoi(c.Request().Header.Get(UserIdHeader)); err != nil {
+		return 0, 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	if id, err = strconv.Atoi(c.Param("id")); err != nil {
+		return 0, 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+	}
+	if commentId, err = strconv.Atoi(c.Param("commentId")); err != nil {
+		return 0, 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid comment ID")
+	}
+	return userId, id, commentId, nil
+}
@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/validate"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type mergeRequest struct {
+	Target     int   `json:"target" validate:"required"`
+	Duplicates []int `json:"duplicates" validate:"max=100"`
+}
+
+func (r *mergeRequest) validate() error {
+	invalid := validate.Fields(r)
+	if len(r.Duplicates) == 0 {
+		invalid.Add("duplicates", "must list at least one snippet")
+	}
+	seen := make(map[int]bool, len(r.Duplicates))
+	for _, id := range r.Duplicates {
+		if id == r.Target {
+			invalid.Add("duplicates", "must not include the target")
+			break
+		}
+		if seen[id] {
+			invalid.Add("duplicates", "must not repeat a snippet")
+			break
+		}
+		seen[id] = true
+	}
+	return invalid.Err()
+}
+
+// getDuplicateClusters reports the groups of likely near-duplicate
+// snippets across the library, largest first. The most starred snippet
+// of each group is listed first, as the natural merge target.
+func getDuplicateClusters(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		clusters, err := storage.SnippetsRepo.GetDuplicateClusters(c.Request().Context())
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, clusters)
+	}
+}
+
+// mergeDuplicates merges duplicates into a target snippet: the target
+// gains their stars, tags and collection entries and keeps their history,
+// and their IDs redirect to it. It returns the merged snippet.
+func mergeDuplicates(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		var req mergeRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		if err := storage.SnippetsRepo.MergeSnippets(ctx, userId, req.Target, req.Duplicates); err != nil {
+			return err
+		}
+		snippet, err := storage.SnippetsRepo.GetSnippetByID(ctx, req.Target)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, snippet)
+	}
+}
+
+// followRedirects sends reads of a snippet that was merged into another as
+// a duplicate on to the snippet it was merged into, with a permanent
+// redirect to the same path under the new ID.
+func followRedirects(storage *db.Storage) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			method := c.Request().Method
+			if !errors.Is(err, repo.ErrSnippetNotFound) || method != http.MethodGet && method != http.MethodHead || c.Response().Committed {
+				return err
+			}
+			id, convErr := strconv.Atoi(c.Param("id"))
+			if convErr != nil {
+				return err
+			}
+			newID, resolveErr := storage.SnippetsRepo.ResolveRedirect(c.Request().Context(), id)
+			if resolveErr != nil {
+				return err
+			}
+
+			// The ID is the first path segment equal to it: the segments
+			// before it are the group's fixed prefix.
+			segments := strings.Split(c.Request().URL.Path, "/")
+			i := slices.Index(segments, c.Param("id"))
+			if i < 0 {
+				return err
+			}
+			segments[i] = strconv.Itoa(newID)
+			location := strings.Join(segments, "/")
+			if q := c.Request().URL.RawQuery; q != "" {
+				location += "?" + q
+			}
+			return c.Redirect(http.StatusPermanentRedirect, location)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"snippetier/apperr"
+	"snippetier/db/repo"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StatusClientClosedRequest is the non-standard status, borrowed from nginx,
+// for requests whose client went away before the response was ready.
+const StatusClientClosedRequest = 499
+
+// MIMEApplicationProblemJSON is the media type of RFC 7807 error responses.
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. Handlers can return one
+// directly to add members beyond the standard ones.
+type Problem struct {
+	Type      string              `json:"type"`
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Detail    string              `json:"detail,omitempty"`
+	Instance  string              `json:"instance,omitempty"`
+	RequestID string              `json:"requestId,omitempty"`
+	Errors    []apperr.FieldError `json:"errors,omitempty"`
+	// Extensions holds additional members specific to the problem.
+	Extensions map[string]any `json:"-"`
+}
+
+// newProblem returns a problem of the generic "about:blank" type.
+func newProblem(status int, detail string) *Problem {
+	title := http.StatusText(status)
+	if status == StatusClientClosedRequest {
+		title = "Client Closed Request"
+	}
+	return &Problem{Type: "about:blank", Title: title, Status: status, Detail: detail}
+}
+
+// With adds an extension member to the problem.
+func (p *Problem) With(key string, value any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = map[string]any{}
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	members := map[string]any{}
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	data, err := json.Marshal((*problem)(p))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+// problemFor maps an error returned by a handler to the problem describing it.
+func problemFor(err error) *Problem {
+	var p *Problem
+	var he *echo.HTTPError
+	var invalid *apperr.ValidationError
+	switch {
+	case errors.As(err, &p):
+		return p
+	case errors.As(err, &he):
+		detail, ok := he.Message.(string)
+		if !ok {
+			detail = ""
+		}
+		return newProblem(he.Code, detail)
+	case errors.As(err, &invalid):
+		p := newProblem(http.StatusUnprocessableEntity, "The request has invalid fields")
+		p.Errors = invalid.Fields
+		return p
+	case errors.Is(err, apperr.ErrNotFound):
+		return newProblem(http.StatusNotFound, err.Error())
+	case errors.Is(err, apperr.ErrConflict):
+		return newProblem(http.StatusConflict, err.Error())
+	case errors.Is(err, apperr.ErrForbidden):
+		return newProblem(http.StatusForbidden, err.Error())
+	case errors.Is(err, repo.ErrCanceled):
+		return newProblem(StatusClientClosedRequest, "The request was cancelled")
+	case errors.Is(err, repo.ErrTimeout):
+		return newProblem(http.StatusServiceUnavailable, "The database did not respond in time")
+	default:
+		return newProblem(http.StatusInternalServerError, "")
+	}
+}
+
+// HTTPErrorHandler renders every error returned by a handler as
+// problem+json carrying the request ID, so clients get one error format.
+func HTTPErrorHandler(err error, c echo.Context) {
+	res := c.Response()
+	if res.Committed {
+		return
+	}
+
+	p := *problemFor(err)
+	p.Instance = c.Request().URL.Path
+	p.RequestID = res.Header().Get(echo.HeaderXRequestID)
+	if p.Status >= http.StatusInternalServerError {
+		log.Printf("Request %s failed: %v", p.RequestID, err)
+	}
+
+	res.Header().Set(echo.HeaderContentType, MIMEApplicationProblemJSON)
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		err = c.JSON(p.Status, &p)
+	}
+	if err != nil {
+		log.Println("Error writing error response:", err)
+	}
+}
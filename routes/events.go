@@ -0,0 +1,243 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"snippetier/db"
+	"snippetier/events"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// heartbeatInterval is how often an idle stream is kept alive, so that
+	// proxies do not time it out and dead clients are noticed.
+	heartbeatInterval = 20 * time.Second
+	// streamWriteTimeout bounds a single write to a client. A client that
+	// cannot take a write in that time is disconnected.
+	streamWriteTimeout = 10 * time.Second
+)
+
+func SetupEventRoutes(g *echo.Group, storage *db.Storage, services *Services) {
+	g.GET("", streamEvents(storage, services.Events))
+	g.GET("/ws", socketEvents(storage, services.Events))
+}
+
+// wsMessage is a message sent over the WebSocket: an event, or a final
+// "lagged" message when the client fell too far behind.
+type wsMessage struct {
+	Type  string        `json:"type"`
+	Event *events.Event `json:"event,omitempty"`
+}
+
+// streamEvents streams the changes the caller may see as Server-Sent
+// Events. The stream can be narrowed with ?snippet=<id>, ?user=<id> and
+// ?org=<slug>. The caller's organizations are read again on every
+// heartbeat, so a removed member stops getting their events, and a stream
+// narrowed to an organization they left ends. Events are not replayed: a client that reconnects, or gets
+// a "lagged" event for falling behind, should refetch what it shows.
+func streamEvents(storage *db.Storage, hub *events.Hub) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sub, userId, err := subscribe(c, storage, hub)
+		if err != nil {
+			return err
+		}
+		defer sub.Close()
+
+		w := c.Response()
+		w.Header().Set(echo.HeaderContentType, "text/event-stream")
+		w.Header().Set(echo.HeaderCacheControl, "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		rc := http.NewResponseController(w)
+		send := func(format string, args ...any) error {
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if _, err := fmt.Fprintf(w, format, args...); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		if err := send("retry: %d\n\n", 3000); err != nil {
+			return nil
+		}
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case event, ok := <-sub.Events():
+				if !ok {
+					if sub.Lagged() {
+						send("event: lagged\ndata: {}\n\n")
+					}
+					return nil
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					return nil
+				}
+				if err := send("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event, data); err != nil {
+					return nil
+				}
+			case <-heartbeat.C:
+				refreshOrgs(c.Request().Context(), storage, sub, userId)
+				if err := send(": keepalive\n\n"); err != nil {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// socketEvents streams the same events as streamEvents over a WebSocket,
+// as JSON messages, and pings idle connections. Browsers must connect from
+// the same origin.
+func socketEvents(storage *db.Storage, hub *events.Hub) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sub, userId, err := subscribe(c, storage, hub)
+		if err != nil {
+			return err
+		}
+		defer sub.Close()
+
+		server := websocket.Server{
+			Handshake: sameOrigin,
+			Handler: func(ws *websocket.Conn) {
+				// Reading answers pings and notices when the client goes away.
+				closed := make(chan struct{})
+				go func() {
+					defer close(closed)
+					io.Copy(io.Discard, ws)
+				}()
+				defer ws.Close()
+
+				send := func(v any) error {
+					ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+					return websocket.JSON.Send(ws, v)
+				}
+				heartbeat := time.NewTicker(heartbeatInterval)
+				defer heartbeat.Stop()
+
+				for {
+					select {
+					case <-closed:
+						return
+					case event, ok := <-sub.Events():
+						if !ok {
+							if sub.Lagged() {
+								send(wsMessage{Type: "lagged"})
+							}
+							return
+						}
+						if err := send(wsMessage{Type: "event", Event: &event}); err != nil {
+							return
+						}
+					case <-heartbeat.C:
+						refreshOrgs(ws.Request().Context(), storage, sub, userId)
+						ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+						ws.PayloadType = websocket.PingFrame
+						_, err := ws.Write(nil)
+						ws.PayloadType = websocket.TextFrame
+						if err != nil {
+							return
+						}
+					}
+				}
+			},
+		}
+		server.ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
+
+// sameOrigin accepts WebSocket handshakes from pages on the same host, and
+// from clients that send no Origin, which are not browsers.
+func sameOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != req.Host {
+		return fmt.Errorf("cross-origin WebSocket from %q", origin)
+	}
+	config.Origin = u
+	return nil
+}
+
+// subscribe opens a subscription for the caller with the filters of the
+// request, and returns it with the caller's ID. The caller must be able to
+// see the snippet or organization it filters on.
+func subscribe(c echo.Context, storage *db.Storage, hub *events.Hub) (*events.Subscription, int, error) {
+	userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+	if err != nil {
+		return nil, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	ctx := c.Request().Context()
+	var filter events.Filter
+	if v := c.QueryParam("snippet"); v != "" {
+		if filter.SnippetID, err = strconv.Atoi(v); err != nil {
+			return nil, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+		snippet, err := storage.SnippetsRepo.GetSnippetByID(ctx, filter.SnippetID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok, err := canViewSnippet(ctx, storage.OrgsRepo, userId, snippet); !ok {
+			return nil, 0, orNotFound(err)
+		}
+	}
+	if v := c.QueryParam("user"); v != "" {
+		if filter.UserID, err = strconv.Atoi(v); err != nil {
+			return nil, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+	}
+	if slug := c.QueryParam("org"); slug != "" {
+		org, _, err := orgMembership(ctx, storage.OrgsRepo, slug, userId)
+		if err != nil {
+			return nil, 0, err
+		}
+		filter.OrgID = org.ID
+	}
+
+	orgIDs, err := userOrgIDs(ctx, storage, userId)
+	if err != nil {
+		return nil, 0, err
+	}
+	return hub.Subscribe(events.Viewer{UserID: userId, OrgIDs: orgIDs}, filter), userId, nil
+}
+
+// refreshOrgs updates a subscription with the organizations the user
+// belongs to now. If they cannot be read, the subscription keeps the ones
+// it had until the next try.
+func refreshOrgs(ctx context.Context, storage *db.Storage, sub *events.Subscription, userId int) {
+	orgIDs, err := userOrgIDs(ctx, storage, userId)
+	if err != nil {
+		return
+	}
+	sub.SetOrgs(orgIDs)
+}
+
+func userOrgIDs(ctx context.Context, storage *db.Storage, userId int) ([]int, error) {
+	orgs, err := storage.OrgsRepo.GetOrgsByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, org := range orgs {
+		ids = append(ids, org.ID)
+	}
+	return ids, nil
+}
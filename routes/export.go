@@ -0,0 +1,88 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"snippetier/apperr"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/export"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// snippetFilter reads the search parameters shared by the search and export
+// endpoints: q, language, tag, user and sort.
+func snippetFilter(c echo.Context) (repo.SnippetFilter, error) {
+	filter := repo.SnippetFilter{
+		Query:    c.QueryParam("q"),
+		Language: c.QueryParam("language"),
+		Tag:      c.QueryParam("tag"),
+	}
+	if user := c.QueryParam("user"); user != "" {
+		userId, err := strconv.Atoi(user)
+		if err != nil {
+			return repo.SnippetFilter{}, apperr.Invalid("user", "must be a user ID")
+		}
+		filter.UserID = userId
+	}
+	sort, err := repo.ParseSnippetSort(c.QueryParam("sort"))
+	if err != nil {
+		return repo.SnippetFilter{}, apperr.Invalid("sort", "must be updated or stars")
+	}
+	filter.Sort = sort
+	return filter, nil
+}
+
+func searchSnippets(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		filter, err := snippetFilter(c)
+		if err != nil {
+			return err
+		}
+
+		snippets, err := storage.SnippetsRepo.SearchSnippets(c.Request().Context(), userId, filter)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, snippets)
+	}
+}
+
+// exportSnippets downloads the snippets matching the search parameters as
+// an editor snippet file: vscode, jetbrains, sublime or yasnippet.
+func exportSnippets(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		filter, err := snippetFilter(c)
+		if err != nil {
+			return err
+		}
+
+		snippets, err := storage.SnippetsRepo.SearchSnippets(c.Request().Context(), userId, filter)
+		if err != nil {
+			return err
+		}
+
+		file, err := export.Export(c.Param("format"), snippets)
+		var unknown export.ErrUnknownFormat
+		if errors.As(err, &unknown) {
+			return echo.NewHTTPError(http.StatusNotFound, unknown.Error())
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to export snippets")
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+file.Name+`"`)
+		return c.Blob(http.StatusOK, file.ContentType, file.Data)
+	}
+}
@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"net/http"
+	"snippetier/apperr"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+// feedPage is one page of the activity feed. NextCursor is passed back as
+// ?before= to get the next page and is left out on the last one.
+type feedPage struct {
+	Items      []repo.Activity `json:"items"`
+	NextCursor *int            `json:"nextCursor,omitempty"`
+}
+
+// followUser makes the caller follow a user.
+func followUser(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := followParams(c)
+		if err != nil {
+			return err
+		}
+
+		if err := storage.UsersRepo.Follow(c.Request().Context(), userId, id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// unfollowUser stops the caller following a user.
+func unfollowUser(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := followParams(c)
+		if err != nil {
+			return err
+		}
+
+		if err := storage.UsersRepo.Unfollow(c.Request().Context(), userId, id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// getFollowers lists the users following a user, or with following set,
+// the users they follow.
+func getFollowers(storage *db.Storage, following bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		ctx := c.Request().Context()
+		if _, err := storage.UsersRepo.GetUserByID(ctx, id); err != nil {
+			return err
+		}
+		var users []repo.User
+		if following {
+			users, err = storage.UsersRepo.GetFollowing(ctx, id)
+		} else {
+			users, err = storage.UsersRepo.GetFollowers(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, users)
+	}
+}
+
+func followParams(c echo.Context) (userId, id int, err error) {
+	if userId, err = strconv.Atoi(c.Request().Header.Get(UserIdHeader)); err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	if id, err = strconv.Atoi(c.Param("id")); err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	return userId, id, nil
+}
+
+// getFeed returns the caller's activity feed, newest first: what the users
+// they follow did, and what happened to their organizations' snippets.
+// Pages are requested with ?before=<nextCursor>&limit=<n>.
+func getFeed(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		invalid := &apperr.ValidationError{}
+		before, limit := 0, defaultFeedLimit
+		if v := c.QueryParam("before"); v != "" {
+			if before, err = strconv.Atoi(v); err != nil || before < 1 {
+				invalid.Add("before", "must be a cursor returned by a previous page")
+			}
+		}
+		if v := c.QueryParam("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxFeedLimit {
+				invalid.Add("limit", "must be between 1 and "+strconv.Itoa(maxFeedLimit))
+			}
+		}
+		if err := invalid.Err(); err != nil {
+			return err
+		}
+
+		items, err := storage.ActivityRepo.GetFeed(c.Request().Context(), userId, before, limit)
+		if err != nil {
+			return err
+		}
+		page := feedPage{Items: items}
+		if len(items) == limit {
+			page.NextCursor = &items[len(items)-1].ID
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+}
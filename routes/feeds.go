@@ -0,0 +1,187 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/feed"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// feedSize is how many of the most recently updated snippets a feed lists.
+const feedSize = 50
+
+// setupFeedRoutes serves the feeds for feed readers, which cannot send the
+// user header: everything in them is public. Each feed is available as
+// feed.atom, feed.rss and feed.json.
+func setupFeedRoutes(e *echo.Echo, storage *db.Storage) {
+	e.GET("/u/:username/feed.:format", userFeed(storage))
+	e.GET("/tags/:tag/feed.:format", tagFeed(storage))
+	e.GET("/searches/:id/feed.:format", searchFeed(storage))
+}
+
+// userFeed lists a user's latest public snippets.
+func userFeed(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := storage.UsersRepo.GetUserByUsername(c.Request().Context(), c.Param("username"))
+		if err != nil {
+			return err
+		}
+		filter := repo.SnippetFilter{UserID: user.ID}
+		link := "/api/snippets/search?user=" + strconv.Itoa(user.ID)
+		return sendFeed(c, storage, filter, "Snippets by "+user.Username, link)
+	}
+}
+
+// tagFeed lists the latest public snippets with a tag.
+func tagFeed(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tag := strings.ToLower(c.Param("tag"))
+		filter := repo.SnippetFilter{Tag: tag}
+		link := "/api/snippets/search?tag=" + url.QueryEscape(tag)
+		return sendFeed(c, storage, filter, "Snippets tagged "+tag, link)
+	}
+}
+
+// searchFeed lists the latest public snippets matching a saved search.
+// Anyone with the link can read it, so saved searches should not hold
+// anything their owner wants to keep private.
+func searchFeed(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid saved search ID")
+		}
+		search, err := storage.SearchesRepo.GetSearch(c.Request().Context(), id)
+		if err != nil {
+			return err
+		}
+		params := url.Values{}
+		for key, value := range map[string]string{"q": search.Query, "language": search.Language, "tag": search.Tag} {
+			if value != "" {
+				params.Set(key, value)
+			}
+		}
+		return sendFeed(c, storage, search.Filter(), search.Name, "/api/snippets/search?"+params.Encode())
+	}
+}
+
+// sendFeed renders the latest public snippets matching filter in the
+// format named in the path. It honours If-None-Match and
+// If-Modified-Since so that readers polling an unchanged feed get a 304.
+func sendFeed(c echo.Context, storage *db.Storage, filter repo.SnippetFilter, title, link string) error {
+	format := c.Param("format")
+	if !feed.Known(format) {
+		return echo.NewHTTPError(http.StatusNotFound, feed.ErrUnknownFormat(format).Error())
+	}
+
+	ctx := c.Request().Context()
+	filter.PublicOnly = true
+	filter.Sort = repo.SortUpdated
+	filter.Limit = feedSize
+	snippets, err := storage.SnippetsRepo.SearchSnippets(ctx, 0, filter)
+	if err != nil {
+		return err
+	}
+
+	base := c.Scheme() + "://" + c.Request().Host
+	f := feed.Feed{
+		Title: title,
+		Link:  base + link,
+		Self:  base + c.Request().URL.Path,
+	}
+	authors := map[int]string{}
+	for _, s := range snippets {
+		entry, err := feedEntry(ctx, storage, base, s, authors)
+		if err != nil {
+			return err
+		}
+		if entry.Updated.After(f.Updated) {
+			f.Updated = entry.Updated
+		}
+		f.Entries = append(f.Entries, entry)
+	}
+
+	doc, err := feed.Render(format, f)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to render feed")
+	}
+
+	tag := etag(doc.Data)
+	header := c.Response().Header()
+	header.Set(HeaderETag, tag)
+	if !f.Updated.IsZero() {
+		header.Set(echo.HeaderLastModified, f.Updated.UTC().Format(http.TimeFormat))
+	}
+	if notModified(c.Request(), tag, f.Updated) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.Blob(http.StatusOK, doc.ContentType, doc.Data)
+}
+
+// feedEntry converts a snippet into a feed entry. authors caches usernames
+// by user ID across the entries of a feed.
+func feedEntry(ctx context.Context, storage *db.Storage, base string, s repo.Snippet, authors map[int]string) (feed.Entry, error) {
+	author, ok := authors[s.UserId]
+	if !ok {
+		user, err := storage.UsersRepo.GetUserByID(ctx, s.UserId)
+		if err != nil && !errors.Is(err, repo.ErrUserNotFound) {
+			return feed.Entry{}, err
+		}
+		author = user.Username
+		authors[s.UserId] = author
+	}
+
+	title := s.Name
+	if title == "" {
+		title = "Untitled snippet"
+	}
+	return feed.Entry{
+		ID:        base + "/api/snippets/" + strconv.Itoa(s.ID),
+		Title:     title,
+		Summary:   s.Description,
+		Content:   s.Content,
+		Author:    author,
+		Tags:      s.Tags,
+		Published: parseTimestamp(s.CreatedAt),
+		Updated:   parseTimestamp(s.UpdatedAt),
+	}, nil
+}
+
+// parseTimestamp reads a timestamp column, which the database returns in
+// UTC. Unreadable values give the zero time.
+func parseTimestamp(s string) time.Time {
+	for _, layout := range []string{time.DateTime, time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// notModified evaluates the conditional GET headers of r against the
+// current entity tag and modification time of a resource. If-None-Match
+// takes precedence over If-Modified-Since, as RFC 9110 requires.
+func notModified(r *http.Request, current string, modified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == current {
+				return true
+			}
+		}
+		return false
+	}
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !modified.IsZero() {
+		since, err := http.ParseTime(header)
+		return err == nil && !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
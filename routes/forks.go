@@ -0,0 +1,236 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"snippetier/apperr"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/diff"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// errNoUpstream is returned for fork operations on a snippet that is not a
+// fork or whose upstream is gone.
+var errNoUpstream = apperr.New(apperr.ErrNotFound, "snippet has no upstream")
+
+// compareResponse describes how a fork differs from its upstream.
+type compareResponse struct {
+	UpstreamID       int `json:"upstreamId"`
+	UpstreamRevision int `json:"upstreamRevision"`
+	ForkedRevision   int `json:"forkedRevision"`
+	// Behind is set when upstream has revisions the fork has not pulled.
+	Behind bool `json:"behind"`
+	// Diff is a unified diff from the upstream content to the fork's.
+	Diff string `json:"diff"`
+}
+
+// pullRequest optionally resolves a conflicting pull by hand: Resolution is
+// stored as the merged content if upstream is still at UpstreamRevision.
+type pullRequest struct {
+	Resolution       *string `json:"resolution"`
+	UpstreamRevision int     `json:"upstreamRevision"`
+}
+
+// forkSnippet copies a snippet under the caller, keeping a link to it.
+func forkSnippet(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		fork, err := storage.SnippetsRepo.ForkSnippet(c.Request().Context(), userId, id)
+		if err != nil {
+			return err
+		}
+
+		c.Response().Header().Set(echo.HeaderLocation, "/api/snippets/"+strconv.Itoa(fork.ID))
+		return c.JSON(http.StatusCreated, fork)
+	}
+}
+
+// getSnippetForks lists the forks of a snippet.
+func getSnippetForks(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		if _, err := viewSnippet(c.Request().Context(), storage, userId, id); err != nil {
+			return err
+		}
+		forks, err := storage.SnippetsRepo.GetForks(c.Request().Context(), id)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, forks)
+	}
+}
+
+// compareWithUpstream diffs a fork against the current upstream content.
+func compareWithUpstream(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		fork, upstream, err := loadFork(c.Request().Context(), storage.SnippetsRepo, storage.OrgsRepo, userId, id)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, compareResponse{
+			UpstreamID:       upstream.ID,
+			UpstreamRevision: upstream.Revision,
+			ForkedRevision:   *fork.ForkedRevision,
+			Behind:           upstream.Revision > *fork.ForkedRevision,
+			Diff:             diff.Unified(upstream.Content, fork.Content, "upstream/"+upstream.Name, "fork/"+fork.Name, 3),
+		})
+	}
+}
+
+// pullUpstream three-way merges the upstream changes made since the fork
+// was last synced into the fork, using the synced upstream revision as the
+// base. A conflicting merge is not stored: the response carries the content
+// with conflict markers, and the client can send back a resolution. With
+// ?dryRun=true the merge is only previewed.
+func pullUpstream(storage *db.Storage, services *Services) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+		dryRun := c.QueryParam("dryRun") == "true"
+
+		var req pullRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+
+		ctx := c.Request().Context()
+		var updatedSnippet repo.Snippet
+		var preview map[string]interface{}
+		err = storage.WithTx(ctx, func(tx *db.Tx) error {
+			fork, upstream, err := loadFork(ctx, tx.SnippetsRepo, tx.OrgsRepo, userId, id)
+			if err != nil {
+				return err
+			}
+			if ok, err := canEditSnippet(ctx, tx.OrgsRepo, userId, fork); err != nil || !ok {
+				return orNotFound(err)
+			}
+			if err := checkIfMatch(c, etag(fork)); err != nil {
+				return err
+			}
+
+			content, conflicts := fork.Content, 0
+			switch {
+			case req.Resolution != nil:
+				if req.UpstreamRevision != upstream.Revision {
+					return newProblem(http.StatusConflict, "Upstream has changed since the conflict was resolved").
+						With("upstreamRevision", upstream.Revision)
+				}
+				content = *req.Resolution
+			case upstream.Revision != *fork.ForkedRevision:
+				base, err := tx.SnippetsRepo.GetRevision(ctx, upstream.ID, *fork.ForkedRevision)
+				if err != nil {
+					return err
+				}
+				content, conflicts = diff.Merge3(base.Content, fork.Content, upstream.Content, "fork", "upstream")
+			}
+
+			if dryRun {
+				preview = map[string]interface{}{
+					"changed":          content != fork.Content,
+					"conflicts":        conflicts,
+					"content":          content,
+					"upstreamRevision": upstream.Revision,
+				}
+				return nil
+			}
+			if conflicts > 0 {
+				return newProblem(http.StatusConflict, "Upstream changes conflict with the fork").
+					With("conflicts", conflicts).
+					With("content", content).
+					With("upstreamRevision", upstream.Revision)
+			}
+
+			update := snippetRequestFrom(fork)
+			update.Content = content
+			snippet, _, err := prepareSnippet(services, &update)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.SnippetsRepo.UpdateSnippet(ctx, userId, id, snippet); err != nil {
+				return err
+			}
+			if err := tx.SnippetsRepo.SetForkedRevision(ctx, userId, id, upstream.Revision); err != nil {
+				return err
+			}
+			stored, err := tx.SnippetsRepo.GetSnippetByID(ctx, id)
+			updatedSnippet = stored
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return c.JSON(http.StatusOK, preview)
+		}
+
+		c.Response().Header().Set(HeaderETag, etag(updatedSnippet))
+		return c.JSON(http.StatusOK, updatedSnippet)
+	}
+}
+
+// loadFork loads a fork the user may see and its upstream without consuming
+// either. Burn-after-read snippets on either side are treated as unlinked,
+// since comparing them would reveal their content, and so is an upstream
+// the user may not see.
+func loadFork(ctx context.Context, snippets *repo.SnippetsRepo, orgs *repo.OrgsRepo, userId, id int) (repo.Snippet, repo.Snippet, error) {
+	fork, err := snippets.GetSnippetByID(ctx, id)
+	if err != nil {
+		return repo.Snippet{}, repo.Snippet{}, err
+	}
+	if ok, err := canViewSnippet(ctx, orgs, userId, fork); err != nil || !ok {
+		return repo.Snippet{}, repo.Snippet{}, orNotFound(err)
+	}
+	if fork.ForkedFrom == nil || fork.ForkedRevision == nil || fork.BurnAfterRead {
+		return repo.Snippet{}, repo.Snippet{}, errNoUpstream
+	}
+
+	upstream, err := snippets.GetSnippetByID(ctx, *fork.ForkedFrom)
+	if errors.Is(err, repo.ErrSnippetNotFound) || (err == nil && upstream.BurnAfterRead) {
+		return repo.Snippet{}, repo.Snippet{}, errNoUpstream
+	}
+	if err != nil {
+		return repo.Snippet{}, repo.Snippet{}, err
+	}
+	if ok, err := canViewSnippet(ctx, orgs, userId, upstream); err != nil {
+		return repo.Snippet{}, repo.Snippet{}, err
+	} else if !ok {
+		return repo.Snippet{}, repo.Snippet{}, errNoUpstream
+	}
+	return fork, upstream, nil
+}
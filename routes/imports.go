@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"snippetier/importer"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxUploadSize caps the size of an import upload.
+const maxUploadSize = 10 << 20
+
+func SetupImportRoutes(g *echo.Group, services *Services) {
+	g.POST("", startImport(services))
+	g.GET("/:id", getImport(services))
+}
+
+// startImport accepts a multipart upload in the "file" field and queues it
+// for import. The format is taken from the optional "format" field or
+// guessed from the file name.
+func startImport(services *Services) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Missing file upload")
+		}
+		if fileHeader.Size > maxUploadSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Upload is too large")
+		}
+
+		format := importer.Format(c.FormValue("format"))
+		if format == "" {
+			format, err = importer.DetectFormat(fileHeader.Filename)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read upload")
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxUploadSize))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read upload")
+		}
+
+		items, err := importer.Parse(format, data)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+
+		job := services.Imports.Start(userId, items)
+		c.Response().Header().Set(echo.HeaderLocation, "/api/imports/"+job.ID)
+		return c.JSON(http.StatusAccepted, job)
+	}
+}
+
+// getImport reports the progress and per-item results of an import job.
+func getImport(services *Services) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		job, ok := services.Imports.Get(c.Param("id"))
+		if !ok || job.UserID != userId {
+			return echo.NewHTTPError(http.StatusNotFound, "Import not found")
+		}
+
+		return c.JSON(http.StatusOK, job)
+	}
+}
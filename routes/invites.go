@@ -0,0 +1,306 @@
+package routes
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"snippetier/apperr"
+	"snippetier/configs"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/invite"
+	"snippetier/validate"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// inviteCookie holds the token of an invitation link opened before logging
+// in, so that the OAuth callback can send the user back to it.
+const inviteCookie = "sn_invite"
+
+// SetupInviteRoutes sets up the API used to answer an invitation.
+func SetupInviteRoutes(g *echo.Group, storage *db.Storage, config *configs.Config) {
+	g.GET("/:token", getInviteByToken(storage, config))
+	g.POST("/:token/accept", respondToInvite(storage, config, true))
+	g.POST("/:token/decline", respondToInvite(storage, config, false))
+}
+
+// setupInvitePages sets up the page an invitation link opens.
+func setupInvitePages(g *echo.Group, storage *db.Storage, config *configs.Config) {
+	g.GET("/:token", invitePage(storage, config))
+}
+
+// inviteRequest is the body accepted when inviting someone to an
+// organization. Exactly one of Email and GithubUsername must be set.
+type inviteRequest struct {
+	Email          string `json:"email" validate:"max=254,email"`
+	GithubUsername string `json:"githubUsername" validate:"max=39,username"`
+	Role           string `json:"role"`
+}
+
+// inviteResponse is a new invitation with the link to send to its recipient.
+// The link is only ever returned here.
+type inviteResponse struct {
+	repo.Invite
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// inviteDetails is what the recipient of an invitation sees.
+type inviteDetails struct {
+	Invite repo.Invite `json:"invite"`
+	Org    repo.Org    `json:"org"`
+}
+
+// createInvite invites a user to an organization by email or GitHub
+// username. Who may invite to which role follows the rules of setOrgMember.
+func createInvite(storage *db.Storage, config *configs.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		if config.InviteSecret == "" {
+			return newProblem(http.StatusServiceUnavailable, "Invitations are not configured")
+		}
+
+		var req inviteRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		invalid := validate.Fields(&req)
+		if (req.Email == "") == (req.GithubUsername == "") {
+			invalid.Add("email", "either email or githubUsername is required")
+		}
+		role := repo.RoleMember
+		if req.Role != "" {
+			var ok bool
+			if role, ok = repo.ParseRole(req.Role); !ok {
+				invalid.Add("role", "must be owner, maintainer or member")
+			}
+		}
+		if err := invalid.Err(); err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		org, callerRole, err := orgMembership(ctx, storage.OrgsRepo, c.Param("org"), userId)
+		if err != nil {
+			return err
+		}
+		if err := canManage(callerRole, "", role); err != nil {
+			return err
+		}
+
+		nonce, err := invite.NewNonce()
+		if err != nil {
+			return err
+		}
+		expiresAt := time.Now().UTC().Add(config.InviteTTL).Truncate(time.Second)
+		created, err := storage.OrgsRepo.CreateInvite(ctx, repo.Invite{
+			OrgID:          org.ID,
+			Email:          req.Email,
+			GithubUsername: req.GithubUsername,
+			Role:           role,
+			InvitedBy:      userId,
+			ExpiresAt:      expiresAt.Format(time.DateTime),
+			TokenHash:      invite.HashNonce(nonce),
+		})
+		if err != nil {
+			return err
+		}
+
+		token := invite.Sign([]byte(config.InviteSecret), invite.Claims{InviteID: created.ID, ExpiresAt: expiresAt, Nonce: nonce})
+		return c.JSON(http.StatusCreated, inviteResponse{
+			Invite: created,
+			Token:  token,
+			URL:    c.Scheme() + "://" + c.Request().Host + "/invites/" + token,
+		})
+	}
+}
+
+// getOrgInvites lists an organization's invitations to its owners and
+// maintainers.
+func getOrgInvites(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		ctx := c.Request().Context()
+		org, err := orgManager(ctx, storage.OrgsRepo, c.Param("org"), userId)
+		if err != nil {
+			return err
+		}
+		invites, err := storage.OrgsRepo.GetInvites(ctx, org.ID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, invites)
+	}
+}
+
+// revokeInvite withdraws a pending invitation.
+func revokeInvite(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		inviteId, err := strconv.Atoi(c.Param("inviteId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid invitation ID")
+		}
+
+		ctx := c.Request().Context()
+		org, err := orgManager(ctx, storage.OrgsRepo, c.Param("org"), userId)
+		if err != nil {
+			return err
+		}
+		if err := storage.OrgsRepo.RevokeInvite(ctx, org.ID, inviteId, userId); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// getOrgAudit lists who invited whom to an organization and how each
+// invitation was answered.
+func getOrgAudit(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		ctx := c.Request().Context()
+		org, err := orgManager(ctx, storage.OrgsRepo, c.Param("org"), userId)
+		if err != nil {
+			return err
+		}
+		entries, err := storage.OrgsRepo.GetAuditLog(ctx, org.ID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, entries)
+	}
+}
+
+// getInviteByToken shows an invitation to whoever holds its link.
+func getInviteByToken(storage *db.Storage, config *configs.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		details, err := loadInvite(c.Request().Context(), storage, config, c.Param("token"))
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, details)
+	}
+}
+
+// respondToInvite accepts or declines an invitation on behalf of the user
+// it is addressed to. The link stops working afterwards.
+func respondToInvite(storage *db.Storage, config *configs.Config, accept bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		ctx := c.Request().Context()
+		details, err := loadInvite(ctx, storage, config, c.Param("token"))
+		if err != nil {
+			return err
+		}
+		answered, err := storage.OrgsRepo.RespondToInvite(ctx, details.Invite.ID, userId, accept)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, inviteDetails{Invite: answered, Org: details.Org})
+	}
+}
+
+// invitePage is where an invitation link leads. Visitors who are not
+// logged in are sent through the login first; the token is kept in a
+// cookie so that the OAuth callback can send them back here.
+func invitePage(storage *db.Storage, config *configs.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.Param("token")
+		if _, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader)); err != nil {
+			c.SetCookie(&http.Cookie{
+				Name:     inviteCookie,
+				Value:    token,
+				Path:     "/",
+				MaxAge:   int(time.Hour / time.Second),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			return c.Redirect(http.StatusSeeOther, "/auth/login")
+		}
+
+		details, err := loadInvite(c.Request().Context(), storage, config, token)
+		if err != nil {
+			return err
+		}
+		return c.Render(http.StatusOK, "invite", map[string]interface{}{
+			"Invite": details.Invite,
+			"Org":    details.Org,
+			"Token":  token,
+		})
+	}
+}
+
+// inviteRedirect returns where to send a user who just logged in: back to
+// the invitation link they opened before, if any. It clears the cookie.
+func inviteRedirect(c echo.Context) (string, bool) {
+	cookie, err := c.Cookie(inviteCookie)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	c.SetCookie(&http.Cookie{Name: inviteCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	return "/invites/" + cookie.Value, true
+}
+
+// loadInvite verifies an invitation token and loads the invitation and its
+// organization. Tokens that are forged, or no longer match their
+// invitation, are reported as not found.
+func loadInvite(ctx context.Context, storage *db.Storage, config *configs.Config, token string) (inviteDetails, error) {
+	if config.InviteSecret == "" {
+		return inviteDetails{}, repo.ErrInviteNotFound
+	}
+	claims, err := invite.Verify([]byte(config.InviteSecret), token, time.Now())
+	if errors.Is(err, invite.ErrExpired) {
+		return inviteDetails{}, newProblem(http.StatusGone, "The invitation has expired")
+	}
+	if err != nil {
+		return inviteDetails{}, repo.ErrInviteNotFound
+	}
+
+	found, err := storage.OrgsRepo.GetInvite(ctx, claims.InviteID)
+	if err != nil {
+		return inviteDetails{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(found.TokenHash), []byte(invite.HashNonce(claims.Nonce))) != 1 {
+		return inviteDetails{}, repo.ErrInviteNotFound
+	}
+	org, err := storage.OrgsRepo.GetOrgByID(ctx, found.OrgID)
+	if err != nil {
+		return inviteDetails{}, err
+	}
+	return inviteDetails{Invite: found, Org: org}, nil
+}
+
+// orgManager loads an organization the user is an owner or maintainer of.
+func orgManager(ctx context.Context, orgs *repo.OrgsRepo, slug string, userId int) (repo.Org, error) {
+	org, role, err := orgMembership(ctx, orgs, slug, userId)
+	if err != nil {
+		return repo.Org{}, err
+	}
+	if !role.CanEdit() {
+		return repo.Org{}, apperr.New(apperr.ErrForbidden, "only owners and maintainers can manage invitations")
+	}
+	return org, nil
+}
@@ -0,0 +1,205 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"snippetier/collab"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/secrets"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+// NewLiveManager returns the manager of live editing sessions, which
+// checkpoints them as snippet revisions every interval.
+func NewLiveManager(storage *db.Storage, scanner *secrets.Scanner, interval time.Duration) *collab.Manager {
+	return collab.NewManager(&snippetStore{storage: storage, scanner: scanner}, interval, repo.MaxContentBytes)
+}
+
+// snippetStore saves live editing sessions as snippet revisions, scanning
+// them for secrets like any other update.
+type snippetStore struct {
+	storage *db.Storage
+	scanner *secrets.Scanner
+}
+
+func (s *snippetStore) Load(ctx context.Context, snippetID int) (string, int, error) {
+	snippet, err := s.storage.SnippetsRepo.GetSnippetByID(ctx, snippetID)
+	if err != nil {
+		return "", 0, err
+	}
+	return snippet.Content, snippet.Revision, nil
+}
+
+func (s *snippetStore) Save(ctx context.Context, snippetID, userID, revision int, content string) (stored string, newRevision int, err error) {
+	err = s.storage.WithTx(ctx, func(tx *db.Tx) error {
+		snippet, err := tx.SnippetsRepo.GetSnippetByID(ctx, snippetID)
+		if err != nil {
+			return err
+		}
+		if snippet.Revision != revision {
+			return collab.ErrStale
+		}
+		if ok, err := canEditSnippet(ctx, tx.OrgsRepo, userID, snippet); err != nil || !ok {
+			return orNotFound(err)
+		}
+
+		checked, findings, ok := s.scanner.Check(content)
+		if !ok {
+			return errors.New("content appears to contain secrets (" + findings[0].Rule + ")")
+		}
+		snippet.Content = checked
+		updated, err := tx.SnippetsRepo.UpdateSnippet(ctx, userID, snippetID, snippet)
+		if err != nil {
+			return err
+		}
+		stored, newRevision = checked, updated.Revision
+		return nil
+	})
+	return stored, newRevision, err
+}
+
+func (s *snippetStore) CanEdit(ctx context.Context, snippetID, userID int) (bool, error) {
+	snippet, err := s.storage.SnippetsRepo.GetSnippetByID(ctx, snippetID)
+	if errors.Is(err, repo.ErrSnippetNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return canEditSnippet(ctx, s.storage.OrgsRepo, userID, snippet)
+}
+
+// liveRequest is a message from a live editing client: an "op" made on
+// Version of the document, or a "cursor" move.
+type liveRequest struct {
+	Type         string            `json:"type"`
+	Version      int               `json:"version"`
+	Op           *collab.Operation `json:"op"`
+	Cursor       int               `json:"cursor"`
+	SelectionEnd int               `json:"selectionEnd"`
+}
+
+// editLive joins the caller to the snippet's live editing session over a
+// WebSocket. Only those who may edit the snippet can join, and they are
+// disconnected once they no longer may. The document is saved as a new
+// revision periodically and when the last editor leaves.
+func editLive(storage *db.Storage, services *Services) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		ctx := c.Request().Context()
+		snippet, err := storage.SnippetsRepo.GetSnippetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if ok, err := canEditSnippet(ctx, storage.OrgsRepo, userId, snippet); !ok {
+			return orNotFound(err)
+		}
+		user, err := storage.UsersRepo.GetUserByID(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		client, err := services.Live.Join(ctx, id, userId, user.Username)
+		if err != nil {
+			return err
+		}
+		defer client.Leave()
+
+		server := websocket.Server{
+			Handshake: sameOrigin,
+			Handler: func(ws *websocket.Conn) {
+				defer ws.Close()
+
+				// Only the loop below writes; the reader hands it the errors
+				// to report.
+				closed := make(chan struct{})
+				errs := make(chan string, 16)
+				go func() {
+					defer close(closed)
+					for {
+						var data []byte
+						if err := websocket.Message.Receive(ws, &data); err != nil {
+							return
+						}
+						var req liveRequest
+						if err := json.Unmarshal(data, &req); err != nil {
+							reportLive(errs, "Invalid message: "+err.Error())
+							continue
+						}
+						var err error
+						switch {
+						case req.Type == "op" && req.Op != nil:
+							err = client.Edit(req.Version, req.Op)
+						case req.Type == "cursor":
+							err = client.MoveCursor(req.Version, req.Cursor, req.SelectionEnd)
+						default:
+							err = errors.New("unknown message type")
+						}
+						if err != nil {
+							reportLive(errs, err.Error())
+						}
+					}
+				}()
+
+				send := func(v any) error {
+					ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+					return websocket.JSON.Send(ws, v)
+				}
+				heartbeat := time.NewTicker(heartbeatInterval)
+				defer heartbeat.Stop()
+
+				for {
+					select {
+					case <-closed:
+						return
+					case msg, ok := <-client.Outbox():
+						if !ok {
+							return
+						}
+						if err := send(msg); err != nil {
+							return
+						}
+					case text := <-errs:
+						if err := send(collab.Message{Type: "error", Error: text}); err != nil {
+							return
+						}
+					case <-heartbeat.C:
+						ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+						ws.PayloadType = websocket.PingFrame
+						_, err := ws.Write(nil)
+						ws.PayloadType = websocket.TextFrame
+						if err != nil {
+							return
+						}
+					}
+				}
+			},
+		}
+		server.ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
+
+// reportLive queues an error for the client, dropping it if the client is
+// not reading them.
+func reportLive(errs chan<- string, text string) {
+	select {
+	case errs <- text:
+	default:
+	}
+}
@@ -0,0 +1,326 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"snippetier/apperr"
+	"snippetier/configs"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/validate"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+func SetupOrgRoutes(g *echo.Group, storage *db.Storage, config *configs.Config, services *Services) {
+	g.GET("", getMyOrgs(storage))
+	g.POST("", createOrg(storage))
+	g.GET("/:org", getOrg(storage))
+	g.GET("/:org/members", getOrgMembers(storage))
+	g.PUT("/:org/members/:userId", setOrgMember(storage))
+	g.DELETE("/:org/members/:userId", removeOrgMember(storage))
+	g.GET("/:org/snippets", getOrgSnippets(storage))
+	g.POST("/:org/snippets", saveOrgSnippet(storage, services))
+	g.POST("/:org/invites", createInvite(storage, config))
+	g.GET("/:org/invites", getOrgInvites(storage))
+	g.DELETE("/:org/invites/:inviteId", revokeInvite(storage))
+	g.GET("/:org/audit", getOrgAudit(storage))
+	g.GET("/:org/webhooks", getOrgWebhooks(storage))
+	g.POST("/:org/webhooks", createWebhook(storage, true))
+}
+
+// orgRequest is the body accepted when creating an organization.
+type orgRequest struct {
+	Slug string `json:"slug" validate:"required,min=2,max=39,username"`
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+// memberRequest is the body accepted when adding a member or changing
+// their role.
+type memberRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+var errNotMember = apperr.New(apperr.ErrForbidden, "organization members only")
+
+// getMyOrgs lists the organizations the caller is a member of.
+func getMyOrgs(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		orgs, err := storage.OrgsRepo.GetOrgsByUser(c.Request().Context(), userId)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, orgs)
+	}
+}
+
+// createOrg creates an organization owned by the caller.
+func createOrg(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		var req orgRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if err := validate.Struct(&req); err != nil {
+			return err
+		}
+
+		org, err := storage.OrgsRepo.CreateOrg(c.Request().Context(), userId, req.Slug, req.Name)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, org)
+	}
+}
+
+func getOrg(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		org, err := storage.OrgsRepo.GetOrg(c.Request().Context(), c.Param("org"))
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, org)
+	}
+}
+
+// getOrgMembers lists the members of an organization to its members.
+func getOrgMembers(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		org, _, err := orgMembership(c.Request().Context(), storage.OrgsRepo, c.Param("org"), userId)
+		if err != nil {
+			return err
+		}
+		members, err := storage.OrgsRepo.GetMembers(c.Request().Context(), org.ID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, members)
+	}
+}
+
+// setOrgMember adds a user to an organization or changes their role.
+// Maintainers may manage plain members; only owners may grant, change or
+// revoke the maintainer and owner roles.
+func setOrgMember(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		memberId, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid member ID")
+		}
+
+		var req memberRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if err := validate.Struct(&req); err != nil {
+			return err
+		}
+		role, ok := repo.ParseRole(req.Role)
+		if !ok {
+			return apperr.Invalid("role", "must be owner, maintainer or member")
+		}
+
+		ctx := c.Request().Context()
+		err = storage.WithTx(ctx, func(tx *db.Tx) error {
+			org, callerRole, err := orgMembership(ctx, tx.OrgsRepo, c.Param("org"), userId)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.UsersRepo.GetUserByID(ctx, memberId); err != nil {
+				return err
+			}
+			current, err := tx.OrgsRepo.GetRole(ctx, org.ID, memberId)
+			if err != nil && !errors.Is(err, repo.ErrMemberNotFound) {
+				return err
+			}
+			if err := canManage(callerRole, current, role); err != nil {
+				return err
+			}
+			return tx.OrgsRepo.SetMember(ctx, org.ID, memberId, role)
+		})
+		if err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// removeOrgMember removes a member from an organization. Any member may
+// leave; removing someone else follows the rules of setOrgMember.
+func removeOrgMember(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		memberId, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid member ID")
+		}
+
+		ctx := c.Request().Context()
+		err = storage.WithTx(ctx, func(tx *db.Tx) error {
+			org, callerRole, err := orgMembership(ctx, tx.OrgsRepo, c.Param("org"), userId)
+			if err != nil {
+				return err
+			}
+			if memberId != userId {
+				current, err := tx.OrgsRepo.GetRole(ctx, org.ID, memberId)
+				if err != nil {
+					return err
+				}
+				if err := canManage(callerRole, current, repo.RoleMember); err != nil {
+					return err
+				}
+			}
+			return tx.OrgsRepo.RemoveMember(ctx, org.ID, memberId)
+		})
+		if err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// canManage checks that a member with the caller's role may move another
+// member from the current role, empty for non-members, to the target role.
+func canManage(caller, current, target repo.Role) error {
+	if !caller.CanEdit() {
+		return apperr.New(apperr.ErrForbidden, "only owners and maintainers can manage members")
+	}
+	if caller != repo.RoleOwner && (current.CanEdit() || target.CanEdit()) {
+		return apperr.New(apperr.ErrForbidden, "only owners can manage owners and maintainers")
+	}
+	return nil
+}
+
+// getOrgSnippets lists and searches an organization's library for its
+// members. It takes the same parameters as the snippet search.
+func getOrgSnippets(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		filter, err := snippetFilter(c)
+		if err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		org, _, err := orgMembership(ctx, storage.OrgsRepo, c.Param("org"), userId)
+		if err != nil {
+			return err
+		}
+		filter.OrgID = org.ID
+
+		snippets, err := storage.SnippetsRepo.SearchSnippets(ctx, userId, filter)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, snippets)
+	}
+}
+
+// saveOrgSnippet adds a new snippet to an organization's library. Any
+// member may add snippets; the organization's maintainers can edit them.
+func saveOrgSnippet(storage *db.Storage, services *Services) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		var req snippetRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		snippet, findings, err := prepareSnippet(services, &req)
+		if err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		org, _, err := orgMembership(ctx, storage.OrgsRepo, c.Param("org"), userId)
+		if err != nil {
+			return err
+		}
+		snippet.OrgID = &org.ID
+
+		savedSnippet, err := storage.SnippetsRepo.SaveSnippet(ctx, userId, snippet)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, snippetResponse{Snippet: savedSnippet, SecretFindings: findings})
+	}
+}
+
+// orgMembership loads an organization and the user's role in it, failing
+// with a Forbidden error if they are not a member.
+func orgMembership(ctx context.Context, orgs *repo.OrgsRepo, slug string, userId int) (repo.Org, repo.Role, error) {
+	org, err := orgs.GetOrg(ctx, slug)
+	if err != nil {
+		return repo.Org{}, "", err
+	}
+	role, err := orgs.GetRole(ctx, org.ID, userId)
+	if errors.Is(err, repo.ErrMemberNotFound) {
+		return repo.Org{}, "", errNotMember
+	}
+	if err != nil {
+		return repo.Org{}, "", err
+	}
+	return org, role, nil
+}
+
+// canViewSnippet reports whether the user may see the snippet: it is
+// theirs, public, or in an organization they belong to.
+func canViewSnippet(ctx context.Context, orgs *repo.OrgsRepo, userId int, snippet repo.Snippet) (bool, error) {
+	if snippet.OrgID == nil || snippet.UserId == userId {
+		return true, nil
+	}
+	_, err := orgs.GetRole(ctx, *snippet.OrgID, userId)
+	if errors.Is(err, repo.ErrMemberNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// canEditSnippet reports whether the user owns the snippet or may edit it
+// as an owner or maintainer of its organization.
+func canEditSnippet(ctx context.Context, orgs *repo.OrgsRepo, userId int, snippet repo.Snippet) (bool, error) {
+	if snippet.UserId == userId {
+		return true, nil
+	}
+	if snippet.OrgID == nil {
+		return false, nil
+	}
+	role, err := orgs.GetRole(ctx, *snippet.OrgID, userId)
+	if errors.Is(err, repo.ErrMemberNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return role.CanEdit(), nil
+}
@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"snippetier/apperr"
+	"snippetier/jsonpatch"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	MIMEApplicationMergePatchJSON = "application/merge-patch+json"
+	MIMEApplicationJSONPatchJSON  = "application/json-patch+json"
+)
+
+// HeaderETag is the response header carrying a resource's entity tag.
+const HeaderETag = "ETag"
+
+// maxPatchSize caps the size of a PATCH body.
+const maxPatchSize = 1 << 20
+
+// etag returns a strong entity tag for the JSON representation of v, so
+// that any change to a stored field yields a new tag.
+func etag(v any) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// checkIfMatch enforces an If-Match precondition against the current entity
+// tag of the resource. Requests without If-Match are let through.
+func checkIfMatch(c echo.Context, current string) error {
+	header := c.Request().Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return nil
+		}
+	}
+	return echo.NewHTTPError(http.StatusPreconditionFailed, "The resource was modified since it was read")
+}
+
+// applyPatch applies the request body to the JSON form of current and
+// decodes the result into target. The body is a JSON Patch if sent as
+// application/json-patch+json and a JSON Merge Patch if sent as
+// application/merge-patch+json or plain application/json.
+func applyPatch(c echo.Context, current, target any) error {
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	var apply func(doc, patch []byte) ([]byte, error)
+	switch mediaType {
+	case MIMEApplicationJSONPatchJSON:
+		apply = jsonpatch.Apply
+	case MIMEApplicationMergePatchJSON, echo.MIMEApplicationJSON:
+		apply = jsonpatch.Merge
+	default:
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, "PATCH accepts "+MIMEApplicationMergePatchJSON+" or "+MIMEApplicationJSONPatchJSON)
+	}
+
+	patch, err := io.ReadAll(io.LimitReader(c.Request().Body, maxPatchSize+1))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to read request body")
+	}
+	if len(patch) > maxPatchSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Patch is too large")
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	patched, err := apply(doc, patch)
+	if errors.Is(err, jsonpatch.ErrInvalidPatch) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return apperr.Invalid(typeErr.Field, "has the wrong type")
+		}
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "The patched document is invalid: "+err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"snippetier/apperr"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/related"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultRelatedLimit = 5
+	maxRelatedLimit     = 20
+)
+
+// relatedSnippet is a suggested snippet with its similarity to the one
+// asked about, between 0 and 1.
+type relatedSnippet struct {
+	repo.Snippet
+	Score float64 `json:"score"`
+}
+
+// getRelatedSnippets suggests up to ?limit=<n> snippets similar to a
+// snippet, most similar first. Only snippets the caller may see are
+// suggested: public ones, their own and their organizations'.
+func getRelatedSnippets(storage *db.Storage, services *Services) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+		limit := defaultRelatedLimit
+		if v := c.QueryParam("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxRelatedLimit {
+				return apperr.Invalid("limit", "must be between 1 and "+strconv.Itoa(maxRelatedLimit))
+			}
+		}
+
+		ctx := c.Request().Context()
+		snippet, err := viewSnippet(ctx, storage, userId, id)
+		if err != nil {
+			return err
+		}
+
+		orgs, err := storage.OrgsRepo.GetOrgsByUser(ctx, userId)
+		if err != nil {
+			return err
+		}
+		orgIDs := make([]int, len(orgs))
+		for i, org := range orgs {
+			orgIDs[i] = org.ID
+		}
+		visible := func(owner related.Owner) bool {
+			return owner.OrgID == nil || owner.UserID == userId || slices.Contains(orgIDs, *owner.OrgID)
+		}
+
+		results := []relatedSnippet{}
+		for _, match := range services.Related.Related(snippet, limit, visible) {
+			s, err := storage.SnippetsRepo.GetSnippetByID(ctx, match.ID)
+			if errors.Is(err, repo.ErrSnippetNotFound) {
+				// Expired since it was indexed.
+				services.Related.Refresh(match.ID)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			results = append(results, relatedSnippet{Snippet: s, Score: match.Score})
+		}
+		return c.JSON(http.StatusOK, results)
+	}
+}
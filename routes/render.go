@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"snippetier/db"
+	"snippetier/placeholder"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// renderRequest carries the values for a snippet's template variables,
+// keyed by variable name or tabstop number.
+type renderRequest struct {
+	Values map[string]string `json:"values"`
+}
+
+// getSnippetVariables lists the placeholders a snippet declares. Their
+// defaults and choices are part of the content, so they are not listed for
+// burn-after-read snippets.
+func getSnippetVariables(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		snippet, err := viewSnippet(c.Request().Context(), storage, userId, id)
+		if err != nil {
+			return err
+		}
+		if snippet.BurnAfterRead {
+			return errBurnAfterRead
+		}
+
+		tmpl, err := placeholder.Parse(snippet.Content)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+
+		return c.JSON(http.StatusOK, tmpl.Variables())
+	}
+}
+
+// renderSnippet expands a snippet's placeholders with the given values.
+// Rendering reads the content, so it burns burn-after-read snippets, but
+// only once it succeeded: a request with missing values can be corrected
+// and sent again.
+func renderSnippet(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		var req renderRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+
+		ctx := c.Request().Context()
+		snippet, err := viewSnippet(ctx, storage, userId, id)
+		if err != nil {
+			return err
+		}
+		content, err := render(snippet.Content, req.Values)
+		if err != nil {
+			return err
+		}
+
+		if snippet.BurnAfterRead {
+			// Consume it now, and render what was consumed in case it was
+			// edited in between. Only one reader gets it.
+			if snippet, err = storage.SnippetsRepo.ReadSnippet(ctx, id); err != nil {
+				return err
+			}
+			if content, err = render(snippet.Content, req.Values); err != nil {
+				return err
+			}
+		}
+		return c.JSON(http.StatusOK, map[string]string{"content": content})
+	}
+}
+
+// render expands the placeholders of content with values.
+func render(content string, values map[string]string) (string, error) {
+	tmpl, err := placeholder.Parse(content)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	rendered, err := tmpl.Render(values)
+	var missing *placeholder.MissingError
+	if errors.As(err, &missing) {
+		return "", newProblem(http.StatusUnprocessableEntity, "Missing or invalid template values").With("fields", missing.Fields)
+	}
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to render snippet")
+	}
+	return rendered, nil
+}
@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"snippetier/db/repo"
+	"snippetier/validate"
+	"time"
+)
+
+// snippetRequest is the body accepted by the create and update endpoints.
+// Server-managed fields such as id, userId and timestamps are not part of
+// it, so clients cannot set them.
+type snippetRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Description   string   `json:"description" validate:"max=1000"`
+	Content       string   `json:"content" validate:"required"`
+	Language      string   `json:"language" validate:"max=32"`
+	Tags          []string `json:"tags" validate:"max=20"`
+	ExpiresAt     *string  `json:"expiresAt"`
+	BurnAfterRead bool     `json:"burnAfterRead"`
+	// FormatOnSave runs the language formatter over the content before it is stored.
+	FormatOnSave bool `json:"formatOnSave"`
+}
+
+// validate checks the request and normalizes its expiry for storage.
+func (r *snippetRequest) validate() error {
+	invalid := validate.Fields(r)
+	if len(r.Content) > repo.MaxContentBytes {
+		invalid.Add("content", fmt.Sprintf("must be at most %d bytes", repo.MaxContentBytes))
+	}
+	expiresAt, err := normalizeExpiresAt(r.ExpiresAt)
+	if err != nil {
+		invalid.Add("expiresAt", err.Error())
+	}
+	r.ExpiresAt = expiresAt
+	return invalid.Err()
+}
+
+func (r *snippetRequest) snippet() repo.Snippet {
+	return repo.Snippet{
+		Name:          r.Name,
+		Description:   r.Description,
+		Content:       r.Content,
+		Language:      r.Language,
+		Tags:          r.Tags,
+		ExpiresAt:     r.ExpiresAt,
+		BurnAfterRead: r.BurnAfterRead,
+	}
+}
+
+// snippetRequestFrom returns the request that would recreate snippet, the
+// document PATCH requests are applied to.
+func snippetRequestFrom(snippet repo.Snippet) snippetRequest {
+	req := snippetRequest{
+		Name:          snippet.Name,
+		Description:   snippet.Description,
+		Content:       snippet.Content,
+		Language:      snippet.Language,
+		Tags:          snippet.Tags,
+		BurnAfterRead: snippet.BurnAfterRead,
+	}
+	if snippet.ExpiresAt != nil {
+		expiresAt := *snippet.ExpiresAt
+		if t, err := time.Parse(time.DateTime, expiresAt); err == nil {
+			expiresAt = t.Format(time.RFC3339)
+		}
+		req.ExpiresAt = &expiresAt
+	}
+	return req
+}
+
+// userRequest is the body accepted by the user update endpoint.
+type userRequest struct {
+	Username string `json:"username" validate:"required,min=3,max=39,username"`
+	Email    string `json:"email" validate:"required,max=254,email"`
+	FullName string `json:"fullName" validate:"max=100"`
+}
+
+func userRequestFrom(user repo.User) userRequest {
+	return userRequest{Username: user.Username, Email: user.Email, FullName: user.FullName}
+}
+
+// validateUser checks the request and that its username and email are not
+// used by a user other than userId.
+func validateUser(ctx context.Context, users *repo.UsersRepo, userId int, req *userRequest) error {
+	invalid := validate.Fields(req)
+	if err := invalid.Err(); err != nil {
+		return err
+	}
+
+	taken, err := users.UsernameTaken(ctx, req.Username, userId)
+	if err != nil {
+		return err
+	}
+	if taken {
+		invalid.Add("username", "is already taken")
+	}
+
+	taken, err = users.EmailTaken(ctx, req.Email, userId)
+	if err != nil {
+		return err
+	}
+	if taken {
+		invalid.Add("email", "is already in use")
+	}
+	return invalid.Err()
+}
+
+// normalizeExpiresAt parses an RFC 3339 expiry from the request and converts
+// it to the UTC DATETIME format stored in the database.
+func normalizeExpiresAt(expiresAt *string) (*string, error) {
+	if expiresAt == nil || *expiresAt == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, *expiresAt)
+	if err != nil {
+		return nil, errors.New("must be an RFC 3339 timestamp")
+	}
+	if !t.After(time.Now()) {
+		return nil, errors.New("must be in the future")
+	}
+	normalized := t.UTC().Format(time.DateTime)
+	return &normalized, nil
+}
@@ -2,16 +2,32 @@ package routes
 
 import (
 	"net/http"
+	"snippetier/collab"
 	"snippetier/configs"
 	"snippetier/db"
+	"snippetier/events"
+	"snippetier/formatter"
+	"snippetier/importer"
+	"snippetier/related"
+	"snippetier/secrets"
 
 	"github.com/labstack/echo/v4"
 )
 
 const UserIdHeader = "sn-trusted-user-id"
 
+// Services bundles the application services that handlers need besides storage.
+type Services struct {
+	Scanner    *secrets.Scanner
+	Formatters *formatter.Registry
+	Imports    *importer.Manager
+	Events     *events.Hub
+	Live       *collab.Manager
+	Related    *related.Indexer
+}
+
 // SetupRoutes sets up all the routes for the application
-func SetupRoutes(e *echo.Echo, s *db.Storage, config *configs.Config) {
+func SetupRoutes(e *echo.Echo, s *db.Storage, config *configs.Config, services *Services) {
 
 	apiGroup := e.Group("api")
 
@@ -20,11 +36,42 @@ func SetupRoutes(e *echo.Echo, s *db.Storage, config *configs.Config) {
 	})
 
 	snippetsGroup := apiGroup.Group("/snippets")
-	SetupSnippetsRoutes(snippetsGroup, s)
+	SetupSnippetsRoutes(snippetsGroup, s, config, services)
+
+	importsGroup := apiGroup.Group("/imports")
+	SetupImportRoutes(importsGroup, services)
+
+	orgsGroup := apiGroup.Group("/orgs")
+	SetupOrgRoutes(orgsGroup, s, config, services)
+
+	invitesGroup := apiGroup.Group("/invites")
+	SetupInviteRoutes(invitesGroup, s, config)
+
+	collectionsGroup := apiGroup.Group("/collections")
+	SetupCollectionRoutes(collectionsGroup, s)
+
+	eventsGroup := apiGroup.Group("/events")
+	SetupEventRoutes(eventsGroup, s, services)
+
+	webhooksGroup := apiGroup.Group("/webhooks")
+	SetupWebhookRoutes(webhooksGroup, s)
+
+	searchesGroup := apiGroup.Group("/searches")
+	SetupSearchRoutes(searchesGroup, s)
+
+	apiGroup.GET("/feed", getFeed(s))
 
 	usersGroup := apiGroup.Group("/users")
 	SetupUserRoutes(usersGroup, s)
 
+	adminGroup := apiGroup.Group("/admin")
+	SetupAdminRoutes(adminGroup, s, config)
+
 	authGroup := e.Group("/auth")
 	setupAuthRoutes(authGroup, s, config)
+
+	invitePagesGroup := e.Group("/invites")
+	setupInvitePages(invitePagesGroup, s, config)
+
+	setupFeedRoutes(e, s)
 }
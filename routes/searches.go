@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"net/http"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/validate"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+func SetupSearchRoutes(g *echo.Group, storage *db.Storage) {
+	g.GET("", getMySearches(storage))
+	g.POST("", createSearch(storage))
+	g.DELETE("/:id", deleteSearch(storage))
+}
+
+// searchRequest is the body accepted when saving a search. Its fields are
+// the q, language and tag parameters of /api/snippets/search.
+type searchRequest struct {
+	Name     string `json:"name" validate:"required,max=100"`
+	Query    string `json:"query" validate:"max=200"`
+	Language string `json:"language" validate:"max=50"`
+	Tag      string `json:"tag" validate:"max=50"`
+}
+
+// getMySearches lists the caller's saved searches.
+func getMySearches(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		searches, err := storage.SearchesRepo.GetSearchesByUser(c.Request().Context(), userId)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, searches)
+	}
+}
+
+// createSearch saves a search for the caller. Its results can then be
+// followed at /searches/:id/feed.atom, .rss or .json.
+func createSearch(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		var req searchRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if err := validate.Struct(&req); err != nil {
+			return err
+		}
+
+		search, err := storage.SearchesRepo.CreateSearch(c.Request().Context(), repo.SavedSearch{
+			UserID:   userId,
+			Name:     req.Name,
+			Query:    req.Query,
+			Language: req.Language,
+			Tag:      strings.ToLower(req.Tag),
+		})
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, search)
+	}
+}
+
+func deleteSearch(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid saved search ID")
+		}
+
+		if err := storage.SearchesRepo.DeleteSearch(c.Request().Context(), userId, id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
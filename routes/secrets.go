@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"net/http"
+	"snippetier/db/repo"
+	"snippetier/secrets"
+)
+
+// snippetResponse is a snippet together with any secrets the scanner
+// flagged while saving it in warn or redact mode.
+type snippetResponse struct {
+	repo.Snippet
+	SecretFindings []secrets.Finding `json:"secretFindings,omitempty"`
+	// Duplicates lists the snippets the new one likely duplicates.
+	Duplicates []repo.Duplicate `json:"duplicates,omitempty"`
+}
+
+func secretsRejected(findings []secrets.Finding) error {
+	return newProblem(http.StatusUnprocessableEntity, "Snippet content appears to contain secrets").With("findings", findings)
+}
@@ -1,88 +1,428 @@
 package routes
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"snippetier/apperr"
+	"snippetier/configs"
 	"snippetier/db"
 	"snippetier/db/repo"
+	"snippetier/diff"
+	"snippetier/secrets"
 	"strconv"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupSnippetsRoutes(g *echo.Group, storage *db.Storage) {
+func SetupSnippetsRoutes(g *echo.Group, storage *db.Storage, config *configs.Config, services *Services) {
+	g.Use(followRedirects(storage))
 	g.GET("", getAllSnippets(storage))
-	g.POST("/new", saveSnippet(storage))
-	g.PUT("/:id", updateSnippet(storage))
+	g.GET("/search", searchSnippets(storage))
+	g.GET("/export/:format", exportSnippets(storage))
+	g.POST("/new", saveSnippet(storage, services))
+	g.GET("/:id", getSnippet(storage))
+	g.GET("/:id/raw", getRawSnippet(storage))
+	g.GET("/:id/revisions", getSnippetRevisions(storage))
+	g.POST("/:id/format", formatSnippet(storage, services))
+	g.GET("/:id/variables", getSnippetVariables(storage))
+	g.POST("/:id/render", renderSnippet(storage))
+	g.PUT("/:id", updateSnippet(storage, services))
+	g.PATCH("/:id", patchSnippet(storage, services))
+	g.GET("/:id/live", editLive(storage, services))
+	g.GET("/:id/related", getRelatedSnippets(storage, services))
 	g.DELETE("/:id", deleteSnippet(storage))
+	g.PUT("/:id/star", starSnippet(storage))
+	g.DELETE("/:id/star", unstarSnippet(storage))
+	g.POST("/:id/fork", forkSnippet(storage))
+	g.GET("/:id/forks", getSnippetForks(storage))
+	g.GET("/:id/compare", compareWithUpstream(storage))
+	g.POST("/:id/pull", pullUpstream(storage, services))
+	g.GET("/:id/comments", getComments(storage))
+	g.POST("/:id/comments", addComment(storage))
+	g.PUT("/:id/comments/:commentId", updateComment(storage))
+	g.DELETE("/:id/comments/:commentId", deleteComment(storage, config))
 }
 
 func getAllSnippets(storage *db.Storage) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		snippets, _ := storage.SnippetsRepo.GetAllSnippets()
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		sort, err := repo.ParseSnippetSort(c.QueryParam("sort"))
+		if err != nil {
+			return apperr.Invalid("sort", "must be updated or stars")
+		}
+
+		snippets, err := storage.SnippetsRepo.GetAllSnippets(c.Request().Context(), userId, sort)
+		if err != nil {
+			return err
+		}
 		return c.JSON(http.StatusOK, snippets)
 	}
 }
 
-func saveSnippet(storage *db.Storage) echo.HandlerFunc {
+func saveSnippet(storage *db.Storage, services *Services) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		parsedUserId := c.Request().Header.Get(UserIdHeader)
 		userId, err := strconv.Atoi(parsedUserId)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		var req snippetRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		snippet, findings, err := prepareSnippet(services, &req)
+		if err != nil {
+			return err
+		}
+
+		savedSnippet, err := storage.SnippetsRepo.SaveSnippet(c.Request().Context(), userId, snippet)
+		if err != nil {
+			return err
 		}
 
-		var snippet repo.Snippet
-		if err := c.Bind(&snippet); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		// The snippet is saved either way: failing to look for duplicates
+		// only leaves out the warning.
+		duplicates, err := storage.SnippetsRepo.FindDuplicates(c.Request().Context(), userId, savedSnippet.ID)
+		if err != nil {
+			log.Println("Error finding duplicates of new snippet:", err)
 		}
 
-		savedSnippet, err := storage.SnippetsRepo.SaveSnippet(userId, snippet.Name, snippet.Description, snippet.Content)
+		return c.JSON(http.StatusCreated, snippetResponse{Snippet: savedSnippet, SecretFindings: findings, Duplicates: duplicates})
+	}
+}
+
+// prepareSnippet validates a create, update or patch request and turns it
+// into the snippet to store, formatting and scanning its content on the way.
+func prepareSnippet(services *Services, req *snippetRequest) (repo.Snippet, []secrets.Finding, error) {
+	if err := req.validate(); err != nil {
+		return repo.Snippet{}, nil, err
+	}
+	snippet := req.snippet()
+
+	if req.FormatOnSave {
+		var err error
+		snippet.Content, err = services.Formatters.Format(snippet.Language, snippet.Content)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save snippet"})
+			return repo.Snippet{}, nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Failed to format snippet: "+err.Error())
 		}
+	}
 
-		return c.JSON(http.StatusCreated, savedSnippet)
+	content, findings, ok := services.Scanner.Check(snippet.Content)
+	if !ok {
+		return repo.Snippet{}, nil, secretsRejected(findings)
 	}
+	snippet.Content = content
+	return snippet, findings, nil
 }
 
-func updateSnippet(storage *db.Storage) echo.HandlerFunc {
+// getSnippet returns a single snippet as JSON. Reading a burn-after-read
+// snippet here deletes it.
+func getSnippet(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		ctx := c.Request().Context()
+		if _, err := viewSnippet(ctx, storage, userId, id); err != nil {
+			return err
+		}
+		snippet, err := storage.SnippetsRepo.ReadSnippet(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		c.Response().Header().Set(HeaderETag, etag(snippet))
+		return c.JSON(http.StatusOK, snippet)
+	}
+}
+
+// getRawSnippet returns only the snippet content as plain text. Reading a
+// burn-after-read snippet here deletes it.
+func getRawSnippet(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		ctx := c.Request().Context()
+		if _, err := viewSnippet(ctx, storage, userId, id); err != nil {
+			return err
+		}
+		snippet, err := storage.SnippetsRepo.ReadSnippet(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return c.String(http.StatusOK, snippet.Content)
+	}
+}
+
+// updateSnippet replaces every editable field of a snippet. With If-Match
+// the update only goes through if the snippet still has that entity tag.
+func updateSnippet(storage *db.Storage, services *Services) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		parsedUserId := c.Request().Header.Get(UserIdHeader)
 		userId, err := strconv.Atoi(parsedUserId)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
 		}
 		parsedSnippetID := c.Param("id")
 		snippetID, err := strconv.Atoi(parsedSnippetID)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid snippet ID"})
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		var req snippetRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		snippet, findings, err := prepareSnippet(services, &req)
+		if err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		var updatedSnippet repo.Snippet
+		err = storage.WithTx(ctx, func(tx *db.Tx) error {
+			if _, err := ownSnippet(c, tx, userId, snippetID); err != nil {
+				return err
+			}
+			stored, err := storeSnippet(c, tx, userId, snippetID, snippet)
+			updatedSnippet = stored
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		c.Response().Header().Set(HeaderETag, etag(updatedSnippet))
+		return c.JSON(http.StatusOK, snippetResponse{Snippet: updatedSnippet, SecretFindings: findings})
+	}
+}
+
+// patchSnippet applies a JSON Merge Patch or JSON Patch to the editable
+// fields of a snippet. With If-Match the patch only applies if the snippet
+// still has that entity tag.
+func patchSnippet(storage *db.Storage, services *Services) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		snippetID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
 		}
 
-		var snippet repo.Snippet
-		if err := c.Bind(&snippet); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		ctx := c.Request().Context()
+		var updatedSnippet repo.Snippet
+		var findings []secrets.Finding
+		err = storage.WithTx(ctx, func(tx *db.Tx) error {
+			current, err := ownSnippet(c, tx, userId, snippetID)
+			if err != nil {
+				return err
+			}
+
+			var req snippetRequest
+			if err := applyPatch(c, snippetRequestFrom(current), &req); err != nil {
+				return err
+			}
+			var snippet repo.Snippet
+			snippet, findings, err = prepareSnippet(services, &req)
+			if err != nil {
+				return err
+			}
+
+			updatedSnippet, err = storeSnippet(c, tx, userId, snippetID, snippet)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		c.Response().Header().Set(HeaderETag, etag(updatedSnippet))
+		return c.JSON(http.StatusOK, snippetResponse{Snippet: updatedSnippet, SecretFindings: findings})
+	}
+}
+
+// ownSnippet loads a snippet the user may edit and checks the request's
+// If-Match precondition against it.
+func ownSnippet(c echo.Context, tx *db.Tx, userId, id int) (repo.Snippet, error) {
+	snippet, err := tx.SnippetsRepo.GetSnippetByID(c.Request().Context(), id)
+	if err != nil {
+		return repo.Snippet{}, err
+	}
+	if ok, err := canEditSnippet(c.Request().Context(), tx.OrgsRepo, userId, snippet); err != nil || !ok {
+		return repo.Snippet{}, orNotFound(err)
+	}
+	if err := checkIfMatch(c, etag(snippet)); err != nil {
+		return repo.Snippet{}, err
+	}
+	return snippet, nil
+}
+
+// viewSnippet loads a snippet the user may see without consuming it. Other
+// snippets are reported as not found.
+func viewSnippet(ctx context.Context, storage *db.Storage, userId, id int) (repo.Snippet, error) {
+	snippet, err := storage.SnippetsRepo.GetSnippetByID(ctx, id)
+	if err != nil {
+		return repo.Snippet{}, err
+	}
+	if ok, err := canViewSnippet(ctx, storage.OrgsRepo, userId, snippet); err != nil || !ok {
+		return repo.Snippet{}, orNotFound(err)
+	}
+	return snippet, nil
+}
+
+// orNotFound hides a snippet the caller may not see or edit behind
+// ErrSnippetNotFound, unless checking the permission itself failed.
+func orNotFound(err error) error {
+	if err != nil {
+		return err
+	}
+	return repo.ErrSnippetNotFound
+}
+
+// storeSnippet updates a snippet and reads it back, so that the response
+// and its entity tag reflect the stored timestamps.
+func storeSnippet(c echo.Context, tx *db.Tx, userId, id int, snippet repo.Snippet) (repo.Snippet, error) {
+	ctx := c.Request().Context()
+	if _, err := tx.SnippetsRepo.UpdateSnippet(ctx, userId, id, snippet); err != nil {
+		return repo.Snippet{}, err
+	}
+	return tx.SnippetsRepo.GetSnippetByID(ctx, id)
+}
+
+// errBurnAfterRead refuses reads that would reveal the content of a
+// burn-after-read snippet without consuming it.
+var errBurnAfterRead = apperr.New(apperr.ErrConflict, "burn-after-read snippets can only be read once")
+
+// getSnippetRevisions lists the content history of a snippet, newest first,
+// followed by the histories of the duplicates merged into it. The history of
+// a burn-after-read snippet is not listed, since it holds its content.
+func getSnippetRevisions(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
 		}
 
-		updatedSnippet, err := storage.SnippetsRepo.UpdateSnippet(userId, snippetID, snippet.Name, snippet.Description, snippet.Content)
+		snippet, err := viewSnippet(c.Request().Context(), storage, userId, id)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update snippet"})
+			return err
+		}
+		if snippet.BurnAfterRead {
+			return errBurnAfterRead
+		}
+
+		revisions, err := storage.SnippetsRepo.GetRevisions(c.Request().Context(), id)
+		if err != nil {
+			return err
+		}
+		merged, err := storage.SnippetsRepo.GetMergedRevisions(c.Request().Context(), id)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, append(revisions, merged...))
+	}
+}
+
+// formatSnippet runs the language formatter over a snippet and stores the
+// result as a new revision. With ?dryRun=true it only returns a unified diff
+// of what would change.
+func formatSnippet(storage *db.Storage, services *Services) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+		dryRun := c.QueryParam("dryRun") == "true"
+
+		snippet, err := storage.SnippetsRepo.GetSnippetByID(c.Request().Context(), id)
+		if err != nil {
+			return err
+		}
+		if ok, err := canEditSnippet(c.Request().Context(), storage.OrgsRepo, userId, snippet); err != nil || !ok {
+			return orNotFound(err)
+		}
+
+		formatted, err := services.Formatters.Format(snippet.Language, snippet.Content)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Failed to format snippet: "+err.Error())
+		}
+
+		if dryRun {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"changed": formatted != snippet.Content,
+				"diff":    diff.Unified(snippet.Content, formatted, "a/"+snippet.Name, "b/"+snippet.Name, 3),
+			})
+		}
+
+		if formatted == snippet.Content {
+			return c.JSON(http.StatusOK, snippet)
+		}
+
+		snippet.Content = formatted
+		updatedSnippet, err := storage.SnippetsRepo.UpdateSnippet(c.Request().Context(), userId, id, snippet)
+		if err != nil {
+			return err
 		}
 
 		return c.JSON(http.StatusOK, updatedSnippet)
 	}
 }
 
+// deleteSnippet deletes a snippet on behalf of its owner or, for a snippet
+// in an organization's library, one of its owners or maintainers.
 func deleteSnippet(storage *db.Storage) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
 		snippetID := c.Param("id")
 		id, err := strconv.Atoi(snippetID)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid snippet ID"})
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		ctx := c.Request().Context()
+		snippet, err := storage.SnippetsRepo.GetSnippetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if ok, err := canEditSnippet(ctx, storage.OrgsRepo, userId, snippet); err != nil || !ok {
+			return orNotFound(err)
 		}
 
-		err = storage.SnippetsRepo.DeleteSnippet(id)
+		err = storage.SnippetsRepo.DeleteSnippet(ctx, userId, id)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete snippet"})
+			return err
 		}
 
 		return c.NoContent(http.StatusNoContent)
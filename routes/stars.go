@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"net/http"
+	"snippetier/db"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// starSnippet stars a snippet for the caller. Starring twice is a no-op.
+func starSnippet(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		if err := storage.SnippetsRepo.StarSnippet(c.Request().Context(), userId, id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// unstarSnippet removes the caller's star from a snippet.
+func unstarSnippet(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid snippet ID")
+		}
+
+		if err := storage.SnippetsRepo.UnstarSnippet(c.Request().Context(), userId, id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// getUserMeStars lists the snippets the caller starred.
+func getUserMeStars(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		snippets, err := storage.SnippetsRepo.GetStarredSnippets(c.Request().Context(), userId)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, snippets)
+	}
+}
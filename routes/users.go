@@ -1,7 +1,10 @@
 package routes
 
 import (
+	"log"
 	"net/http"
+	"snippetier/apperr"
+	"snippetier/backup"
 	"snippetier/db"
 	"snippetier/db/repo"
 	"strconv"
@@ -11,8 +14,15 @@ import (
 
 func SetupUserRoutes(g *echo.Group, s *db.Storage) {
 	g.GET("/me", getUserMe(s))
+	g.GET("/me/export", exportUserMe(s))
+	g.GET("/me/stars", getUserMeStars(s))
 	g.GET("/:id", getUserById(s))
 	g.PUT("/:id", updateUser(s))
+	g.PATCH("/:id", patchUser(s))
+	g.PUT("/:id/follow", followUser(s))
+	g.DELETE("/:id/follow", unfollowUser(s))
+	g.GET("/:id/followers", getFollowers(s, false))
+	g.GET("/:id/following", getFollowers(s, true))
 }
 
 // getUserById retrieves a user by ID and returns it.
@@ -21,14 +31,15 @@ func getUserById(storage *db.Storage) echo.HandlerFunc {
 		userID := c.Param("id")
 		id, err := strconv.Atoi(userID)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
 		}
 
-		user, err := storage.UsersRepo.GetUserByID(id)
+		user, err := storage.UsersRepo.GetUserByID(c.Request().Context(), id)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve user"})
+			return err
 		}
 
+		c.Response().Header().Set(HeaderETag, etag(user))
 		return c.JSON(http.StatusOK, user)
 	}
 }
@@ -38,37 +49,155 @@ func getUserMe(storage *db.Storage) echo.HandlerFunc {
 		userID := c.Request().Header.Get(UserIdHeader)
 		id, err := strconv.Atoi(userID)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
 		}
 
-		user, err := storage.UsersRepo.GetUserByID(id)
+		user, err := storage.UsersRepo.GetUserByID(c.Request().Context(), id)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve user"})
+			return err
 		}
 
+		c.Response().Header().Set(HeaderETag, etag(user))
 		return c.JSON(http.StatusOK, user)
 	}
 }
 
-// updateUser updates an existing user and returns the updated user.
+// exportUserMe streams a backup archive of everything the caller owns.
+func exportUserMe(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Request().Header.Get(UserIdHeader)
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		account, err := backup.Collect(c.Request().Context(), storage, id)
+		if err != nil {
+			return err
+		}
+
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, "application/zip")
+		res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="snippetier-export-`+strconv.Itoa(id)+`.zip"`)
+		res.WriteHeader(http.StatusOK)
+
+		// The status line is already sent, so a failure here can only be logged.
+		if err := backup.Write(res, account); err != nil {
+			log.Println("Error writing account export:", err)
+		}
+		return nil
+	}
+}
+
+// updateUser replaces every editable field of a user and returns the updated
+// user. With If-Match the update only goes through if the user still has
+// that entity tag.
 func updateUser(storage *db.Storage) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		userID := c.Param("id")
 		id, err := strconv.Atoi(userID)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		if err := ownAccount(c, id); err != nil {
+			return err
 		}
 
-		var user repo.User
-		if err := c.Bind(&user); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		var req userRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
 		}
 
-		updatedUser, err := storage.UsersRepo.UpdateUser(id, user.Username, user.Email, user.FullName)
+		var updatedUser repo.User
+		err = storage.WithTx(c.Request().Context(), func(tx *db.Tx) error {
+			if _, err := currentUser(c, tx, id); err != nil {
+				return err
+			}
+			stored, err := storeUser(c, tx, id, &req)
+			updatedUser = stored
+			return err
+		})
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+			return err
 		}
 
+		c.Response().Header().Set(HeaderETag, etag(updatedUser))
 		return c.JSON(http.StatusOK, updatedUser)
 	}
 }
+
+// patchUser applies a JSON Merge Patch or JSON Patch to the editable fields
+// of a user. With If-Match the patch only applies if the user still has that
+// entity tag.
+func patchUser(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+		if err := ownAccount(c, id); err != nil {
+			return err
+		}
+
+		var updatedUser repo.User
+		err = storage.WithTx(c.Request().Context(), func(tx *db.Tx) error {
+			current, err := currentUser(c, tx, id)
+			if err != nil {
+				return err
+			}
+
+			var req userRequest
+			if err := applyPatch(c, userRequestFrom(current), &req); err != nil {
+				return err
+			}
+			stored, err := storeUser(c, tx, id, &req)
+			updatedUser = stored
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		c.Response().Header().Set(HeaderETag, etag(updatedUser))
+		return c.JSON(http.StatusOK, updatedUser)
+	}
+}
+
+var errNotOwnAccount = apperr.New(apperr.ErrForbidden, "users can only change their own account")
+
+// ownAccount checks that the caller is user id. Invitations are matched on
+// username and email, so changing them for someone else would also let the
+// caller accept invitations meant for that user.
+func ownAccount(c echo.Context, id int) error {
+	callerID, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	if callerID != id {
+		return errNotOwnAccount
+	}
+	return nil
+}
+
+// currentUser loads a user and checks the request's If-Match precondition
+// against it.
+func currentUser(c echo.Context, tx *db.Tx, id int) (repo.User, error) {
+	user, err := tx.UsersRepo.GetUserByID(c.Request().Context(), id)
+	if err != nil {
+		return repo.User{}, err
+	}
+	if err := checkIfMatch(c, etag(user)); err != nil {
+		return repo.User{}, err
+	}
+	return user, nil
+}
+
+// storeUser validates req and writes it to the user.
+func storeUser(c echo.Context, tx *db.Tx, id int, req *userRequest) (repo.User, error) {
+	ctx := c.Request().Context()
+	if err := validateUser(ctx, tx.UsersRepo, id, req); err != nil {
+		return repo.User{}, err
+	}
+	return tx.UsersRepo.UpdateUser(ctx, id, req.Username, req.Email, req.FullName)
+}
@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"snippetier/db"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestUpdateOtherUserForbidden(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	// The check comes before any query, so the storage is never used.
+	SetupUserRoutes(e.Group("/api/users"), &db.Storage{})
+
+	for _, tt := range []struct {
+		method, contentType, body string
+	}{
+		{http.MethodPut, echo.MIMEApplicationJSON, `{"username":"mallory","email":"victim@example.com","fullName":"Mallory"}`},
+		{http.MethodPatch, MIMEApplicationMergePatchJSON, `{"email":"victim@example.com"}`},
+		{http.MethodPatch, MIMEApplicationJSONPatchJSON, `[{"op":"replace","path":"/username","value":"mallory"}]`},
+	} {
+		req := httptest.NewRequest(tt.method, "/api/users/2", strings.NewReader(tt.body))
+		req.Header.Set(echo.HeaderContentType, tt.contentType)
+		req.Header.Set(UserIdHeader, "1")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s by user 1 = %d, want %d", tt.method, tt.contentType, rec.Code, http.StatusForbidden)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"snippetier/apperr"
+	"snippetier/db"
+	"snippetier/db/repo"
+	"snippetier/validate"
+	"snippetier/webhook"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// deliveryLogSize is how many of a webhook's latest deliveries are listed.
+const deliveryLogSize = 100
+
+func SetupWebhookRoutes(g *echo.Group, storage *db.Storage) {
+	g.GET("", getMyWebhooks(storage))
+	g.POST("", createWebhook(storage, false))
+	g.GET("/:id", getWebhook(storage))
+	g.PUT("/:id", updateWebhook(storage))
+	g.DELETE("/:id", deleteWebhook(storage))
+	g.GET("/:id/deliveries", getDeliveries(storage))
+	g.GET("/:id/deliveries/:deliveryId", getDelivery(storage))
+	g.POST("/:id/deliveries/:deliveryId/redeliver", redeliver(storage))
+}
+
+// webhookRequest is the body accepted when creating or updating a webhook.
+// Active defaults to true.
+type webhookRequest struct {
+	URL    string   `json:"url" validate:"required,max=2000"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+func (r *webhookRequest) validate() error {
+	invalid := validate.Fields(r)
+	if u, err := url.Parse(r.URL); r.URL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		invalid.Add("url", "must be an absolute http or https URL")
+	} else if r.URL != "" && webhook.ForbiddenHost(u.Hostname()) {
+		invalid.Add("url", "must not point at a loopback, link-local or private address")
+	}
+	if len(r.Events) == 0 {
+		invalid.Add("events", "must list at least one event")
+	}
+	for _, event := range r.Events {
+		if !slices.Contains(repo.WebhookEvents, event) {
+			invalid.Add("events", "unknown event "+strconv.Quote(event))
+			break
+		}
+	}
+	return invalid.Err()
+}
+
+func (r *webhookRequest) webhook() repo.Webhook {
+	var events []string
+	for _, event := range repo.WebhookEvents {
+		if slices.Contains(r.Events, event) {
+			events = append(events, event)
+		}
+	}
+	return repo.Webhook{URL: r.URL, Events: events, Active: r.Active == nil || *r.Active}
+}
+
+// createdWebhook is a new webhook together with its secret, which is not
+// shown again.
+type createdWebhook struct {
+	repo.Webhook
+	Secret string `json:"secret"`
+}
+
+// getMyWebhooks lists the caller's own webhooks.
+func getMyWebhooks(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		hooks, err := storage.WebhooksRepo.GetWebhooksByUser(c.Request().Context(), userId)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, hooks)
+	}
+}
+
+// getOrgWebhooks lists an organization's webhooks to its owners and
+// maintainers.
+func getOrgWebhooks(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		ctx := c.Request().Context()
+		org, err := webhookOrg(ctx, storage.OrgsRepo, c.Param("org"), userId)
+		if err != nil {
+			return err
+		}
+		hooks, err := storage.WebhooksRepo.GetWebhooksByOrg(ctx, org.ID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, hooks)
+	}
+}
+
+// createWebhook subscribes a new webhook to events on the caller's snippets
+// or, with forOrg, on the library of the organization in the path. The
+// response carries the signing secret, which is not shown again.
+func createWebhook(storage *db.Storage, forOrg bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, err := strconv.Atoi(c.Request().Header.Get(UserIdHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+		}
+
+		var req webhookRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		hook := req.webhook()
+		hook.CreatedBy = userId
+		if forOrg {
+			org, err := webhookOrg(ctx, storage.OrgsRepo, c.Param("org"), userId)
+			if err != nil {
+				return err
+			}
+			hook.OrgID = &org.ID
+		} else {
+			hook.UserID = &userId
+		}
+
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		hook.Secret = hex.EncodeToString(b)
+
+		created, err := storage.WebhooksRepo.CreateWebhook(ctx, hook)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, createdWebhook{Webhook: created, Secret: created.Secret})
+	}
+}
+
+func getWebhook(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := webhookParams(c)
+		if err != nil {
+			return err
+		}
+
+		hook, err := manageableWebhook(c.Request().Context(), storage, userId, id)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, hook)
+	}
+}
+
+// updateWebhook changes a webhook's URL, events and active flag. Its
+// secret stays the same.
+func updateWebhook(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := webhookParams(c)
+		if err != nil {
+			return err
+		}
+
+		var req webhookRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		if _, err := manageableWebhook(ctx, storage, userId, id); err != nil {
+			return err
+		}
+		hook, err := storage.WebhooksRepo.UpdateWebhook(ctx, id, req.webhook())
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, hook)
+	}
+}
+
+// deleteWebhook deletes a webhook. Its pending deliveries are dropped.
+func deleteWebhook(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := webhookParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		if _, err := manageableWebhook(ctx, storage, userId, id); err != nil {
+			return err
+		}
+		if err := storage.WebhooksRepo.DeleteWebhook(ctx, id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// getDeliveries lists a webhook's latest deliveries, newest first.
+func getDeliveries(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := webhookParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		if _, err := manageableWebhook(ctx, storage, userId, id); err != nil {
+			return err
+		}
+		deliveries, err := storage.WebhooksRepo.GetDeliveries(ctx, id, deliveryLogSize)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, deliveries)
+	}
+}
+
+// getDelivery returns a delivery with the log of its attempts and the
+// response codes they got.
+func getDelivery(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := webhookParams(c)
+		if err != nil {
+			return err
+		}
+		deliveryId, err := strconv.Atoi(c.Param("deliveryId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid delivery ID")
+		}
+
+		ctx := c.Request().Context()
+		if _, err := manageableWebhook(ctx, storage, userId, id); err != nil {
+			return err
+		}
+		delivery, err := storage.WebhooksRepo.GetDelivery(ctx, id, deliveryId)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, delivery)
+	}
+}
+
+// redeliver queues a past delivery's payload to be sent again.
+func redeliver(storage *db.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userId, id, err := webhookParams(c)
+		if err != nil {
+			return err
+		}
+		deliveryId, err := strconv.Atoi(c.Param("deliveryId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid delivery ID")
+		}
+
+		ctx := c.Request().Context()
+		if _, err := manageableWebhook(ctx, storage, userId, id); err != nil {
+			return err
+		}
+		delivery, err := storage.WebhooksRepo.Redeliver(ctx, id, deliveryId)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusAccepted, delivery)
+	}
+}
+
+// manageableWebhook loads a webhook the user may manage: one of their own,
+// or one of an organization they own or maintain. Webhooks of organizations
+// the user is not in are reported as not found.
+func manageableWebhook(ctx context.Context, storage *db.Storage, userId, id int) (repo.Webhook, error) {
+	hook, err := storage.WebhooksRepo.GetWebhook(ctx, id)
+	if err != nil {
+		return repo.Webhook{}, err
+	}
+	if hook.UserID != nil && *hook.UserID == userId {
+		return hook, nil
+	}
+	if hook.OrgID == nil {
+		return repo.Webhook{}, repo.ErrWebhookNotFound
+	}
+	role, err := storage.OrgsRepo.GetRole(ctx, *hook.OrgID, userId)
+	if errors.Is(err, repo.ErrMemberNotFound) {
+		return repo.Webhook{}, repo.ErrWebhookNotFound
+	}
+	if err != nil {
+		return repo.Webhook{}, err
+	}
+	if !role.CanEdit() {
+		return repo.Webhook{}, errWebhookManagers
+	}
+	return hook, nil
+}
+
+var errWebhookManagers = apperr.New(apperr.ErrForbidden, "only owners and maintainers can manage webhooks")
+
+// webhookOrg resolves the organization in the path for one of its owners
+// or maintainers.
+func webhookOrg(ctx context.Context, orgs *repo.OrgsRepo, slug string, userId int) (repo.Org, error) {
+	org, role, err := orgMembership(ctx, orgs, slug, userId)
+	if err != nil {
+		return repo.Org{}, err
+	}
+	if !role.CanEdit() {
+		return repo.Org{}, errWebhookManagers
+	}
+	return org, nil
+}
+
+// webhookParams reads the caller and webhook ID of a request.
+func webhookParams(c echo.Context) (userId, id int, err error) {
+	if userId, err = strconv.Atoi(c.Request().Header.Get(UserIdHeader)); err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	if id, err = strconv.Atoi(c.Param("id")); err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook ID")
+	}
+	return userId, id, nil
+}
@@ -0,0 +1,210 @@
+package secrets
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Mode decides what happens to a snippet that contains secrets.
+type Mode string
+
+const (
+	ModeOff    Mode = "off"
+	ModeWarn   Mode = "warn"
+	ModeReject Mode = "reject"
+	ModeRedact Mode = "redact"
+)
+
+// ParseMode validates a mode name coming from configuration.
+func ParseMode(value string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(value)); mode {
+	case ModeOff, ModeWarn, ModeReject, ModeRedact:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown secret scan mode %q", value)
+}
+
+// Rule is a named regular expression detector.
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// Finding describes a single suspected secret in scanned content.
+type Finding struct {
+	Rule    string `json:"rule"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Preview string `json:"preview"`
+	start   int
+	end     int
+}
+
+var builtinRules = []Rule{
+	{"aws-access-key-id", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"aws-secret-access-key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`)},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,255}\b`)},
+	{"github-fine-grained-token", regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{82}\b`)},
+	{"private-key", regexp.MustCompile(`(?s)-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----.*?(?:-----END (?:[A-Z]+ )?PRIVATE KEY-----|\z)`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+}
+
+// Candidates for the entropy detector: long runs of base64/url-safe characters.
+var tokenPattern = regexp.MustCompile(`[A-Za-z0-9+/=_\-]{32,}`)
+
+const (
+	entropyRule      = "high-entropy-string"
+	entropyThreshold = 4.5
+)
+
+type Scanner struct {
+	mode  Mode
+	rules []Rule
+}
+
+// NewScanner builds a scanner with the built-in detectors plus any custom
+// rules, given as name to regular expression.
+func NewScanner(mode Mode, custom map[string]string) (*Scanner, error) {
+	rules := append([]Rule{}, builtinRules...)
+
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		pattern, err := regexp.Compile(custom[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret rule %q: %v", name, err)
+		}
+		rules = append(rules, Rule{Name: name, Pattern: pattern})
+	}
+
+	return &Scanner{mode: mode, rules: rules}, nil
+}
+
+func (s *Scanner) Mode() Mode {
+	return s.mode
+}
+
+// Scan reports every suspected secret in content, ordered by position.
+// Regex rules take precedence; the entropy detector only reports strings
+// that no rule already matched.
+func (s *Scanner) Scan(content string) []Finding {
+	if s.mode == ModeOff {
+		return nil
+	}
+
+	var findings []Finding
+	for _, rule := range s.rules {
+		for _, loc := range rule.Pattern.FindAllStringIndex(content, -1) {
+			findings = append(findings, newFinding(content, rule.Name, loc[0], loc[1]))
+		}
+	}
+
+	for _, loc := range tokenPattern.FindAllStringIndex(content, -1) {
+		if overlaps(findings, loc[0], loc[1]) {
+			continue
+		}
+		token := content[loc[0]:loc[1]]
+		if looksRandom(token) {
+			findings = append(findings, newFinding(content, entropyRule, loc[0], loc[1]))
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].start < findings[j].start
+	})
+	return findings
+}
+
+// Check scans content and applies the scanner's mode. It returns the content
+// to store, which is redacted in redact mode, the findings, and false when
+// the scanner is in reject mode and found something.
+func (s *Scanner) Check(content string) (string, []Finding, bool) {
+	findings := s.Scan(content)
+	if len(findings) == 0 {
+		return content, nil, true
+	}
+
+	switch s.mode {
+	case ModeReject:
+		return "", findings, false
+	case ModeRedact:
+		return Redact(content, findings), findings, true
+	}
+	return content, findings, true
+}
+
+// Redact replaces every finding in content, ordered by position, with a
+// marker naming the rule that matched it. Overlapping findings share the
+// marker of the first, which then covers all of them.
+func Redact(content string, findings []Finding) string {
+	var b strings.Builder
+	last := 0
+	for _, f := range findings {
+		if f.start < last {
+			// Overlaps the previous marker: extend what it covers.
+			last = max(last, f.end)
+			continue
+		}
+		b.WriteString(content[last:f.start])
+		b.WriteString("[REDACTED:" + f.Rule + "]")
+		last = f.end
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+func newFinding(content, rule string, start, end int) Finding {
+	line := strings.Count(content[:start], "\n") + 1
+	column := start - strings.LastIndex(content[:start], "\n")
+
+	// Cut the preview after four characters, not bytes, so that it stays
+	// valid UTF-8.
+	preview := content[start:end]
+	if runes := []rune(preview); len(runes) > 4 {
+		preview = string(runes[:4]) + "..."
+	}
+
+	return Finding{Rule: rule, Line: line, Column: column, Preview: preview, start: start, end: end}
+}
+
+func overlaps(findings []Finding, start, end int) bool {
+	for _, f := range findings {
+		if start < f.end && f.start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// looksRandom reports whether token has the character mix and Shannon
+// entropy of a generated credential rather than an identifier or a path.
+func looksRandom(token string) bool {
+	hasDigit := strings.ContainsAny(token, "0123456789")
+	hasUpper := strings.ToLower(token) != token
+	hasLower := strings.ToUpper(token) != token
+	if !hasDigit || !hasUpper || !hasLower {
+		return false
+	}
+	return shannonEntropy(token) >= entropyThreshold
+}
+
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	var entropy float64
+	length := float64(len(s))
+	for _, count := range counts {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
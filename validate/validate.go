@@ -0,0 +1,129 @@
+// Package validate checks request payloads against rules declared in
+// `validate` struct tags, e.g.
+//
+//	Name string `json:"name" validate:"required,max=100"`
+//
+// Supported rules are required, min=N and max=N (characters for strings,
+// elements for slices), maxbytes=N, email and username. Fields are reported
+// under their JSON names so clients can match errors to their input.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"snippetier/apperr"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Struct validates v, a struct or a pointer to one, and returns an
+// *apperr.ValidationError listing every invalid field, or nil.
+func Struct(v any) error {
+	return Fields(v).Err()
+}
+
+// Fields validates v like Struct but always returns the collected errors,
+// so that callers can add checks of their own, such as uniqueness, before
+// calling Err.
+func Fields(v any) *apperr.ValidationError {
+	invalid := &apperr.ValidationError{}
+	val := reflect.Indirect(reflect.ValueOf(v))
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		rules := field.Tag.Get("validate")
+		if rules == "" {
+			continue
+		}
+		name := jsonName(field)
+		for _, rule := range strings.Split(rules, ",") {
+			if message := check(val.Field(i), rule); message != "" {
+				invalid.Add(name, message)
+				break
+			}
+		}
+	}
+	return invalid
+}
+
+// check applies one rule to value and returns why it fails, or "".
+func check(value reflect.Value, rule string) string {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			if rule == "required" {
+				return "is required"
+			}
+			return ""
+		}
+		value = value.Elem()
+	}
+
+	name, arg, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required":
+		if value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "" || value.IsZero() {
+			return "is required"
+		}
+	case "min":
+		if n := length(value); n > 0 && n < number(rule, arg) {
+			return fmt.Sprintf("must be at least %s %s", arg, unit(value))
+		}
+	case "max":
+		if length(value) > number(rule, arg) {
+			return fmt.Sprintf("must be at most %s %s", arg, unit(value))
+		}
+	case "maxbytes":
+		if len(value.String()) > number(rule, arg) {
+			return fmt.Sprintf("must be at most %s bytes", arg)
+		}
+	case "email":
+		if s := value.String(); s != "" {
+			addr, err := mail.ParseAddress(s)
+			if err != nil || addr.Address != s {
+				return "must be a valid email address"
+			}
+		}
+	case "username":
+		if s := value.String(); s != "" && !usernamePattern.MatchString(s) {
+			return "may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit"
+		}
+	default:
+		panic("validate: unknown rule " + strconv.Quote(rule))
+	}
+	return ""
+}
+
+func length(value reflect.Value) int {
+	if value.Kind() == reflect.String {
+		return utf8.RuneCountInString(value.String())
+	}
+	return value.Len()
+}
+
+func unit(value reflect.Value) string {
+	if value.Kind() == reflect.String {
+		return "characters"
+	}
+	return "items"
+}
+
+func number(rule, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic("validate: bad rule " + strconv.Quote(rule))
+	}
+	return n
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
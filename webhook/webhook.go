@@ -0,0 +1,224 @@
+// Package webhook delivers queued snippet events to webhook receivers.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"snippetier/db/repo"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Headers set on every delivery. SignatureHeader carries "sha256=" and the
+// hex HMAC-SHA256 of the raw body keyed with the webhook's secret.
+const (
+	EventHeader     = "X-Snippetier-Event"
+	DeliveryHeader  = "X-Snippetier-Delivery"
+	SignatureHeader = "X-Snippetier-Signature-256"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it fails.
+	MaxAttempts = 8
+	// batchSize caps the deliveries sent per tick.
+	batchSize = 20
+	// requestTimeout bounds a single attempt, receiver included.
+	requestTimeout = 10 * time.Second
+	firstBackoff   = 30 * time.Second
+	maxBackoff     = 6 * time.Hour
+)
+
+// Sign returns the signature header value of body for secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ErrForbiddenAddress is returned for deliveries to an address webhooks
+// may not reach.
+var ErrForbiddenAddress = errors.New("webhook receivers must be on a public address")
+
+// Forbidden reports whether webhooks may not reach ip: loopback, link-local
+// addresses such as the cloud metadata service's 169.254.169.254, private
+// networks and addresses that are not a single host.
+func Forbidden(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// ForbiddenHost reports whether the host of a webhook URL is, on its face,
+// one webhooks may not reach: a forbidden IP address or localhost. Names
+// that resolve to forbidden addresses are refused when connecting.
+func ForbiddenHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && Forbidden(ip)
+}
+
+// guard refuses connections to forbidden addresses. It runs once the
+// receiver's name is resolved, so that a name pointing at, or rebound to,
+// an internal address cannot be used to reach it.
+func guard(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || Forbidden(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// Backoff returns how long to wait before retrying a delivery that has
+// failed attempts times: 30s, doubling each time, up to 6h.
+func Backoff(attempts int) time.Duration {
+	d := firstBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// Dispatcher periodically sends the deliveries that are due. The queue
+// lives in the database, so deliveries outlive restarts; one in flight
+// when the dispatcher stops is retried on the next start.
+type Dispatcher struct {
+	hooks    *repo.WebhooksRepo
+	client   *http.Client
+	interval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+func NewDispatcher(hooks *repo.WebhooksRepo, interval time.Duration) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		hooks:    hooks,
+		client:   newClient(),
+		interval: interval,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// newClient returns the client deliveries are sent with. It connects
+// directly, never through a proxy, and only to public addresses.
+func newClient() *http.Client {
+	dialer := &net.Dialer{Timeout: requestTimeout, Control: guard}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: requestTimeout, Transport: transport}
+}
+
+// Start runs the delivery loop in a background goroutine.
+func (d *Dispatcher) Start() {
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.dispatch()
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop aborts the deliveries in flight and waits for the loop to exit.
+func (d *Dispatcher) Stop() {
+	d.once.Do(d.cancel)
+	<-d.done
+}
+
+func (d *Dispatcher) dispatch() {
+	due, err := d.hooks.DueDeliveries(d.ctx, time.Now(), batchSize)
+	if err != nil {
+		if d.ctx.Err() == nil {
+			log.Println("Failed to retrieve due webhook deliveries:", err)
+		}
+		return
+	}
+	for _, delivery := range due {
+		if d.ctx.Err() != nil {
+			return
+		}
+		d.deliver(delivery)
+	}
+}
+
+// deliver makes one attempt at a delivery and records its outcome.
+func (d *Dispatcher) deliver(delivery repo.DueDelivery) {
+	start := time.Now()
+	code, err := d.post(delivery)
+	if d.ctx.Err() != nil {
+		// Shutting down: leave the delivery pending for the next start.
+		return
+	}
+
+	attempt := repo.Attempt{DurationMs: int(time.Since(start).Milliseconds())}
+	if code != 0 {
+		attempt.StatusCode = &code
+	}
+	if err != nil {
+		msg := err.Error()
+		attempt.Error = &msg
+	}
+	succeeded := err == nil && code >= 200 && code < 300
+
+	var retryAt *time.Time
+	if attempts := delivery.Attempts + 1; !succeeded && attempts < MaxAttempts {
+		at := time.Now().Add(Backoff(attempts))
+		retryAt = &at
+	}
+	if err := d.hooks.RecordAttempt(context.Background(), delivery.ID, attempt, succeeded, retryAt); err != nil {
+		log.Println("Failed to record webhook delivery attempt:", err)
+	}
+}
+
+// post sends a delivery and returns the receiver's status code, or an error
+// if no response was received.
+func (d *Dispatcher) post(delivery repo.DueDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Snippetier-Webhook")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,290 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"snippetier/db/repo"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testSecret = "s3cret"
+
+// received is a request a test receiver got.
+type received struct {
+	event, delivery, signature string
+	body                       []byte
+}
+
+// receiver is a webhook receiver answering with the queued status codes,
+// then 200.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	codes    []int
+	requests []received
+}
+
+func newReceiver(t *testing.T, codes ...int) *receiver {
+	r := &receiver{codes: codes}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, received{
+			event:     req.Header.Get(EventHeader),
+			delivery:  req.Header.Get(DeliveryHeader),
+			signature: req.Header.Get(SignatureHeader),
+			body:      body,
+		})
+		code := http.StatusOK
+		if len(r.codes) > 0 {
+			code, r.codes = r.codes[0], r.codes[1:]
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]received(nil), r.requests...)
+}
+
+// setup opens a fresh database with a webhook on a user's snippets posting
+// to url, and creates a snippet so that one delivery is queued. It returns
+// the database, the webhooks repo, the webhook and the queued delivery.
+func setup(t *testing.T, url string) (*sql.DB, *repo.WebhooksRepo, repo.Webhook, repo.Delivery) {
+	t.Helper()
+	ctx := context.Background()
+	schema, err := os.ReadFile("../db/sql/init.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	conn := repo.NewConn(db)
+
+	user, err := repo.NewUsersRepo(conn, 0).CreateUser(ctx, "owner", "owner@example.com", "Owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := repo.NewWebhooksRepo(conn, 0)
+	hook, err := hooks.CreateWebhook(ctx, repo.Webhook{
+		UserID:    &user.ID,
+		URL:       url,
+		Events:    []string{repo.EventSnippetCreated},
+		Active:    true,
+		Secret:    testSecret,
+		CreatedBy: user.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.NewSnippetsRepo(conn, 0).SaveSnippet(ctx, user.ID, repo.Snippet{Name: "hello", Content: "echo hello"}); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := hooks.GetDeliveries(ctx, hook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("queued %d deliveries, want 1", len(deliveries))
+	}
+	return db, hooks, hook, deliveries[0]
+}
+
+// newTestDispatcher returns a dispatcher that may reach the loopback test
+// receiver, which the real client refuses.
+func newTestDispatcher(t *testing.T, hooks *repo.WebhooksRepo, r *receiver) *Dispatcher {
+	d := NewDispatcher(hooks, time.Hour)
+	d.client = r.Client()
+	t.Cleanup(d.cancel)
+	return d
+}
+
+func getDelivery(t *testing.T, hooks *repo.WebhooksRepo, hook repo.Webhook, id int) repo.Delivery {
+	t.Helper()
+	delivery, err := hooks.GetDelivery(context.Background(), hook.ID, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func TestDeliverySigned(t *testing.T) {
+	r := newReceiver(t)
+	_, hooks, hook, delivery := setup(t, r.URL)
+	newTestDispatcher(t, hooks, r).dispatch()
+
+	requests := r.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if string(req.body) != delivery.Payload {
+		t.Errorf("body = %s, want %s", req.body, delivery.Payload)
+	}
+	if !hmac.Equal([]byte(req.signature), []byte(Sign(testSecret, req.body))) {
+		t.Errorf("signature %q does not match the body", req.signature)
+	}
+	if req.signature == Sign("wrong", req.body) {
+		t.Error("signature does not depend on the secret")
+	}
+	if req.event != repo.EventSnippetCreated || req.delivery != strconv.Itoa(delivery.ID) {
+		t.Errorf("event, delivery headers = %q, %q, want %q, %d", req.event, req.delivery, repo.EventSnippetCreated, delivery.ID)
+	}
+
+	got := getDelivery(t, hooks, hook, delivery.ID)
+	if got.Status != repo.DeliveryDelivered || got.Attempts != 1 || got.DeliveredAt == nil {
+		t.Errorf("status %s, attempts %d, delivered at %v; want delivered, 1, set", got.Status, got.Attempts, got.DeliveredAt)
+	}
+	if len(got.Log) != 1 || got.Log[0].StatusCode == nil || *got.Log[0].StatusCode != http.StatusOK || got.Log[0].Error != nil {
+		t.Errorf("delivery log = %+v, want one attempt answered with 200", got.Log)
+	}
+}
+
+func TestDeliveryRetriedWithBackoff(t *testing.T) {
+	r := newReceiver(t, http.StatusInternalServerError)
+	db, hooks, hook, delivery := setup(t, r.URL)
+	d := newTestDispatcher(t, hooks, r)
+
+	before := time.Now().UTC()
+	d.dispatch()
+	got := getDelivery(t, hooks, hook, delivery.ID)
+	if got.Status != repo.DeliveryPending || got.Attempts != 1 || got.NextAttemptAt == nil {
+		t.Fatalf("after a failed attempt: status %s, attempts %d, next attempt %v; want pending, 1, set", got.Status, got.Attempts, got.NextAttemptAt)
+	}
+	next, err := time.Parse(time.RFC3339, *got.NextAttemptAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait := next.Sub(before); wait < Backoff(1)-time.Second || wait > Backoff(1)+5*time.Second {
+		t.Errorf("retried after %v, want about %v", wait, Backoff(1))
+	}
+
+	// Not due yet.
+	d.dispatch()
+	if n := len(r.received()); n != 1 {
+		t.Fatalf("receiver got %d requests before the retry was due, want 1", n)
+	}
+
+	// Let the backoff pass.
+	if _, err := db.Exec("UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ?", before.Add(-time.Second).Format(time.DateTime), delivery.ID); err != nil {
+		t.Fatal(err)
+	}
+	d.dispatch()
+	got = getDelivery(t, hooks, hook, delivery.ID)
+	if got.Status != repo.DeliveryDelivered || got.Attempts != 2 {
+		t.Errorf("after the retry: status %s, attempts %d; want delivered, 2", got.Status, got.Attempts)
+	}
+	var codes []int
+	for _, a := range got.Log {
+		if a.StatusCode != nil {
+			codes = append(codes, *a.StatusCode)
+		}
+	}
+	if len(codes) != 2 || codes[0] != http.StatusInternalServerError || codes[1] != http.StatusOK {
+		t.Errorf("delivery log status codes = %v, want [500 200]", codes)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		10: 4*time.Hour + 16*time.Minute,
+		11: 6 * time.Hour,
+		50: 6 * time.Hour,
+	} {
+		if got := Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestRedelivery(t *testing.T) {
+	r := newReceiver(t)
+	_, hooks, hook, delivery := setup(t, r.URL)
+	d := newTestDispatcher(t, hooks, r)
+	d.dispatch()
+
+	redelivery, err := hooks.Redeliver(context.Background(), hook.ID, delivery.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redelivery.RedeliveryOf == nil || *redelivery.RedeliveryOf != delivery.ID || redelivery.Status != repo.DeliveryPending {
+		t.Fatalf("redelivery = %+v, want a pending redelivery of %d", redelivery, delivery.ID)
+	}
+	d.dispatch()
+
+	requests := r.received()
+	if len(requests) != 2 {
+		t.Fatalf("receiver got %d requests, want 2", len(requests))
+	}
+	if string(requests[1].body) != string(requests[0].body) {
+		t.Errorf("redelivered body %s differs from the original %s", requests[1].body, requests[0].body)
+	}
+	if requests[1].delivery != strconv.Itoa(redelivery.ID) {
+		t.Errorf("redelivery header = %q, want %d", requests[1].delivery, redelivery.ID)
+	}
+	if got := getDelivery(t, hooks, hook, redelivery.ID); got.Status != repo.DeliveryDelivered || len(got.Log) != 1 {
+		t.Errorf("redelivery status %s with %d attempts logged, want delivered with 1", got.Status, len(got.Log))
+	}
+}
+
+func TestPrivateReceiverRefused(t *testing.T) {
+	r := newReceiver(t)
+	_, hooks, hook, delivery := setup(t, r.URL)
+	d := NewDispatcher(hooks, time.Hour)
+	t.Cleanup(d.cancel)
+	d.dispatch()
+
+	if n := len(r.received()); n != 0 {
+		t.Errorf("loopback receiver got %d requests, want none", n)
+	}
+	got := getDelivery(t, hooks, hook, delivery.ID)
+	if len(got.Log) != 1 || got.Log[0].Error == nil {
+		t.Fatalf("delivery log = %+v, want one failed attempt", got.Log)
+	}
+}
+
+func TestForbidden(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "::1", "localhost", "api.localhost", "169.254.169.254", "10.1.2.3", "172.16.0.1", "192.168.1.1", "fd00::1", "0.0.0.0", "[::ffff:127.0.0.1]"} {
+		if !ForbiddenHost(host) {
+			t.Errorf("ForbiddenHost(%q) = false, want true", host)
+		}
+	}
+	for _, host := range []string{"example.com", "8.8.8.8", "2001:4860:4860::8888", "172.32.0.1"} {
+		if ForbiddenHost(host) {
+			t.Errorf("ForbiddenHost(%q) = true, want false", host)
+		}
+	}
+	if err := guard("tcp", net.JoinHostPort("169.254.169.254", "80"), nil); err != ErrForbiddenAddress {
+		t.Errorf("guard(169.254.169.254) = %v, want ErrForbiddenAddress", err)
+	}
+	if err := guard("tcp", net.JoinHostPort("93.184.216.34", "443"), nil); err != nil {
+		t.Errorf("guard(93.184.216.34) = %v, want nil", err)
+	}
+}